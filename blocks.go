@@ -0,0 +1,207 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// A Block is the sequence of trips operated by the same vehicle on a
+// service day
+type Block struct {
+	ID string
+
+	// the trips of the block, ordered by their start time
+	Trips []BlockTrip
+}
+
+// A BlockTrip is a single run of a trip in a block. Trips with
+// frequencies have one BlockTrip per run.
+type BlockTrip struct {
+	Trip *gtfs.Trip
+
+	// departure at the first and arrival at the last stop, in seconds
+	// since midnight of the service day
+	Start int
+	End   int
+}
+
+// Blocks returns the blocks of all trips with a block_id which are active
+// on the date, ordered by block ID
+func (feed *Feed) Blocks(date gtfs.Date) []*Block {
+	trips := feed.blockTrips()
+	ret := make([]*Block, 0)
+
+	for _, id := range sortedMapKeys(trips) {
+		b := newBlock(id, trips[id], func(s *gtfs.Service) bool { return s.IsActiveOn(date) })
+		if len(b.Trips) > 0 {
+			ret = append(ret, b)
+		}
+	}
+
+	return ret
+}
+
+// ValidateBlocks checks that the trips of each block do not overlap in
+// time on any date, and that each trip starts at the stop (or a stop of
+// the station) where the previous trip of the block ended, or at most
+// maxDist meters away from it. Each violation is only reported once,
+// for the first date it occurs on.
+func (feed *Feed) ValidateBlocks(maxDist float64) []error {
+	ret := make([]error, 0)
+	trips := feed.blockTrips()
+
+	for _, id := range sortedMapKeys(trips) {
+		services := make(map[*gtfs.Service]bool)
+		var first, last gtfs.Date
+		for _, t := range trips[id] {
+			if t.Service == nil || services[t.Service] {
+				continue
+			}
+			services[t.Service] = true
+			if d := t.Service.GetFirstActiveDate(); !d.IsEmpty() && (first.IsEmpty() || d.GetTime().Before(first.GetTime())) {
+				first = d
+			}
+			if d := t.Service.GetLastActiveDate(); !d.IsEmpty() && (last.IsEmpty() || d.GetTime().After(last.GetTime())) {
+				last = d
+			}
+		}
+
+		if first.IsEmpty() || last.IsEmpty() {
+			continue
+		}
+
+		// the block only has to be checked once per combination of
+		// active services
+		checked := make(map[string]bool)
+		reported := make(map[string]bool)
+
+		for d := first; !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+			active := make(map[*gtfs.Service]bool, len(services))
+			var key strings.Builder
+			for _, t := range trips[id] {
+				if t.Service != nil && t.Service.IsActiveOn(d) {
+					active[t.Service] = true
+					key.WriteString("1")
+				} else {
+					key.WriteString("0")
+				}
+			}
+
+			if checked[key.String()] {
+				continue
+			}
+			checked[key.String()] = true
+
+			b := newBlock(id, trips[id], func(s *gtfs.Service) bool { return active[s] })
+
+			for _, e := range b.check(maxDist) {
+				if !reported[e.Error()] {
+					reported[e.Error()] = true
+					ret = append(ret, fmt.Errorf("%s on %s.", e.Error(), d.String()))
+				}
+			}
+		}
+	}
+
+	return ret
+}
+
+// blockTrips returns the trips of each block, ordered by trip ID
+func (feed *Feed) blockTrips() map[string][]*gtfs.Trip {
+	ret := make(map[string][]*gtfs.Trip)
+
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		if t.BlockID == nil || len(*t.BlockID) == 0 || t.NumStopTimes() == 0 {
+			continue
+		}
+		ret[*t.BlockID] = append(ret[*t.BlockID], t)
+	}
+
+	return ret
+}
+
+// newBlock builds a block from the trips with an active service
+func newBlock(id string, trips []*gtfs.Trip, active func(*gtfs.Service) bool) *Block {
+	b := &Block{ID: id, Trips: make([]BlockTrip, 0)}
+
+	for _, t := range trips {
+		if t.Service == nil || !active(t.Service) {
+			continue
+		}
+
+		first, last := t.GetStopTime(0), t.GetStopTime(t.NumStopTimes()-1)
+
+		start := first.DepartureTime
+		if start.Empty() {
+			start = first.ArrivalTime
+		}
+		end := last.ArrivalTime
+		if end.Empty() {
+			end = last.DepartureTime
+		}
+		if start.Empty() || end.Empty() {
+			continue
+		}
+
+		for _, offset := range tripStartOffsets(t) {
+			b.Trips = append(b.Trips, BlockTrip{t, start.SecondsSinceMidnight() + offset, end.SecondsSinceMidnight() + offset})
+		}
+	}
+
+	sort.SliceStable(b.Trips, func(i, j int) bool {
+		if b.Trips[i].Start != b.Trips[j].Start {
+			return b.Trips[i].Start < b.Trips[j].Start
+		}
+		return b.Trips[i].End < b.Trips[j].End
+	})
+
+	return b
+}
+
+// check returns the overlaps and gaps between consecutive trips of the
+// block, without the date
+func (b *Block) check(maxDist float64) []error {
+	ret := make([]error, 0)
+
+	for i := 1; i < len(b.Trips); i++ {
+		prev, cur := &b.Trips[i-1], &b.Trips[i]
+
+		if cur.Start < prev.End {
+			ret = append(ret, fmt.Errorf("Trips %s (%s-%s) and %s (%s-%s) of block %s overlap", prev.Trip.ID, fmtDayTime(prev.Start), fmtDayTime(prev.End), cur.Trip.ID, fmtDayTime(cur.Start), fmtDayTime(cur.End), b.ID))
+			continue
+		}
+
+		from := prev.Trip.GetStopTime(prev.Trip.NumStopTimes() - 1).Stop
+		to := cur.Trip.GetStopTime(0).Stop
+		if from == nil || to == nil || from == to {
+			continue
+		}
+		if st := stationOf(from); st != nil && st == stationOf(to) {
+			continue
+		}
+		if !from.HasLatLon() || !to.HasLatLon() {
+			continue
+		}
+
+		if d := gtfs.Haversine(float64(from.Lat), float64(from.Lon), float64(to.Lat), float64(to.Lon)); d > maxDist {
+			ret = append(ret, fmt.Errorf("Trip %s of block %s ends at stop %s, but the next trip %s starts at stop %s, %.0f m away", prev.Trip.ID, b.ID, from.ID, cur.Trip.ID, to.ID, d))
+		}
+	}
+
+	return ret
+}
+
+// fmtDayTime formats seconds since midnight as HH:MM:SS
+func fmtDayTime(s int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, (s/60)%60, s%60)
+}
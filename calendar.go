@@ -0,0 +1,164 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// MinimizeService rewrites a service with the smallest number of
+// calendar.txt and calendar_dates.txt rows which yields the same active
+// days. Services which are never active are not changed.
+func MinimizeService(s *gtfs.Service) {
+	first, last := s.GetFirstActiveDate(), s.GetLastActiveDate()
+	if first.IsEmpty() || last.IsEmpty() {
+		return
+	}
+
+	active := make([]bool, 0)
+	weekdays := make([]uint8, 0)
+	numActive := 0
+	activeDays := uint8(0)
+
+	wd := uint8(first.GetTime().Weekday())
+	for d := first; !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+		a := s.IsActiveOn(d)
+		active = append(active, a)
+		weekdays = append(weekdays, wd)
+		if a {
+			numActive++
+			activeDays |= 1 << wd
+		}
+		wd = (wd + 1) % 7
+	}
+
+	// without calendar.txt, every active day is an exception
+	bestRows, bestDaymap, bestStart, bestEnd := numActive, uint8(0), -1, -1
+
+	// with calendar.txt, active days of the daymap inside the range need
+	// no exception, inactive ones do. The best range is the one with the
+	// maximum difference between both, weekdays without any active day
+	// never help.
+	for daymap := uint8(1); daymap < 128; daymap++ {
+		if daymap&^activeDays != 0 {
+			continue
+		}
+
+		sum, start := 0, -1
+		best, bs, be := 0, -1, -1
+
+		for i, a := range active {
+			if daymap&(1<<weekdays[i]) == 0 {
+				continue
+			}
+			if a {
+				if start < 0 {
+					start = i
+				}
+				sum++
+				if sum > best {
+					best, bs, be = sum, start, i
+				}
+			} else if start >= 0 {
+				sum--
+				if sum <= 0 {
+					sum, start = 0, -1
+				}
+			}
+		}
+
+		if rows := 1 + numActive - best; bs >= 0 && rows < bestRows {
+			bestRows, bestDaymap, bestStart, bestEnd = rows, daymap, bs, be
+		}
+	}
+
+	s.Daymap = bestDaymap
+	s.StartDate, s.EndDate = gtfs.Date{}, gtfs.Date{}
+	s.Exceptions = make(map[gtfs.Date]bool)
+
+	i := 0
+	for d := first; !d.GetTime().After(last.GetTime()); d = d.GetOffsettedDate(1) {
+		if i == bestStart {
+			s.StartDate = d
+		}
+		if i == bestEnd {
+			s.EndDate = d
+		}
+
+		regular := i >= bestStart && i <= bestEnd && bestDaymap&(1<<weekdays[i]) != 0
+		if active[i] != regular {
+			s.Exceptions[d] = active[i]
+		}
+		i++
+	}
+}
+
+// NormalizeServices minimizes all services with MinimizeService and merges
+// services which are active on the same days into the one with the
+// smallest ID. The trips of merged services are repointed. The number of
+// removed services is returned.
+func (feed *Feed) NormalizeServices() int {
+	byKey := make(map[string]*gtfs.Service)
+	merged := make(map[*gtfs.Service]*gtfs.Service)
+
+	for _, id := range sortedMapKeys(feed.Services) {
+		s := feed.Services[id]
+		MinimizeService(s)
+
+		key := serviceKey(s)
+		if s.GetFirstActiveDate().IsEmpty() {
+			key = "inactive"
+		}
+
+		if m, ok := byKey[key]; ok {
+			merged[s] = m
+			continue
+		}
+		byKey[key] = s
+	}
+
+	for _, t := range feed.Trips {
+		if m, ok := merged[t.Service]; ok {
+			t.Service = m
+		}
+	}
+
+	for s := range merged {
+		feed.DeleteService(s.ID)
+	}
+
+	return len(merged)
+}
+
+// serviceKey returns a key for the encoding of a service
+func serviceKey(s *gtfs.Service) string {
+	dates := make([]gtfs.Date, 0, len(s.Exceptions))
+	for d := range s.Exceptions {
+		dates = append(dates, d)
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].GetTime().Before(dates[j].GetTime()) })
+
+	var b strings.Builder
+	b.WriteByte(s.Daymap)
+	b.WriteString(s.StartDate.String())
+	b.WriteByte(':')
+	b.WriteString(s.EndDate.String())
+	for _, d := range dates {
+		b.WriteByte(':')
+		b.WriteString(d.String())
+		if s.Exceptions[d] {
+			b.WriteByte('+')
+		} else {
+			b.WriteByte('-')
+		}
+	}
+
+	return b.String()
+}
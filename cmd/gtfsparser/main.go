@@ -0,0 +1,530 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+// Command gtfsparser parses, validates, filters and converts GTFS feeds.
+//
+// Usage:
+//
+//	gtfsparser validate [flags] <feed>
+//	gtfsparser stats [flags] <feed>
+//	gtfsparser filter [flags] -o <out.zip> <feed>
+//	gtfsparser convert [flags] -o <out.zip|out.snap> <feed>
+//	gtfsparser serve [flags] -addr <addr> <feed>
+//	gtfsparser geojson [flags] -layer <stops|shapes|routes|trips> <feed>
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thecodinglab/gtfsparser"
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+const usage = `usage: gtfsparser <command> [flags] <feed>
+
+commands:
+  validate  parse the feed and report errors and warnings
+  stats     print feed statistics
+  filter    write a filtered feed to a GTFS ZIP
+  convert   write the (cleaned) feed to a GTFS ZIP or a snapshot
+  serve     serve the feed as a read-only JSON API
+  geojson   export stops, shapes, routes or trips as GeoJSON
+
+Run 'gtfsparser <command> -h' for the flags of a command.
+`
+
+// parseFlags holds the flags shared by all commands
+type parseFlags struct {
+	opts        gtfsparser.ParseOptions
+	dropErrs    bool
+	defOnErrs   bool
+	bothOnErrs  bool
+	dateStart   string
+	dateEnd     string
+	polygonFile string
+	mot         string
+	motNeg      string
+	genParents  float64
+	checkSpeeds bool
+	snapStops   bool
+	normCal     bool
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var e error
+
+	switch os.Args[1] {
+	case "validate":
+		e = validate(os.Args[2:])
+	case "stats":
+		e = stats(os.Args[2:])
+	case "filter":
+		e = convert("filter", os.Args[2:])
+	case "convert":
+		e = convert("convert", os.Args[2:])
+	case "serve":
+		e = serve(os.Args[2:])
+	case "geojson":
+		e = geoJSON(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "error: "+e.Error())
+		os.Exit(1)
+	}
+}
+
+func newFlagSet(cmd string, pf *parseFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: gtfsparser %s [flags] <feed>\n\nflags:\n", cmd)
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&pf.dropErrs, "D", false, "drop erroneous entities")
+	fs.BoolVar(&pf.defOnErrs, "e", false, "use default values for erroneous optional fields")
+	fs.BoolVar(&pf.bothOnErrs, "De", false, "same as -D -e")
+	fs.BoolVar(&pf.opts.DryRun, "dry-run", false, "only validate, do not keep the parsed entities")
+	fs.BoolVar(&pf.opts.CheckNullCoordinates, "check-null-coords", false, "treat 0,0 coordinates as errors")
+	fs.StringVar(&pf.opts.EmptyStringRepl, "empty-str-repl", "", "replacement for empty required strings")
+	fs.BoolVar(&pf.opts.ZipFix, "zip-fix", false, "search for GTFS files in subfolders of the ZIP")
+	fs.BoolVar(&pf.opts.ShowWarnings, "warnings", false, "print warnings to stderr")
+	fs.BoolVar(&pf.opts.DropShapes, "drop-shapes", false, "do not parse shapes")
+	fs.BoolVar(&pf.opts.KeepAddFlds, "keep-add-flds", false, "keep additional, non-standard fields")
+	fs.BoolVar(&pf.opts.UseStandardRouteTypes, "standard-route-types", false, "map extended route types to basic route types")
+	fs.BoolVar(&pf.opts.AssumeCleanCsv, "assume-clean-csv", false, "use the fast CSV parser without quoting checks")
+	fs.BoolVar(&pf.opts.SinglePass, "single-pass", false, "read stop_times.txt and shapes.txt in a single pass")
+	fs.BoolVar(&pf.opts.CompactStopTimes, "compact-stop-times", false, "store stop times compactly")
+	fs.BoolVar(&pf.opts.InternStrings, "intern-strings", false, "deduplicate repeated strings")
+	fs.IntVar(&pf.opts.Workers, "workers", 0, "number of files parsed concurrently")
+	fs.StringVar(&pf.dateStart, "date-start", "", "drop service before this date (YYYYMMDD)")
+	fs.StringVar(&pf.dateEnd, "date-end", "", "drop service after this date (YYYYMMDD)")
+	fs.StringVar(&pf.polygonFile, "polygon", "", "GeoJSON or WKT file with (multi-)polygons, only keep stops inside them")
+	fs.BoolVar(&pf.opts.ClipToPolygons, "clip", false, "clip shapes to -polygon and cut trips leaving it")
+	fs.StringVar(&pf.mot, "mot", "", "comma-separated route types to keep")
+	fs.StringVar(&pf.motNeg, "mot-neg", "", "comma-separated route types to drop")
+	fs.BoolVar(&pf.checkSpeeds, "check-speeds", false, "check for implausible speeds between consecutive stops")
+	fs.Float64Var(&pf.genParents, "gen-parents", 0, "create parent stations for same-named stops within this distance (meters)")
+	fs.BoolVar(&pf.normCal, "normalize-calendar", false, "encode services with the fewest calendar rows and merge equal services")
+	fs.BoolVar(&pf.snapStops, "snap-stops", false, "set shape_dist_traveled of stop times by matching stops in order to shapes")
+
+	return fs
+}
+
+// hasFilter returns true if any filter flag was given
+func (pf *parseFlags) hasFilter() bool {
+	return len(pf.dateStart) > 0 || len(pf.dateEnd) > 0 || len(pf.polygonFile) > 0 || len(pf.mot) > 0 || len(pf.motNeg) > 0
+}
+
+// parseOpts returns the ParseOptions for the given flags
+func (pf *parseFlags) parseOpts() (gtfsparser.ParseOptions, error) {
+	opts := pf.opts
+	opts.DropErroneous = pf.dropErrs || pf.bothOnErrs
+	opts.UseDefValueOnError = pf.defOnErrs || pf.bothOnErrs
+
+	var e error
+
+	if opts.DateFilterStart, e = parseDate(pf.dateStart); e != nil {
+		return opts, e
+	}
+	if opts.DateFilterEnd, e = parseDate(pf.dateEnd); e != nil {
+		return opts, e
+	}
+
+	opts.PolygonFilter = make([]gtfsparser.Polygon, 0)
+	if len(pf.polygonFile) > 0 {
+		if opts.PolygonFilter, e = gtfsparser.LoadPolygons(pf.polygonFile); e != nil {
+			return opts, e
+		}
+	}
+
+	if pf.checkSpeeds {
+		opts.SpeedLimits = gtfsparser.DefaultSpeedLimits()
+	}
+
+	if opts.MOTFilter, e = parseMOTs(pf.mot); e != nil {
+		return opts, e
+	}
+	if opts.MOTFilterNeg, e = parseMOTs(pf.motNeg); e != nil {
+		return opts, e
+	}
+
+	return opts, nil
+}
+
+func parseDate(s string) (gtfs.Date, error) {
+	if len(s) == 0 {
+		return gtfs.Date{}, nil
+	}
+
+	t, e := time.Parse("20060102", s)
+	if e != nil {
+		return gtfs.Date{}, fmt.Errorf("invalid date %q, expected YYYYMMDD", s)
+	}
+
+	return gtfs.GetGtfsDateFromTime(t), nil
+}
+
+func parseMOTs(s string) (map[int16]bool, error) {
+	ret := make(map[int16]bool)
+	if len(s) == 0 {
+		return ret, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		t, e := strconv.ParseInt(strings.TrimSpace(v), 10, 16)
+		if e != nil {
+			return nil, fmt.Errorf("invalid route type %q", v)
+		}
+		ret[int16(t)] = true
+	}
+
+	return ret, nil
+}
+
+// parse parses the feed given as the single positional argument
+func parse(fs *flag.FlagSet, pf *parseFlags, warn func(error)) (*gtfsparser.Feed, error) {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts, e := pf.parseOpts()
+	if e != nil {
+		return nil, e
+	}
+
+	feed := gtfsparser.NewFeed()
+	feed.SetParseOpts(opts)
+	if warn != nil {
+		feed.SetWarningHandler(warn)
+	}
+
+	if e := feed.Parse(fs.Arg(0)); e != nil {
+		return feed, e
+	}
+
+	if pf.genParents > 0 {
+		feed.GenerateParentStations(pf.genParents)
+	}
+
+	if pf.snapStops {
+		feed.SnapStopsToShapes()
+	}
+
+	if pf.normCal {
+		feed.NormalizeServices()
+	}
+
+	return feed, nil
+}
+
+func validate(args []string) error {
+	pf := &parseFlags{}
+	fs := newFlagSet("validate", pf)
+	maxShapeDist := fs.Float64("max-stop-shape-dist", 0, "warn about stops farther than this from their trip's shape (meters)")
+	checkBlocks := fs.Bool("check-blocks", false, "warn about overlapping or disconnected trips in blocks")
+	maxBlockDist := fs.Float64("max-block-dist", 100, "maximum distance between the end and the start of consecutive trips in a block (meters)")
+	checkCalendar := fs.Bool("check-calendar", false, "warn about days without service, inactive services and gaps to feed_info.txt")
+	expiryDays := fs.Int("expiry-days", 7, "with -check-calendar, warn if the feed expires within this many days")
+	fs.Parse(args)
+
+	if pf.opts.DryRun {
+		if e := dryRunConflict(fs, "gen-parents", "snap-stops", "normalize-calendar", "max-stop-shape-dist", "check-blocks", "check-calendar"); e != nil {
+			return e
+		}
+	}
+
+	numWarns := 0
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	feed, e := parse(fs, pf, func(w error) {
+		numWarns++
+		fmt.Fprintln(out, "WARNING: "+w.Error())
+	})
+
+	if e != nil {
+		fmt.Fprintln(out, "ERROR: "+e.Error())
+		fmt.Fprintf(out, "\n%d warnings, feed is invalid\n", numWarns)
+		out.Flush()
+		os.Exit(1)
+	}
+
+	for _, w := range gtfsparser.NewStationGraph(feed).CheckReachability(gtfsparser.PathOptions{}) {
+		numWarns++
+		fmt.Fprintln(out, "WARNING: "+w.Error())
+	}
+
+	if *maxShapeDist > 0 {
+		for _, w := range feed.CheckStopShapeDistances(*maxShapeDist) {
+			numWarns++
+			fmt.Fprintln(out, "WARNING: "+w.Error())
+		}
+	}
+
+	if *checkBlocks {
+		for _, w := range feed.ValidateBlocks(*maxBlockDist) {
+			numWarns++
+			fmt.Fprintln(out, "WARNING: "+w.Error())
+		}
+	}
+
+	if *checkCalendar {
+		for _, w := range feed.CheckCalendarCoverage(gtfs.GetGtfsDateFromTime(time.Now()), *expiryDays) {
+			numWarns++
+			fmt.Fprintln(out, "WARNING: "+w.Error())
+		}
+	}
+
+	printErrStats(out, &feed.ErrorStats)
+	fmt.Fprintf(out, "\n%d warnings, feed is valid\n", numWarns)
+
+	return nil
+}
+
+// dryRunConflict returns an error if one of the named flags was given,
+// they need the entities which are not kept with -dry-run
+func dryRunConflict(fs *flag.FlagSet, names ...string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for _, name := range names {
+		if given[name] {
+			return errors.New("-dry-run cannot be used with -" + name)
+		}
+	}
+
+	return nil
+}
+
+func printErrStats(w io.Writer, s *gtfsparser.ErrStats) {
+	dropped := []struct {
+		name string
+		n    int
+	}{
+		{"agencies", s.DroppedAgencies},
+		{"stops", s.DroppedStops},
+		{"routes", s.DroppedRoutes},
+		{"trips", s.DroppedTrips},
+		{"stop times", s.DroppedStopTimes},
+		{"frequencies", s.DroppedFrequencies},
+		{"services", s.DroppedServices},
+		{"fare attributes", s.DroppedFareAttributes},
+		{"fare rules", s.DroppedFareAttributeRules},
+		{"attributions", s.DroppedAttributions},
+		{"shapes", s.DroppedShapes},
+		{"levels", s.DroppedLevels},
+		{"pathways", s.DroppedPathways},
+		{"transfers", s.DroppedTransfers},
+		{"feed infos", s.DroppedFeedInfos},
+		{"translations", s.DroppedTranslations},
+	}
+
+	for _, d := range dropped {
+		if d.n > 0 {
+			fmt.Fprintf(w, "dropped %d erroneous %s\n", d.n, d.name)
+		}
+	}
+}
+
+func stats(args []string) error {
+	pf := &parseFlags{}
+	fs := newFlagSet("stats", pf)
+	format := fs.String("format", "md", "output format, json or md")
+	fs.Parse(args)
+
+	if *format != "json" && *format != "md" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	if pf.opts.DryRun {
+		return errors.New("-dry-run cannot be used with stats")
+	}
+
+	feed, e := parse(fs, pf, nil)
+	if e != nil {
+		return e
+	}
+
+	s := feed.Stats()
+
+	if *format == "json" {
+		data, e := s.JSON()
+		if e != nil {
+			return e
+		}
+		_, e = fmt.Fprintln(os.Stdout, string(data))
+		return e
+	}
+
+	return s.WriteMarkdown(os.Stdout)
+}
+
+func convert(cmd string, args []string) error {
+	pf := &parseFlags{}
+	fs := newFlagSet(cmd, pf)
+	out := fs.String("o", "", "output file, a snapshot is written if it ends with .snap")
+	fs.Parse(args)
+
+	if len(*out) == 0 {
+		return errors.New("no output file given (-o)")
+	}
+
+	if cmd == "filter" && !pf.hasFilter() {
+		return errors.New("no filter given (-date-start, -date-end, -polygon, -mot, -mot-neg)")
+	}
+
+	if pf.opts.DryRun {
+		return errors.New("-dry-run cannot be used with " + cmd)
+	}
+
+	feed, e := parse(fs, pf, nil)
+	if e != nil {
+		return e
+	}
+
+	f, e := os.Create(*out)
+	if e != nil {
+		return e
+	}
+
+	w := bufio.NewWriter(f)
+
+	if strings.HasSuffix(*out, ".snap") {
+		e = feed.SaveSnapshot(w)
+	} else {
+		e = feed.WriteZip(w)
+	}
+
+	if e == nil {
+		e = w.Flush()
+	}
+
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+
+	return e
+}
+
+func serve(args []string) error {
+	pf := &parseFlags{}
+	fs := newFlagSet("serve", pf)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	fs.Parse(args)
+
+	if pf.opts.DryRun {
+		return errors.New("-dry-run cannot be used with serve")
+	}
+
+	feed, e := parse(fs, pf, nil)
+	if e != nil {
+		return e
+	}
+
+	fmt.Fprintf(os.Stderr, "Serving %d stops, %d routes and %d trips on %s\n", len(feed.Stops), len(feed.Routes), len(feed.Trips), *addr)
+
+	return http.ListenAndServe(*addr, gtfsparser.NewHandler(feed))
+}
+
+func geoJSON(args []string) error {
+	pf := &parseFlags{}
+	fs := newFlagSet("geojson", pf)
+	layer := fs.String("layer", "stops", "layer to export, stops, shapes, routes or trips")
+	agencies := fs.String("agencies", "", "comma-separated agency IDs to export")
+	routeTypes := fs.String("route-types", "", "comma-separated route types to export")
+	bbox := fs.String("bbox", "", "bounding box to export, as min lon,min lat,max lon,max lat")
+	out := fs.String("o", "", "output file, default is stdout")
+	fs.Parse(args)
+
+	if pf.opts.DryRun {
+		return errors.New("-dry-run cannot be used with geojson")
+	}
+
+	var e error
+	opts := gtfsparser.GeoJSONOptions{Agencies: make(map[string]bool)}
+
+	if len(*agencies) > 0 {
+		for _, a := range strings.Split(*agencies, ",") {
+			opts.Agencies[strings.TrimSpace(a)] = true
+		}
+	}
+
+	if opts.RouteTypes, e = parseMOTs(*routeTypes); e != nil {
+		return e
+	}
+
+	if len(*bbox) > 0 {
+		vals := strings.Split(*bbox, ",")
+		if len(vals) != 4 {
+			return fmt.Errorf("invalid bounding box %q", *bbox)
+		}
+		opts.BBox = &[4]float64{}
+		for i, v := range vals {
+			if opts.BBox[i], e = strconv.ParseFloat(strings.TrimSpace(v), 64); e != nil {
+				return fmt.Errorf("invalid bounding box %q", *bbox)
+			}
+		}
+	}
+
+	feed, e := parse(fs, pf, nil)
+	if e != nil {
+		return e
+	}
+
+	var fc *gtfsparser.GeoJSONFeatureCollection
+
+	switch *layer {
+	case "stops":
+		fc = feed.StopsGeoJSON(opts)
+	case "shapes":
+		fc = feed.ShapesGeoJSON(opts)
+	case "routes":
+		fc = feed.RoutesGeoJSON(opts)
+	case "trips":
+		fc = feed.TripsGeoJSON(opts)
+	default:
+		return fmt.Errorf("unknown layer %q", *layer)
+	}
+
+	if len(*out) == 0 {
+		_, e = fc.WriteTo(os.Stdout)
+		return e
+	}
+
+	f, e := os.Create(*out)
+	if e != nil {
+		return e
+	}
+
+	_, e = fc.WriteTo(f)
+	if ce := f.Close(); e == nil {
+		e = ce
+	}
+
+	return e
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// CompactStopTimes converts the stop times of all trips into the compact
+// representation, where stop sequences and relative times are shared
+// between trips. Afterwards, Trip.StopTimes is empty and the stop times
+// have to be accessed via Trip.GetStopTimes() or Trip.GetStopTime().
+func (feed *Feed) CompactStopTimes() {
+	if feed.compactor == nil {
+		feed.compactor = gtfs.NewStopTimeCompactor()
+	}
+
+	for _, t := range feed.Trips {
+		if t == nil || t.CompactStopTimes != nil || len(t.StopTimes) == 0 {
+			continue
+		}
+		t.CompactStopTimes = feed.compactor.Compact(t.StopTimes)
+		t.StopTimes = make(gtfs.StopTimes, 0)
+	}
+}
+
+// ExpandStopTimes converts compactly stored stop times back into
+// Trip.StopTimes
+func (feed *Feed) ExpandStopTimes() {
+	for _, t := range feed.Trips {
+		if t == nil || t.CompactStopTimes == nil {
+			continue
+		}
+		t.StopTimes = t.CompactStopTimes.StopTimes()
+		t.CompactStopTimes = nil
+	}
+
+	feed.compactor = nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// An InactiveServiceErr is a service which is not active on any date
+type InactiveServiceErr struct {
+	sid  string
+	tids []string
+	msg  string
+}
+
+func (e *InactiveServiceErr) Error() string {
+	return e.msg
+}
+
+// ServiceId returns the ID of the inactive service
+func (e *InactiveServiceErr) ServiceId() string {
+	return e.sid
+}
+
+// TripIds returns the IDs of the trips using the service, ordered by ID
+func (e *InactiveServiceErr) TripIds() []string {
+	return e.tids
+}
+
+// CheckCalendarCoverage checks that the services cover the validity period
+// given in feed_info.txt, that there is service on every day of the
+// validity period (or, without feed_info.txt, between the first and the
+// last day with service), and that the feed does not expire within
+// expiryDays after today. The expiry check is skipped if today is empty.
+// Services which are never active are reported as *InactiveServiceErr.
+func (feed *Feed) CheckCalendarCoverage(today gtfs.Date, expiryDays int) []error {
+	ret := make([]error, 0)
+
+	ret = append(ret, feed.checkInactiveServices()...)
+
+	active := feed.activeDates()
+
+	var first, last gtfs.Date
+	for d := range active {
+		if first.IsEmpty() || d.GetTime().Before(first.GetTime()) {
+			first = d
+		}
+		if last.IsEmpty() || d.GetTime().After(last.GetTime()) {
+			last = d
+		}
+	}
+
+	if first.IsEmpty() {
+		return append(ret, fmt.Errorf("No service is active on any day."))
+	}
+
+	start, end := first, last
+
+	for _, fi := range feed.FeedInfos {
+		if !fi.StartDate.IsEmpty() {
+			if fi.StartDate.GetTime().Before(first.GetTime()) {
+				ret = append(ret, fmt.Errorf("Services start on %s, after the start date %s given in feed_info.txt.", first.String(), fi.StartDate.String()))
+			}
+			start = fi.StartDate
+		}
+		if !fi.EndDate.IsEmpty() {
+			if fi.EndDate.GetTime().After(last.GetTime()) {
+				ret = append(ret, fmt.Errorf("Services end on %s, before the end date %s given in feed_info.txt.", last.String(), fi.EndDate.String()))
+			}
+			end = fi.EndDate
+		}
+	}
+
+	// days before the first and after the last day with service are
+	// already reported above
+	if start.GetTime().Before(first.GetTime()) {
+		start = first
+	}
+	gapEnd := end
+	if gapEnd.GetTime().After(last.GetTime()) {
+		gapEnd = last
+	}
+
+	// report consecutive days without service as a single error
+	var gapStart gtfs.Date
+	for d := start; !d.GetTime().After(gapEnd.GetTime()); d = d.GetOffsettedDate(1) {
+		if !active[d] {
+			if gapStart.IsEmpty() {
+				gapStart = d
+			}
+		} else if !gapStart.IsEmpty() {
+			ret = append(ret, noServiceErr(gapStart, d.GetOffsettedDate(-1)))
+			gapStart = gtfs.Date{}
+		}
+	}
+	if !gapStart.IsEmpty() {
+		ret = append(ret, noServiceErr(gapStart, gapEnd))
+	}
+
+	if !today.IsEmpty() {
+		days := int(end.GetTime().Sub(today.GetTime()).Hours() / 24)
+		if days < 0 {
+			ret = append(ret, fmt.Errorf("Feed expired on %s.", end.String()))
+		} else if days <= expiryDays {
+			ret = append(ret, fmt.Errorf("Feed expires on %s, in %d days.", end.String(), days))
+		}
+	}
+
+	return ret
+}
+
+func noServiceErr(from, to gtfs.Date) error {
+	if from == to {
+		return fmt.Errorf("No service on %s.", from.String())
+	}
+	return fmt.Errorf("No service from %s to %s.", from.String(), to.String())
+}
+
+// checkInactiveServices returns an *InactiveServiceErr for each service
+// which is never active, ordered by service ID
+func (feed *Feed) checkInactiveServices() []error {
+	ret := make([]error, 0)
+
+	trips := make(map[*gtfs.Service][]string)
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		trips[t.Service] = append(trips[t.Service], t.ID)
+	}
+
+	for _, id := range sortedMapKeys(feed.Services) {
+		s := feed.Services[id]
+		if !s.GetFirstActiveDate().IsEmpty() {
+			continue
+		}
+
+		tids := trips[s]
+		msg := fmt.Sprintf("Service %s is never active.", s.ID)
+		if len(tids) > 5 {
+			msg = fmt.Sprintf("Service %s is never active, but is used by %d trip(s): %s, ...", s.ID, len(tids), strings.Join(tids[:5], ", "))
+		} else if len(tids) > 0 {
+			msg = fmt.Sprintf("Service %s is never active, but is used by %d trip(s): %s.", s.ID, len(tids), strings.Join(tids, ", "))
+		}
+
+		ret = append(ret, &InactiveServiceErr{s.ID, tids, msg})
+	}
+
+	return ret
+}
+
+// activeDates returns all dates on which at least one service is active
+func (feed *Feed) activeDates() map[gtfs.Date]bool {
+	ret := make(map[gtfs.Date]bool)
+
+	for _, s := range feed.Services {
+		start, end := s.GetFirstDefinedDate(), s.GetLastDefinedDate()
+		if start.IsEmpty() || end.IsEmpty() {
+			continue
+		}
+		for d := start; !d.GetTime().After(end.GetTime()); d = d.GetOffsettedDate(1) {
+			if !ret[d] && s.IsActiveOn(d) {
+				ret[d] = true
+			}
+		}
+	}
+
+	return ret
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/csv"
 	"io"
 	"strings"
+	"sync"
 )
 
 type HeaderIdx map[string]int
@@ -34,8 +35,27 @@ type CsvParser struct {
 	assumeclean bool
 	scanner     *bufio.Scanner
 	record      []string
+
+	// called with the number of data rows read after each line, and
+	// once at the end of the file
+	onLine func(rows int, eof bool)
+
+	// if set, lines are read ahead in a separate goroutine
+	prefetchQuit <-chan struct{}
+	prefetchWg   *sync.WaitGroup
+	prefetched   chan []csvLine
+	batch        []csvLine
+}
+
+// a single line read ahead
+type csvLine struct {
+	record []string
+	err    error
 }
 
+const prefetchBatchSize = 512
+const prefetchBatches = 16
+
 // NewCsvParser creates a new CsvParser
 func NewCsvParser(file io.Reader, silentfail bool, assumeclean bool) CsvParser {
 	reader := csv.NewReader(file)
@@ -90,20 +110,39 @@ func (p *CsvParser) ParseCsvLine() []string {
 	// is not accessible.
 	p.Curline++
 
-	if p.assumeclean {
-		have := p.scanner.Scan()
+	var record []string
+	var err error
+
+	if p.prefetchQuit != nil {
+		record, err = p.nextPrefetched()
+	} else {
+		record, err = p.readLine()
+	}
 
-		if !have {
+	if err == io.EOF {
+		p.lineDone(true)
+		return nil
+	} else if err != nil {
+		if p.silentfail {
 			return nil
-		} else if p.scanner.Err() != nil {
-			if p.silentfail {
-				return nil
-			} else {
-				panic(p.scanner.Err())
-			}
+		} else {
+			panic(err)
+		}
+	}
+
+	p.lineDone(false)
+
+	return record
+}
+
+// readLine reads and cleans the next record from the underlying reader
+func (p *CsvParser) readLine() ([]string, error) {
+	if p.assumeclean {
+		if !p.scanner.Scan() {
+			return nil, io.EOF
 		}
 
-		return strings.Split(p.scanner.Text(), ",")
+		return strings.Split(p.scanner.Text(), ","), nil
 	}
 
 	record, err := p.reader.Read()
@@ -125,14 +164,8 @@ func (p *CsvParser) ParseCsvLine() []string {
 		}
 	}
 
-	if err == io.EOF {
-		return nil
-	} else if err != nil {
-		if p.silentfail {
-			return nil
-		} else {
-			panic(err)
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	// trim
@@ -142,7 +175,82 @@ func (p *CsvParser) ParseCsvLine() []string {
 		}
 	}
 
-	return record
+	return record, nil
+}
+
+// prefetch lets all following lines be read and decoded ahead in a
+// separate goroutine, which stops once quit is closed. The goroutine is
+// tracked in wg.
+func (p *CsvParser) prefetch(quit <-chan struct{}, wg *sync.WaitGroup) {
+	p.prefetchQuit = quit
+	p.prefetchWg = wg
+}
+
+func (p *CsvParser) nextPrefetched() ([]string, error) {
+	if p.prefetched == nil {
+		// start reading on first use, to not read files of which only
+		// the header is needed
+		p.prefetched = make(chan []csvLine, prefetchBatches)
+		p.prefetchWg.Add(1)
+		go p.readAhead(p.prefetched, p.prefetchQuit, p.prefetchWg)
+	}
+
+	if len(p.batch) == 0 {
+		batch, ok := <-p.prefetched
+		if !ok {
+			return nil, io.EOF
+		}
+		p.batch = batch
+	}
+
+	line := p.batch[0]
+	p.batch = p.batch[1:]
+
+	return line.record, line.err
+}
+
+func (p *CsvParser) readAhead(out chan<- []csvLine, quit <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(out)
+
+	batch := make([]csvLine, 0, prefetchBatchSize)
+
+	for {
+		record, err := p.readLine()
+
+		if err == nil && !p.assumeclean {
+			// records are reused by the CSV reader
+			record = append([]string(nil), record...)
+		}
+
+		batch = append(batch, csvLine{record, err})
+
+		if err != nil || len(batch) == prefetchBatchSize {
+			select {
+			case out <- batch:
+			case <-quit:
+				return
+			}
+			if err != nil {
+				return
+			}
+			batch = make([]csvLine, 0, prefetchBatchSize)
+		}
+	}
+}
+
+func (p *CsvParser) lineDone(eof bool) {
+	if p.onLine == nil {
+		return
+	}
+
+	// the header is line 1, and Curline has already been increased
+	// for the failed read at EOF
+	if eof {
+		p.onLine(p.Curline-2, true)
+	} else {
+		p.onLine(p.Curline-1, false)
+	}
 }
 
 func (p *CsvParser) parseHeader() {
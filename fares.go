@@ -0,0 +1,271 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// A FareLeg is a single ride of an itinerary
+type FareLeg struct {
+	Route     *gtfs.Route
+	From      *gtfs.Stop
+	To        *gtfs.Stop
+	Departure gtfs.Time
+
+	// optional: all stops passed by the ride, used for contains_id
+	// rules. If empty, only the zones of From and To are used.
+	Stops []*gtfs.Stop
+}
+
+// A FarePart is a fare attribute paid for consecutive legs of an itinerary
+type FarePart struct {
+	Fare      *gtfs.FareAttribute
+	FirstLeg  int
+	LastLeg   int
+	Transfers int
+}
+
+// A FareResult holds the cheapest fare of an itinerary in a currency
+type FareResult struct {
+	Parts    []FarePart
+	Total    gtfs.Decimal
+	Currency gtfs.Currency
+}
+
+// fareRuleSet holds the rules of a fare attribute. As in OpenTripPlanner,
+// the route_ids, origin/destination pairs and contains_ids of all rules
+// are merged into separate restrictions: a rule without a route_id does
+// not lift the route restriction of the other rules.
+type fareRuleSet struct {
+	fare     *gtfs.FareAttribute
+	routes   map[*gtfs.Route]struct{}
+	ods      map[[2]string]struct{}
+	contains map[string]struct{}
+}
+
+// A FareCalculator prices itineraries using fare_attributes.txt and
+// fare_rules.txt
+type FareCalculator struct {
+	rules []*fareRuleSet
+}
+
+// NewFareCalculator creates a FareCalculator for the fares of a feed
+func NewFareCalculator(feed *Feed) *FareCalculator {
+	fc := &FareCalculator{}
+
+	ids := make([]string, 0, len(feed.FareAttributes))
+	for id := range feed.FareAttributes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		fa := feed.FareAttributes[id]
+		rs := &fareRuleSet{
+			fare:     fa,
+			routes:   make(map[*gtfs.Route]struct{}),
+			ods:      make(map[[2]string]struct{}),
+			contains: make(map[string]struct{}),
+		}
+
+		for _, r := range fa.Rules {
+			if r.Route != nil {
+				rs.routes[r.Route] = struct{}{}
+			}
+			if len(r.OriginID) > 0 || len(r.DestinationID) > 0 {
+				rs.ods[[2]string{r.OriginID, r.DestinationID}] = struct{}{}
+			}
+			if len(r.ContainsID) > 0 {
+				rs.contains[r.ContainsID] = struct{}{}
+			}
+		}
+
+		fc.rules = append(fc.rules, rs)
+	}
+
+	return fc
+}
+
+// Calculate returns the cheapest combination of fares for an itinerary in
+// each currency it can be priced in, ordered by currency code, as fares
+// of different currencies are never combined or compared. A fare covers
+// consecutive legs if its rules match the routes and zones of these legs,
+// and if the number of transfers and the time between the first and the
+// last departure are allowed by the fare's Transfers and
+// TransferDuration. A TransferDuration of 0 is treated as unlimited.
+func (fc *FareCalculator) Calculate(legs []FareLeg) ([]*FareResult, error) {
+	if len(legs) == 0 {
+		return nil, errors.New("No legs given")
+	}
+
+	for i, l := range legs {
+		if l.Route == nil || l.From == nil || l.To == nil {
+			return nil, fmt.Errorf("Leg %d has no route, origin or destination", i)
+		}
+	}
+
+	// fares of different currencies cannot be combined, so each currency
+	// is priced separately
+	currencies := make([]gtfs.Currency, 0)
+	seen := make(map[gtfs.Currency]bool)
+	for _, rs := range fc.rules {
+		if !seen[rs.fare.CurrencyType] {
+			seen[rs.fare.CurrencyType] = true
+			currencies = append(currencies, rs.fare.CurrencyType)
+		}
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].String() < currencies[j].String() })
+
+	res := make([]*FareResult, 0, 1)
+	for _, c := range currencies {
+		if r := fc.calculate(legs, c); r != nil {
+			res = append(res, r)
+		}
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("No fare applies to the itinerary")
+	}
+
+	return res, nil
+}
+
+// calculate returns the cheapest combination of fares in a currency, or
+// nil if there is none
+func (fc *FareCalculator) calculate(legs []FareLeg, currency gtfs.Currency) *FareResult {
+	// best[j] is the cheapest price of legs 0..j-1, prev[j] the part
+	// covering the last of these legs
+	best := make([]*gtfs.Decimal, len(legs)+1)
+	prev := make([]FarePart, len(legs)+1)
+	best[0] = &gtfs.Decimal{}
+
+	for j := 1; j <= len(legs); j++ {
+		for i := 0; i < j; i++ {
+			if best[i] == nil {
+				continue
+			}
+
+			fare := fc.cheapest(legs[i:j], currency)
+			if fare == nil {
+				continue
+			}
+
+			price := best[i].Add(fare.Price)
+			if best[j] == nil || price.Cmp(*best[j]) < 0 {
+				best[j] = &price
+				prev[j] = FarePart{fare, i, j - 1, j - i - 1}
+			}
+		}
+	}
+
+	if best[len(legs)] == nil {
+		return nil
+	}
+
+	res := &FareResult{Total: *best[len(legs)], Currency: currency}
+
+	for j := len(legs); j > 0; j = prev[j].FirstLeg {
+		res.Parts = append(res.Parts, prev[j])
+	}
+
+	// parts were collected from the end
+	for i, j := 0, len(res.Parts)-1; i < j; i, j = i+1, j-1 {
+		res.Parts[i], res.Parts[j] = res.Parts[j], res.Parts[i]
+	}
+
+	return res
+}
+
+// cheapest returns the cheapest fare attribute in a currency applying to
+// all legs, or nil
+func (fc *FareCalculator) cheapest(legs []FareLeg, currency gtfs.Currency) *gtfs.FareAttribute {
+	var ret *gtfs.FareAttribute
+
+	for _, rs := range fc.rules {
+		if rs.fare.CurrencyType != currency || !rs.matches(legs) {
+			continue
+		}
+		if ret == nil || rs.fare.Price.Cmp(ret.Price) < 0 {
+			ret = rs.fare
+		}
+	}
+
+	return ret
+}
+
+// matches returns true if the rule set applies to the legs
+func (rs *fareRuleSet) matches(legs []FareLeg) bool {
+	transfers := len(legs) - 1
+	if rs.fare.Transfers >= 0 && transfers > rs.fare.Transfers {
+		return false
+	}
+
+	first, last := legs[0], legs[len(legs)-1]
+
+	if rs.fare.TransferDuration > 0 && transfers > 0 && !first.Departure.Empty() && !last.Departure.Empty() &&
+		last.Departure.Minus(first.Departure) > rs.fare.TransferDuration {
+		return false
+	}
+
+	zones := make(map[string]struct{})
+
+	for _, l := range legs {
+		if rs.fare.Agency != nil && l.Route.Agency != rs.fare.Agency {
+			return false
+		}
+
+		if _, ok := rs.routes[l.Route]; !ok && len(rs.routes) > 0 {
+			return false
+		}
+
+		zones[stopZone(l.From)] = struct{}{}
+		zones[stopZone(l.To)] = struct{}{}
+		for _, s := range l.Stops {
+			zones[stopZone(s)] = struct{}{}
+		}
+	}
+
+	if len(rs.ods) > 0 {
+		o, d := stopZone(first.From), stopZone(last.To)
+		_, ok1 := rs.ods[[2]string{o, d}]
+		_, ok2 := rs.ods[[2]string{o, ""}]
+		_, ok3 := rs.ods[[2]string{"", d}]
+		if !ok1 && !ok2 && !ok3 {
+			return false
+		}
+	}
+
+	if len(rs.contains) > 0 {
+		delete(zones, "")
+		if len(zones) != len(rs.contains) {
+			return false
+		}
+		for z := range zones {
+			if _, ok := rs.contains[z]; !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// stopZone returns the zone of a stop, or of its parent station
+func stopZone(s *gtfs.Stop) string {
+	if s == nil {
+		return ""
+	}
+	if len(s.ZoneID) == 0 && s.ParentStation != nil {
+		return s.ParentStation.ZoneID
+	}
+	return s.ZoneID
+}
@@ -8,14 +8,18 @@ package gtfsparser
 
 import (
 	"archive/zip"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math"
 	"os"
 	opath "path"
 	"runtime"
 	"sort"
+	"sync"
 	"unicode"
 
 	"github.com/thecodinglab/gtfsparser/gtfs"
@@ -102,9 +106,39 @@ type ParseOptions struct {
 	DateFilterEnd         gtfs.Date
 	PolygonFilter         []Polygon
 	UseStandardRouteTypes bool
-	MOTFilter             map[int16]bool
-	MOTFilterNeg          map[int16]bool
-	AssumeCleanCsv        bool
+
+	// route types to keep (MOTFilter) or drop (MOTFilterNeg). A basic
+	// route type also matches the extended types mapped to it, and the
+	// other way round.
+	MOTFilter      map[int16]bool
+	MOTFilterNeg   map[int16]bool
+	AssumeCleanCsv bool
+
+	// parse stop_times.txt and shapes.txt in a single pass, without
+	// counting the rows of each trip and shape first. Faster for large
+	// compressed feeds, but uses more memory if the rows of a trip or
+	// shape are not contiguous.
+	SinglePass bool
+
+	// store stop times compactly after parsing, see Feed.CompactStopTimes
+	CompactStopTimes bool
+
+	// deduplicate repeated string values (names, headsigns, zone IDs,
+	// additional fields, ...) across all tables
+	InternStrings bool
+
+	// clip shapes to the PolygonFilter instead of dropping shape points
+	// outside of it, and cut trips which leave and re-enter it into
+	// separate trips. Not applied to streamed entities.
+	ClipToPolygons bool
+
+	// check the speeds between consecutive stop times against these
+	// limits, see DefaultSpeedLimits. Disabled if nil.
+	SpeedLimits *SpeedLimits
+
+	// number of files parsed concurrently, values < 2 parse sequentially.
+	// Ignored if stream handlers are set.
+	Workers int
 }
 
 type ErrStats struct {
@@ -169,15 +203,48 @@ type Feed struct {
 	lastTrip  *gtfs.Trip
 	lastShape *gtfs.Shape
 
+	// used for single pass parsing
+	stopTimeArena   arena[gtfs.StopTimes, gtfs.StopTime]
+	shapePointArena arena[gtfs.ShapePoints, gtfs.ShapePoint]
+
+	// shares stop patterns between compactly stored stop times
+	compactor *gtfs.StopTimeCompactor
+
+	// deduplicates strings, if enabled
+	interner *stringInterner
+
+	// stop times outside of the PolygonFilter, for clipping
+	outsideStopTimes map[*gtfs.Trip]*outsideStopTimes
+
 	zipFileCloser *zip.ReadCloser
-	curFileHandle *os.File
+	zipReader     *zip.Reader
+	zipDir        string
+	fsys          fs.FS
+	fileHandles   []io.Closer
+	fileMutex     sync.Mutex
+
+	// closed to stop all CSV read-ahead goroutines
+	prefetchQuit chan struct{}
+	prefetchWg   sync.WaitGroup
+
+	ctx           context.Context
+	progress      func(ParseProgress)
+	progressMutex sync.Mutex
+
+	warnHandler func(error)
+	warnMutex   sync.Mutex
 
 	lastString  *string
 	emptyString string
 
 	fastParsePossible bool
 
-	opts ParseOptions
+	opts   ParseOptions
+	stream StreamHandlers
+
+	// entities parsed in the current run, for the stream handlers
+	pendingServices []*gtfs.Service
+	pendingTrips    []*gtfs.Trip
 }
 
 // NewFeed creates a new, empty feed
@@ -212,7 +279,7 @@ func NewFeed() *Feed {
 		NumShpPoints:          0,
 		NumStopTimes:          0,
 		fastParsePossible:     true,
-		opts:                  ParseOptions{false, false, false, false, "", false, false, false, false, gtfs.Date{}, gtfs.Date{}, make([]Polygon, 0), false, make(map[int16]bool, 0), make(map[int16]bool, 0), false},
+		opts:                  ParseOptions{false, false, false, false, "", false, false, false, false, gtfs.Date{}, gtfs.Date{}, make([]Polygon, 0), false, make(map[int16]bool, 0), make(map[int16]bool, 0), false, false, false, false, false, nil, 0},
 	}
 	g.lastString = &g.emptyString
 
@@ -232,6 +299,77 @@ func (feed *Feed) Parse(path string) error {
 // Parse the GTFS data in the specified folder into the feed, use
 // and id prefix
 func (feed *Feed) PrefixParse(path string, prefix string) error {
+	fileInfo, e := os.Stat(path)
+	if e != nil {
+		return e
+	}
+
+	if fileInfo.IsDir() {
+		feed.fsys = os.DirFS(path)
+	} else {
+		feed.zipFileCloser, e = zip.OpenReader(path)
+		if e != nil {
+			return e
+		}
+		feed.setZipReader(&feed.zipFileCloser.Reader)
+	}
+
+	return feed.parse(prefix)
+}
+
+// ParseFS parses the GTFS data in the root of a file system into the feed
+func (feed *Feed) ParseFS(fsys fs.FS) error {
+	return feed.PrefixParseFS(fsys, "")
+}
+
+// PrefixParseFS parses the GTFS data in the root of a file system into
+// the feed, use an id prefix
+func (feed *Feed) PrefixParseFS(fsys fs.FS, prefix string) error {
+	feed.fsys = fsys
+	return feed.parse(prefix)
+}
+
+// ParseZipReader parses the GTFS data in a ZIP archive of the given size
+// into the feed
+func (feed *Feed) ParseZipReader(r io.ReaderAt, size int64) error {
+	return feed.PrefixParseZipReader(r, size, "")
+}
+
+// PrefixParseZipReader parses the GTFS data in a ZIP archive of the given
+// size into the feed, use an id prefix
+func (feed *Feed) PrefixParseZipReader(r io.ReaderAt, size int64, prefix string) error {
+	zipReader, e := zip.NewReader(r, size)
+	if e != nil {
+		return e
+	}
+
+	feed.setZipReader(zipReader)
+	return feed.parse(prefix)
+}
+
+// ParseBytes parses the GTFS data in an in-memory ZIP archive into the feed
+func (feed *Feed) ParseBytes(data []byte) error {
+	return feed.PrefixParseBytes(data, "")
+}
+
+// PrefixParseBytes parses the GTFS data in an in-memory ZIP archive into
+// the feed, use an id prefix
+func (feed *Feed) PrefixParseBytes(data []byte, prefix string) error {
+	return feed.PrefixParseZipReader(bytes.NewReader(data), int64(len(data)), prefix)
+}
+
+func (feed *Feed) setZipReader(zipReader *zip.Reader) {
+	feed.zipReader = zipReader
+	feed.zipDir = ""
+
+	// check for any directory that is a ZIP file
+	if feed.opts.ZipFix {
+		feed.zipDir = feed.getGTFSDir(zipReader)
+	}
+}
+
+// parse the GTFS data from the currently opened source
+func (feed *Feed) parse(prefix string) error {
 	var e error
 
 	// holds stops that are dropped because of geometric filtering.
@@ -249,105 +387,94 @@ func (feed *Feed) PrefixParse(path string, prefix string) error {
 	// with -De
 	filteredTrips := make(map[string]struct{}, 0)
 
-	e = feed.parseAgencies(path, prefix)
-	if e == nil {
-		e = feed.parseFeedInfos(path)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseLevels(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseStops(path, prefix, geofilteredStops)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.reserveShapes(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseShapes(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseRoutes(path, prefix, filteredRoutes)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseCalendar(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseCalendarDates(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseTrips(path, prefix, filteredRoutes, filteredTrips)
-	}
-
-	runtime.GC()
-	if e == nil {
-		e = feed.reserveStopTimes(path, prefix, filteredTrips)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseStopTimes(path, prefix, geofilteredStops, filteredTrips)
-	}
-	if e == nil {
-		// remove reservation markers
-		for tripId, t := range feed.Trips {
-			// might be nil on dry run
-			if t != nil && t.ID != tripId {
-				t.ID = tripId
-				t.StopTimes = make(gtfs.StopTimes, 0)
+	if feed.opts.InternStrings && feed.interner == nil {
+		feed.interner = newStringInterner()
+	}
+
+	steps := []parseStep{
+		{1, 0, func() error { return feed.parseAgencies(prefix) }},
+		{1, 1, func() error { return feed.parseFeedInfos() }},
+		{1, 2, func() error { return feed.parseLevels(prefix) }},
+		{1, 2, func() error { return feed.parseStops(prefix, geofilteredStops) }},
+		{1, 3, func() error {
+			if !feed.reserveShapesFirst() {
+				return nil
+			}
+			return feed.reserveShapes(prefix)
+		}},
+		{1, 3, func() error { return feed.parseShapes(prefix) }},
+		{1, 0, func() error { return feed.parseRoutes(prefix, filteredRoutes) }},
+		{1, 4, func() error { return feed.parseCalendar(prefix) }},
+		{1, 4, func() error {
+			e := feed.parseCalendarDates(prefix)
+			if e == nil {
+				feed.emitServices()
+			}
+			return e
+		}},
+		{2, 0, func() error {
+			e := feed.parseTrips(prefix, filteredRoutes, filteredTrips)
+			if e == nil && feed.streamStopTimes() {
+				// trips have to be known before their stop times are streamed
+				feed.emitTrips()
+			}
+			return e
+		}},
+		{3, 0, func() error {
+			if !feed.reserveStopTimesFirst() {
+				return nil
+			}
+			return feed.reserveStopTimes(prefix, filteredTrips)
+		}},
+		{3, 0, func() error {
+			e := feed.parseStopTimes(prefix, geofilteredStops, filteredTrips)
+			if e == nil {
+				// remove reservation markers
+				for tripId, t := range feed.Trips {
+					// might be nil on dry run
+					if t != nil && (t.ID != tripId || t.StopTimes == nil) {
+						t.ID = tripId
+						t.StopTimes = make(gtfs.StopTimes, 0)
+					}
+				}
+				feed.emitTrips()
 			}
-		}
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseFareAttributes(path, prefix)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseFareAttributeRules(path, prefix, filteredRoutes)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseFrequencies(path, prefix, filteredTrips)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseTransfers(path, prefix, geofilteredStops, filteredRoutes)
-	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parsePathways(path, prefix, geofilteredStops)
+			return e
+		}},
+		{3, 1, func() error { return feed.parseFareAttributes(prefix) }},
+		{3, 1, func() error { return feed.parseFareAttributeRules(prefix, filteredRoutes) }},
+		// frequencies read the trip IDs restored above
+		{3, 0, func() error { return feed.parseFrequencies(prefix, filteredTrips) }},
+		{3, 2, func() error { return feed.parseTransfers(prefix, geofilteredStops, filteredRoutes) }},
+		{3, 3, func() error { return feed.parsePathways(prefix, geofilteredStops) }},
+		{3, 4, func() error { return feed.parseAttributions(prefix, filteredRoutes, filteredTrips) }},
 	}
-	runtime.GC()
-	if e == nil {
-		e = feed.parseAttributions(path, prefix, filteredRoutes, filteredTrips)
-	}
-	runtime.GC()
+
+	e = feed.runSteps(steps)
+
 	// if e == nil {
-	// e = feed.parseTranslations(path, prefix)
+	// e = feed.parseTranslations(prefix)
 	// }
 
-	// close open readers
-	if feed.zipFileCloser != nil {
-		feed.zipFileCloser.Close()
-		feed.zipFileCloser = nil
+	feed.closeSource()
+
+	if e != nil && feed.ctx != nil && feed.ctx.Err() != nil {
+		// parsing was cancelled somewhere inside a file
+		e = feed.ctx.Err()
 	}
 
-	if feed.curFileHandle != nil {
-		feed.curFileHandle.Close()
-		feed.curFileHandle = nil
+	if e == nil && feed.opts.ClipToPolygons && len(feed.opts.PolygonFilter) > 0 {
+		feed.clipToPolygons()
 	}
 
 	if !feed.opts.DateFilterStart.IsEmpty() || !feed.opts.DateFilterEnd.IsEmpty() {
 		feed.filterServices(prefix)
 	}
 
+	if e == nil && feed.opts.CompactStopTimes {
+		feed.CompactStopTimes()
+	}
+
 	runtime.GC()
 
 	return e
@@ -368,56 +495,95 @@ func (feed *Feed) filterServices(prefix string) {
 	}
 }
 
-func (feed *Feed) getFile(path string, name string) (io.Reader, error) {
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		return nil, err
+func (feed *Feed) getFile(name string) (io.Reader, error) {
+	if !feed.concurrent() {
+		// close previous handle
+		feed.closeFiles()
 	}
 
-	if fileInfo.IsDir() {
-		if feed.curFileHandle != nil {
-			// close previous handle
-			feed.curFileHandle.Close()
+	if feed.zipReader != nil {
+		for _, f := range feed.zipReader.File {
+			d, n := opath.Split(f.Name)
+			if d == feed.zipDir && n == name {
+				rc, e := f.Open()
+				if e != nil {
+					return nil, e
+				}
+				feed.addFileHandle(rc)
+				return feed.wrapFile(rc, name, int64(f.UncompressedSize64)), nil
+			}
 		}
 
-		return os.Open(opath.Join(path, name))
-	}
-
-	var e error
-	if feed.zipFileCloser == nil {
-		// reuse existing opened zip file
-		feed.zipFileCloser, e = zip.OpenReader(path)
+		return nil, errors.New("Not found")
 	}
 
+	file, e := feed.fsys.Open(name)
 	if e != nil {
 		return nil, e
 	}
 
-	// check for any directory that is a ZIP file
-	zipDir := feed.getGTFSDir(feed.zipFileCloser)
+	feed.addFileHandle(file)
 
-	if !feed.opts.ZipFix {
-		zipDir = ""
+	size := int64(-1)
+	if info, e := file.Stat(); e == nil {
+		size = info.Size()
 	}
 
-	for _, f := range feed.zipFileCloser.File {
-		d, n := opath.Split(f.Name)
-		if d == zipDir && n == name {
-			return f.Open()
-		}
+	return feed.wrapFile(file, name, size), nil
+}
+
+// wrapFile wraps a file reader for progress reporting, if required
+func (feed *Feed) wrapFile(file io.Reader, name string, size int64) io.Reader {
+	if feed.progress == nil {
+		return file
+	}
+
+	pr := &progressReader{r: file, name: name, total: size}
+	feed.reportProgress(pr, 0, false)
+
+	return pr
+}
+
+func (feed *Feed) addFileHandle(c io.Closer) {
+	feed.fileMutex.Lock()
+	feed.fileHandles = append(feed.fileHandles, c)
+	feed.fileMutex.Unlock()
+}
+
+// closeFiles closes all open file handles
+func (feed *Feed) closeFiles() {
+	feed.fileMutex.Lock()
+	defer feed.fileMutex.Unlock()
+
+	for _, c := range feed.fileHandles {
+		c.Close()
+	}
+	feed.fileHandles = nil
+}
+
+// closeSource closes all open readers
+func (feed *Feed) closeSource() {
+	feed.stopPrefetching()
+	feed.closeFiles()
+
+	if feed.zipFileCloser != nil {
+		feed.zipFileCloser.Close()
+		feed.zipFileCloser = nil
 	}
 
-	return nil, errors.New("Not found")
+	feed.zipReader = nil
+	feed.zipDir = ""
+	feed.fsys = nil
 }
 
-func (feed *Feed) parseAgencies(path string, prefix string) (err error) {
-	file, e := feed.getFile(path, "agency.txt")
+func (feed *Feed) parseAgencies(prefix string) (err error) {
+	file, e := feed.getFile("agency.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file agency.txt")
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -442,6 +608,9 @@ func (feed *Feed) parseAgencies(path string, prefix string) (err error) {
 	if feed.opts.KeepAddFlds {
 		addFlds = addiFields(reader.header, flds)
 	}
+
+	streamed := make([]*gtfs.Agency, 0)
+
 	for record = reader.ParseCsvLine(); record != nil; record = reader.ParseCsvLine() {
 		agency, e := createAgency(record, flds, feed, prefix)
 		if e == nil {
@@ -475,30 +644,38 @@ func (feed *Feed) parseAgencies(path string, prefix string) (err error) {
 
 		feed.Agencies[agency.ID] = agency
 
+		if feed.stream.OnAgency != nil {
+			streamed = append(streamed, agency)
+		}
+
 		for _, i := range addFlds {
 			if i < len(record) {
 				if _, ok := feed.AgenciesAddFlds[reader.header[i]]; !ok {
 					feed.AgenciesAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.AgenciesAddFlds[reader.header[i]][agency.ID] = record[i]
+				feed.AgenciesAddFlds[reader.header[i]][agency.ID] = feed.intern(record[i])
 			}
 		}
 	}
 
 	feed.ColOrders.Agencies = append([]string(nil), reader.header...)
 
+	for _, agency := range streamed {
+		feed.stream.OnAgency(agency)
+	}
+
 	return e
 }
 
-func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "stops.txt")
+func (feed *Feed) parseStops(prefix string, geofiltered map[string]struct{}) (err error) {
+	file, e := feed.getFile("stops.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file stops.txt")
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -531,6 +708,7 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 	}
 
 	parentStopIds := make(map[string]string, 0)
+	streamed := make([]*gtfs.Stop, 0)
 	for record = reader.ParseCsvLine(); record != nil; record = reader.ParseCsvLine() {
 		stop, parentId, e := createStop(record, flds, feed, prefix)
 		if e == nil {
@@ -549,16 +727,7 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 		}
 
 		// check if any defined PolygonFilter contains the stop
-		contains := true
-		for _, poly := range feed.opts.PolygonFilter {
-			contains = false
-			if poly.PolyContains(float64(stop.Lon), float64(stop.Lat)) {
-				contains = true
-				break
-			}
-		}
-
-		if !contains {
+		if !feed.insidePolygonFilter(float64(stop.Lon), float64(stop.Lat)) {
 			geofiltered[stop.ID] = struct{}{}
 			continue
 		}
@@ -569,13 +738,17 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 
 		feed.Stops[stop.ID] = stop
 
+		if feed.stream.OnStop != nil {
+			streamed = append(streamed, stop)
+		}
+
 		for _, i := range addFlds {
 			if i < len(record) {
 				if _, ok := feed.StopsAddFlds[reader.header[i]]; !ok {
 					feed.StopsAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.StopsAddFlds[reader.header[i]][stop.ID] = record[i]
+				feed.StopsAddFlds[reader.header[i]][stop.ID] = feed.intern(record[i])
 			}
 		}
 	}
@@ -583,10 +756,11 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 	feed.ColOrders.Stops = append([]string(nil), reader.header...)
 
 	// write the parent stop ids
-	for id, pid := range parentStopIds {
+	for _, id := range sortedMapKeys(parentStopIds) {
+		pid := parentStopIds[id]
 		pstop, ok := feed.Stops[pid]
 		if !ok {
-			locErr := errors.New("(for stop id " + id + ") No station with id " + pid + " found, cannot use as parent station here.")
+			locErr := newHierarchyErr(HierarchyMissingParent, id, pid, "(for stop id %s) No station with id %s found, cannot use as parent station here.", id, pid)
 			_, wasFiltered := geofiltered[pid]
 
 			// note: if type >= 2, a parent Id is *required*
@@ -609,7 +783,7 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 		}
 
 		if (feed.Stops[id].LocationType == 0 || feed.Stops[id].LocationType == 2 || feed.Stops[id].LocationType == 3) && pstop.LocationType != 1 {
-			locErr := fmt.Errorf("(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=%d (must be 1).", id, pid, pstop.LocationType, feed.Stops[id].LocationType)
+			locErr := newHierarchyErr(HierarchyWrongParentType, id, pid, "(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=%d (must be 1).", id, pid, pstop.LocationType, feed.Stops[id].LocationType)
 			if feed.opts.UseDefValueOnError && !(feed.Stops[id].LocationType == 2 || feed.Stops[id].LocationType == 3) {
 				// continue, the default value "nil" has already be written above
 				feed.warn(locErr)
@@ -626,7 +800,7 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 		}
 
 		if feed.Stops[id].LocationType == 4 && pstop.LocationType != 0 {
-			locErr := fmt.Errorf("(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=4 (boarding area), which expects a parent station with location_type=0 (stop/platform).", id, pid, pstop.LocationType)
+			locErr := newHierarchyErr(HierarchyWrongParentType, id, pid, "(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=4 (boarding area), which expects a parent station with location_type=0 (stop/platform).", id, pid, pstop.LocationType)
 			if feed.opts.DropErroneous {
 				// delete the erroneous entry
 				delete(feed.Stops, id)
@@ -634,24 +808,36 @@ func (feed *Feed) parseStops(path string, prefix string, geofiltered map[string]
 				feed.warn(locErr)
 				continue
 			} else {
-				panic(locErr)
+				return locErr
 			}
 		}
 
 		feed.Stops[id].ParentStation = pstop
 	}
 
+	// stops dropped above may have been parents of other stops
+	if e := feed.checkStationHierarchy(); e != nil {
+		return e
+	}
+
+	for _, stop := range streamed {
+		// stop may have been dropped during parent station resolution
+		if feed.Stops[stop.ID] == stop {
+			feed.stream.OnStop(stop)
+		}
+	}
+
 	return e
 }
 
-func (feed *Feed) parseRoutes(path string, prefix string, filtered map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "routes.txt")
+func (feed *Feed) parseRoutes(prefix string, filtered map[string]struct{}) (err error) {
+	file, e := feed.getFile("routes.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file routes.txt")
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -681,6 +867,8 @@ func (feed *Feed) parseRoutes(path string, prefix string, filtered map[string]st
 		addFlds = addiFields(reader.header, flds)
 	}
 
+	streamed := make([]*gtfs.Route, 0)
+
 	for record = reader.ParseCsvLine(); record != nil; record = reader.ParseCsvLine() {
 		route, e := createRoute(record, flds, feed, prefix)
 		if e == nil {
@@ -698,21 +886,24 @@ func (feed *Feed) parseRoutes(path string, prefix string, filtered map[string]st
 			}
 		}
 		if feed.opts.UseStandardRouteTypes {
+			if _, ok := gtfs.RouteType(route.Type).BasicType(); !ok {
+				feed.warn(fmt.Errorf("No basic route type for route type %d of route '%s', using 2 (rail)", route.Type, route.ID))
+			}
 			route.Type = gtfs.GetTypeFromExtended(route.Type)
 		}
 
-		if len(feed.opts.MOTFilter) != 0 {
-			if _, ok := feed.opts.MOTFilter[route.Type]; !ok {
-				filtered[route.ID] = struct{}{}
-				continue
-			}
+		if len(feed.opts.MOTFilter) != 0 && !matchesMOTFilter(feed.opts.MOTFilter, route.Type) {
+			filtered[route.ID] = struct{}{}
+			continue
 		}
 
-		if len(feed.opts.MOTFilterNeg) != 0 {
-			if _, ok := feed.opts.MOTFilterNeg[route.Type]; ok {
-				filtered[route.ID] = struct{}{}
-				continue
-			}
+		if len(feed.opts.MOTFilterNeg) != 0 && matchesMOTFilter(feed.opts.MOTFilterNeg, route.Type) {
+			filtered[route.ID] = struct{}{}
+			continue
+		}
+
+		if feed.stream.OnRoute != nil {
+			streamed = append(streamed, route)
 		}
 
 		if feed.opts.DryRun {
@@ -726,7 +917,7 @@ func (feed *Feed) parseRoutes(path string, prefix string, filtered map[string]st
 						feed.RoutesAddFlds[reader.header[i]] = make(map[string]string)
 					}
 
-					feed.RoutesAddFlds[reader.header[i]][route.ID] = record[i]
+					feed.RoutesAddFlds[reader.header[i]][route.ID] = feed.intern(record[i])
 				}
 			}
 		}
@@ -734,18 +925,37 @@ func (feed *Feed) parseRoutes(path string, prefix string, filtered map[string]st
 
 	feed.ColOrders.Routes = append([]string(nil), reader.header...)
 
+	for _, route := range streamed {
+		feed.stream.OnRoute(route)
+	}
+
 	return e
 }
 
-func (feed *Feed) parseCalendar(path string, prefix string) (err error) {
-	file, e := feed.getFile(path, "calendar.txt")
+// matchesMOTFilter returns true if route type t matches a type in filter
+func matchesMOTFilter(filter map[int16]bool, t int16) bool {
+	if _, ok := filter[t]; ok {
+		return true
+	}
+
+	for ft := range filter {
+		if gtfs.RouteType(ft).Matches(gtfs.RouteType(t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (feed *Feed) parseCalendar(prefix string) (err error) {
+	file, e := feed.getFile("calendar.txt")
 
 	if e != nil {
 		return nil
 	}
 
-	// reader := NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	// reader := feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -786,6 +996,9 @@ func (feed *Feed) parseCalendar(path string, prefix string) (err error) {
 				feed.Services[service.ID] = nil
 			} else {
 				feed.Services[service.ID] = service
+				if feed.stream.OnService != nil {
+					feed.pendingServices = append(feed.pendingServices, service)
+				}
 
 				// check if service is completely out of range
 				if !feed.opts.DateFilterStart.IsEmpty() && service.EndDate.GetTime().Before(feed.opts.DateFilterStart.GetTime()) || !feed.opts.DateFilterEnd.IsEmpty() && service.StartDate.GetTime().After(feed.opts.DateFilterEnd.GetTime()) {
@@ -814,15 +1027,15 @@ func (feed *Feed) parseCalendar(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseCalendarDates(path string, prefix string) (err error) {
-	file, e := feed.getFile(path, "calendar_dates.txt")
+func (feed *Feed) parseCalendarDates(prefix string) (err error) {
+	file, e := feed.getFile("calendar_dates.txt")
 
 	if e != nil {
 		return nil
 	}
 
-	// reader := NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	// reader := feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -856,6 +1069,9 @@ func (feed *Feed) parseCalendarDates(path string, prefix string) (err error) {
 				feed.Services[service.ID] = nil
 			} else {
 				feed.Services[service.ID] = service
+				if feed.stream.OnService != nil {
+					feed.pendingServices = append(feed.pendingServices, service)
+				}
 			}
 		}
 	}
@@ -865,14 +1081,14 @@ func (feed *Feed) parseCalendarDates(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseTrips(path string, prefix string, filteredRoutes map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "trips.txt")
+func (feed *Feed) parseTrips(prefix string, filteredRoutes map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
+	file, e := feed.getFile("trips.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file trips.txt")
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -907,10 +1123,13 @@ func (feed *Feed) parseTrips(path string, prefix string, filteredRoutes map[stri
 
 		if e == nil {
 			tripId = trip.ID
-			trip.ID = ""
-			dummy := gtfs.StopTime{}
-			dummy.SetSequence(0)
-			trip.StopTimes = append(trip.StopTimes, dummy)
+			if feed.reserveStopTimesFirst() {
+				// reservation marker, see reserveStopTimes
+				trip.ID = ""
+				dummy := gtfs.StopTime{}
+				dummy.SetSequence(0)
+				trip.StopTimes = append(trip.StopTimes, dummy)
+			}
 			if _, ok := feed.Trips[tripId]; ok {
 				e = errors.New("ID collision, trip_id '" + tripId + "' already used.")
 			}
@@ -934,13 +1153,17 @@ func (feed *Feed) parseTrips(path string, prefix string, filteredRoutes map[stri
 		}
 		feed.Trips[tripId] = trip
 
+		if feed.stream.OnTrip != nil && !feed.isDateFiltered(trip.Service) {
+			feed.pendingTrips = append(feed.pendingTrips, trip)
+		}
+
 		for _, i := range addFlds {
 			if i < len(record) {
 				if _, ok := feed.TripsAddFlds[reader.header[i]]; !ok {
 					feed.TripsAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.TripsAddFlds[reader.header[i]][tripId] = record[i]
+				feed.TripsAddFlds[reader.header[i]][tripId] = feed.intern(record[i])
 			}
 		}
 	}
@@ -950,17 +1173,17 @@ func (feed *Feed) parseTrips(path string, prefix string, filteredRoutes map[stri
 	return e
 }
 
-func (feed *Feed) reserveShapes(path string, prefix string) (err error) {
+func (feed *Feed) reserveShapes(prefix string) (err error) {
 	if feed.opts.DropShapes {
 		return
 	}
-	file, e := feed.getFile(path, "shapes.txt")
+	file, e := feed.getFile("shapes.txt")
 
 	if e != nil {
 		return nil
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -991,17 +1214,17 @@ func (feed *Feed) reserveShapes(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseShapes(path string, prefix string) (err error) {
+func (feed *Feed) parseShapes(prefix string) (err error) {
 	if feed.opts.DropShapes {
 		return
 	}
-	file, e := feed.getFile(path, "shapes.txt")
+	file, e := feed.getFile("shapes.txt")
 
 	if e != nil {
 		return nil
 	}
 
-	reader := NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1020,10 +1243,18 @@ func (feed *Feed) parseShapes(path string, prefix string) (err error) {
 
 	addFlds := make([]int, 0)
 
-	if feed.opts.KeepAddFlds {
+	if feed.opts.KeepAddFlds && !feed.streamShapes() {
 		addFlds = addiFields(reader.header, flds)
 	}
 
+	// shapes already handed over to the stream handler
+	var streamed map[*gtfs.Shape]struct{}
+	var curShape *gtfs.Shape
+
+	if feed.streamShapes() {
+		streamed = make(map[*gtfs.Shape]struct{})
+	}
+
 	i := 0
 
 	for record = reader.ParseCsvLine(); record != nil; record = reader.ParseCsvLine() {
@@ -1034,6 +1265,21 @@ func (feed *Feed) parseShapes(path string, prefix string) (err error) {
 
 		shape, sp, e := createShapePoint(record, flds, feed, prefix)
 
+		if e == nil && streamed != nil && shape != curShape {
+			if _, ok := streamed[shape]; ok {
+				e = notContiguousErr("shapes.txt", "shape", shape.ID)
+				shape.Points = nil
+			} else {
+				if curShape != nil {
+					if le := feed.flushShape(curShape); le != nil {
+						return le
+					}
+					streamed[curShape] = struct{}{}
+				}
+				curShape = shape
+			}
+		}
+
 		if e != nil {
 			if feed.opts.DropErroneous {
 				feed.ErrorStats.DroppedShapes++
@@ -1052,15 +1298,20 @@ func (feed *Feed) parseShapes(path string, prefix string) (err error) {
 						feed.ShapesAddFlds[reader.header[i]][shape.ID] = make(map[int]string)
 					}
 
-					feed.ShapesAddFlds[reader.header[i]][shape.ID][int(sp.Sequence)] = record[i]
+					feed.ShapesAddFlds[reader.header[i]][shape.ID][int(sp.Sequence)] = feed.intern(record[i])
 				}
 			}
 		}
 	}
 
 	feed.ColOrders.Shapes = append([]string(nil), reader.header...)
+	feed.shapePointArena = arena[gtfs.ShapePoints, gtfs.ShapePoint]{}
 
-	if e == nil {
+	if e == nil && streamed != nil {
+		if curShape != nil {
+			e = feed.flushShape(curShape)
+		}
+	} else if e == nil {
 		// sort points in shapes, drop empty shapes
 		for id, shape := range feed.Shapes {
 			if len(shape.Points) == 0 {
@@ -1092,13 +1343,13 @@ func (feed *Feed) parseShapes(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) reserveStopTimes(path string, prefix string, filteredTrips map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "stop_times.txt")
+func (feed *Feed) reserveStopTimes(prefix string, filteredTrips map[string]struct{}) (err error) {
+	file, e := feed.getFile("stop_times.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file stop_times.txt")
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1122,13 +1373,13 @@ func (feed *Feed) reserveStopTimes(path string, prefix string, filteredTrips map
 		timepoint:         reader.headeridx.GetFldId("timepoint", -12),
 	}
 
-	file, e = feed.getFile(path, "stop_times.txt")
+	file, e = feed.getFile("stop_times.txt")
 
 	if e != nil {
 		return errors.New("Could not open required file stop_times.txt")
 	}
 
-	reader = NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && flds.stopHeadsign < 0 && !feed.opts.KeepAddFlds)
+	reader = feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && flds.stopHeadsign < 0 && !feed.opts.KeepAddFlds)
 
 	for record = reader.ParseCsvLine(); record != nil; record = reader.ParseCsvLine() {
 		reserveStopTime(record, flds, feed, prefix)
@@ -1137,13 +1388,23 @@ func (feed *Feed) reserveStopTimes(path string, prefix string, filteredTrips map
 	return e
 }
 
-func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "stop_times.txt")
+func (feed *Feed) parseStopTimes(prefix string, geofiltered map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
+	var reader CsvParser
+	var e error
+
+	if feed.opts.SinglePass {
+		reader, e = feed.openStopTimes()
+	} else {
+		var file io.Reader
+		file, e = feed.getFile("stop_times.txt")
+		if e == nil {
+			reader = feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
+		}
+	}
 
 	if e != nil {
 		return errors.New("Could not open required file stop_times.txt")
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !feed.opts.KeepAddFlds)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1169,17 +1430,27 @@ func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[str
 
 	addFlds := make([]int, 0)
 
-	if feed.opts.KeepAddFlds {
+	if feed.opts.KeepAddFlds && !feed.streamStopTimes() {
 		addFlds = addiFields(reader.header, flds)
 	}
 
-	file, e = feed.getFile(path, "stop_times.txt")
+	if !feed.opts.SinglePass {
+		file, e := feed.getFile("stop_times.txt")
 
-	if e != nil {
-		return errors.New("Could not open required file stop_times.txt")
+		if e != nil {
+			return errors.New("Could not open required file stop_times.txt")
+		}
+
+		reader = feed.newCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && flds.stopHeadsign < 0)
 	}
 
-	reader = NewCsvParser(file, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && flds.stopHeadsign < 0)
+	// trips whose stop times were already handed over to the stream handler
+	var streamed map[*gtfs.Trip]struct{}
+	var curTrip *gtfs.Trip
+
+	if feed.streamStopTimes() {
+		streamed = make(map[*gtfs.Trip]struct{})
+	}
 
 	i := 0
 
@@ -1191,6 +1462,21 @@ func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[str
 
 		trip, st, e := createStopTime(record, flds, feed, prefix)
 
+		if e == nil && streamed != nil && trip != curTrip {
+			if _, ok := streamed[trip]; ok {
+				e = notContiguousErr("stop_times.txt", "trip", trip.ID)
+				trip.StopTimes = nil
+			} else {
+				if curTrip != nil {
+					if le := feed.flushStopTimes(curTrip); le != nil {
+						return le
+					}
+					streamed[curTrip] = struct{}{}
+				}
+				curTrip = trip
+			}
+		}
+
 		if e != nil {
 			wasFiltered := false
 			stopNotFoundErr, stopNotFound := e.(*StopNotFoundErr)
@@ -1204,6 +1490,9 @@ func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[str
 			}
 
 			if wasFiltered {
+				if stopNotFound && feed.opts.ClipToPolygons {
+					feed.markOutsideStopTime(record, flds, prefix)
+				}
 				continue
 			} else if feed.opts.DropErroneous {
 				feed.ErrorStats.DroppedStopTimes++
@@ -1222,15 +1511,20 @@ func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[str
 						feed.StopTimesAddFlds[reader.header[i]][trip.ID] = make(map[int]string)
 					}
 
-					feed.StopTimesAddFlds[reader.header[i]][trip.ID][st.Sequence()] = record[i]
+					feed.StopTimesAddFlds[reader.header[i]][trip.ID][st.Sequence()] = feed.intern(record[i])
 				}
 			}
 		}
 	}
 
 	feed.ColOrders.StopTimes = append([]string(nil), reader.header...)
+	feed.stopTimeArena = arena[gtfs.StopTimes, gtfs.StopTime]{}
 
-	if e == nil {
+	if e == nil && streamed != nil {
+		if curTrip != nil {
+			e = feed.flushStopTimes(curTrip)
+		}
+	} else if e == nil {
 		// sort stoptimes in trips
 		for _, trip := range feed.Trips {
 			sort.Sort(trip.StopTimes)
@@ -1245,13 +1539,13 @@ func (feed *Feed) parseStopTimes(path string, prefix string, geofiltered map[str
 	return e
 }
 
-func (feed *Feed) parseFrequencies(path string, prefix string, filteredTrips map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "frequencies.txt")
+func (feed *Feed) parseFrequencies(prefix string, filteredTrips map[string]struct{}) (err error) {
+	file, e := feed.getFile("frequencies.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1303,7 +1597,7 @@ func (feed *Feed) parseFrequencies(path string, prefix string, filteredTrips map
 					feed.FrequenciesAddFlds[reader.header[i]][trip.ID] = make(map[*gtfs.Frequency]string)
 				}
 
-				feed.FrequenciesAddFlds[reader.header[i]][trip.ID][freq] = record[i]
+				feed.FrequenciesAddFlds[reader.header[i]][trip.ID][freq] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1313,13 +1607,13 @@ func (feed *Feed) parseFrequencies(path string, prefix string, filteredTrips map
 	return e
 }
 
-func (feed *Feed) parseFareAttributes(path string, prefix string) (err error) {
-	file, e := feed.getFile(path, "fare_attributes.txt")
+func (feed *Feed) parseFareAttributes(prefix string) (err error) {
+	file, e := feed.getFile("fare_attributes.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1363,7 +1657,7 @@ func (feed *Feed) parseFareAttributes(path string, prefix string) (err error) {
 					feed.FareAttributesAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.FareAttributesAddFlds[reader.header[i]][fa.ID] = record[i]
+				feed.FareAttributesAddFlds[reader.header[i]][fa.ID] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1373,13 +1667,13 @@ func (feed *Feed) parseFareAttributes(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseFareAttributeRules(path string, prefix string, filteredRoutes map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "fare_rules.txt")
+func (feed *Feed) parseFareAttributeRules(prefix string, filteredRoutes map[string]struct{}) (err error) {
+	file, e := feed.getFile("fare_rules.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1430,7 +1724,7 @@ func (feed *Feed) parseFareAttributeRules(path string, prefix string, filteredRo
 						feed.FareRulesAddFlds[reader.header[i]][fare.ID] = make(map[*gtfs.FareAttributeRule]string)
 					}
 
-					feed.FareRulesAddFlds[reader.header[i]][fare.ID][rule] = record[i]
+					feed.FareRulesAddFlds[reader.header[i]][fare.ID][rule] = feed.intern(record[i])
 				}
 			}
 		}
@@ -1441,13 +1735,13 @@ func (feed *Feed) parseFareAttributeRules(path string, prefix string, filteredRo
 	return e
 }
 
-func (feed *Feed) parseTransfers(path string, prefix string, geofiltered map[string]struct{}, filteredRoutes map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "transfers.txt")
+func (feed *Feed) parseTransfers(prefix string, geofiltered map[string]struct{}, filteredRoutes map[string]struct{}) (err error) {
+	file, e := feed.getFile("transfers.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1507,7 +1801,7 @@ func (feed *Feed) parseTransfers(path string, prefix string, geofiltered map[str
 						feed.TransfersAddFlds[reader.header[i]] = make(map[gtfs.TransferKey]string)
 					}
 
-					feed.TransfersAddFlds[reader.header[i]][tk] = record[i]
+					feed.TransfersAddFlds[reader.header[i]][tk] = feed.intern(record[i])
 				}
 			}
 		}
@@ -1518,13 +1812,13 @@ func (feed *Feed) parseTransfers(path string, prefix string, geofiltered map[str
 	return e
 }
 
-func (feed *Feed) parsePathways(path string, prefix string, geofiltered map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "pathways.txt")
+func (feed *Feed) parsePathways(prefix string, geofiltered map[string]struct{}) (err error) {
+	file, e := feed.getFile("pathways.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1586,7 +1880,7 @@ func (feed *Feed) parsePathways(path string, prefix string, geofiltered map[stri
 					feed.PathwaysAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.PathwaysAddFlds[reader.header[i]][pw.ID] = record[i]
+				feed.PathwaysAddFlds[reader.header[i]][pw.ID] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1596,13 +1890,13 @@ func (feed *Feed) parsePathways(path string, prefix string, geofiltered map[stri
 	return e
 }
 
-func (feed *Feed) parseTranslations(path string, prefix string) (err error) {
-	file, e := feed.getFile(path, "translations.txt")
+func (feed *Feed) parseTranslations(prefix string) (err error) {
+	file, e := feed.getFile("translations.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1647,7 +1941,7 @@ func (feed *Feed) parseTranslations(path string, prefix string) (err error) {
 					feed.TranslationsAddFlds[reader.header[i]] = make(map[*gtfs.Translation]string)
 				}
 
-				feed.TranslationsAddFlds[reader.header[i]][trans] = record[i]
+				feed.TranslationsAddFlds[reader.header[i]][trans] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1657,13 +1951,13 @@ func (feed *Feed) parseTranslations(path string, prefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseAttributions(path string, prefix string, filteredRoutes map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
-	file, e := feed.getFile(path, "attributions.txt")
+func (feed *Feed) parseAttributions(prefix string, filteredRoutes map[string]struct{}, filteredTrips map[string]struct{}) (err error) {
+	file, e := feed.getFile("attributions.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1753,7 +2047,7 @@ func (feed *Feed) parseAttributions(path string, prefix string, filteredRoutes m
 					feed.AttributionsAddFlds[reader.header[i]] = make(map[*gtfs.Attribution]string)
 				}
 
-				feed.AttributionsAddFlds[reader.header[i]][attr] = record[i]
+				feed.AttributionsAddFlds[reader.header[i]][attr] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1763,13 +2057,13 @@ func (feed *Feed) parseAttributions(path string, prefix string, filteredRoutes m
 	return e
 }
 
-func (feed *Feed) parseLevels(path string, idprefix string) (err error) {
-	file, e := feed.getFile(path, "levels.txt")
+func (feed *Feed) parseLevels(idprefix string) (err error) {
+	file, e := feed.getFile("levels.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1814,7 +2108,7 @@ func (feed *Feed) parseLevels(path string, idprefix string) (err error) {
 					feed.LevelsAddFlds[reader.header[i]] = make(map[string]string)
 				}
 
-				feed.LevelsAddFlds[reader.header[i]][lvl.ID] = record[i]
+				feed.LevelsAddFlds[reader.header[i]][lvl.ID] = feed.intern(record[i])
 			}
 		}
 	}
@@ -1824,13 +2118,13 @@ func (feed *Feed) parseLevels(path string, idprefix string) (err error) {
 	return e
 }
 
-func (feed *Feed) parseFeedInfos(path string) (err error) {
-	file, e := feed.getFile(path, "feed_info.txt")
+func (feed *Feed) parseFeedInfos() (err error) {
+	file, e := feed.getFile("feed_info.txt")
 
 	if e != nil {
 		return nil
 	}
-	reader := NewCsvParser(file, feed.opts.DropErroneous, false)
+	reader := feed.newCsvParser(file, feed.opts.DropErroneous, false)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -1874,7 +2168,7 @@ func (feed *Feed) parseFeedInfos(path string) (err error) {
 						feed.FeedInfosAddFlds[reader.header[i]] = make(map[*gtfs.FeedInfo]string)
 					}
 
-					feed.FeedInfosAddFlds[reader.header[i]][fi] = record[i]
+					feed.FeedInfosAddFlds[reader.header[i]][fi] = feed.intern(record[i])
 				}
 			}
 			feed.FeedInfos = append(feed.FeedInfos, fi)
@@ -1979,7 +2273,7 @@ func (feed *Feed) checkStopTimeMeasure(trip *gtfs.Trip, opt *ParseOptions) error
 			}
 		}
 	}
-	return nil
+	return feed.checkStopTimeSpeeds(trip, opt)
 }
 
 func (p *Polygon) PolyContains(x float64, y float64) bool {
@@ -2067,7 +2361,7 @@ func polyContCheck(ax float64, ay float64, bx float64, by float64, cx float64, c
 	return 0
 }
 
-func (feed *Feed) getGTFSDir(zip *zip.ReadCloser) string {
+func (feed *Feed) getGTFSDir(zip *zip.Reader) string {
 	// count number of GTFS file occurances in folders,
 	// return the folder with the most GTFS files
 
@@ -2090,7 +2384,7 @@ func (feed *Feed) getGTFSDir(zip *zip.ReadCloser) string {
 		"feed_info.txt":       true,
 	}
 
-	for _, f := range feed.zipFileCloser.File {
+	for _, f := range zip.File {
 		dir, name := opath.Split(f.Name)
 		if files[name] {
 			pathm[dir] = pathm[dir] + 1
@@ -2110,11 +2404,24 @@ func (feed *Feed) getGTFSDir(zip *zip.ReadCloser) string {
 }
 
 func (feed *Feed) warn(e error) {
+	if feed.warnHandler != nil {
+		feed.warnMutex.Lock()
+		feed.warnHandler(e)
+		feed.warnMutex.Unlock()
+	}
+
 	if feed.opts.ShowWarnings {
 		fmt.Fprintln(os.Stderr, "WARNING: "+e.Error())
 	}
 }
 
+// SetWarningHandler sets a function which is called with each warning
+// issued during parsing, regardless of ShowWarnings. The handler is never
+// called concurrently.
+func (feed *Feed) SetWarningHandler(handler func(error)) {
+	feed.warnHandler = handler
+}
+
 func (feed *Feed) DeletePathway(id string) {
 	delete(feed.FareAttributes, id)
 
@@ -6,7 +6,26 @@
 
 package gtfsparser
 
-import "testing"
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
 
 func TestFeedParsing(t *testing.T) {
 	feedCorA := NewFeed()
@@ -93,3 +112,1513 @@ func TestFeedParsing(t *testing.T) {
 		t.Error("Wrong value for <testfield>")
 	}
 }
+
+func TestStreamParsing(t *testing.T) {
+	feed := NewFeed()
+	e := feed.Parse("./testfeeds/correct/b")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	streamFeed := NewFeed()
+
+	numTrips := 0
+	numStopTimes := 0
+	numShpPoints := 0
+	var lastTrip *gtfs.Trip
+	lastSeq := -1
+
+	streamFeed.SetStreamHandlers(StreamHandlers{
+		OnTrip: func(trip *gtfs.Trip) {
+			numTrips++
+		},
+		OnStopTime: func(trip *gtfs.Trip, st *gtfs.StopTime) {
+			if trip == lastTrip && st.Sequence() <= lastSeq {
+				t.Errorf("Stop times for trip %s not ordered", trip.ID)
+			}
+			lastTrip = trip
+			lastSeq = st.Sequence()
+			numStopTimes++
+		},
+		OnShapePoint: func(shape *gtfs.Shape, p *gtfs.ShapePoint) {
+			numShpPoints++
+		},
+	})
+
+	e = streamFeed.Parse("./testfeeds/correct/b")
+
+	if e != nil {
+		t.Error(e)
+		return
+	}
+
+	if numTrips != len(feed.Trips) {
+		t.Errorf("Expected %d streamed trips, got %d", len(feed.Trips), numTrips)
+	}
+
+	expStopTimes := 0
+	for _, trip := range feed.Trips {
+		expStopTimes += len(trip.StopTimes)
+	}
+
+	if numStopTimes != expStopTimes || streamFeed.NumStopTimes != expStopTimes {
+		t.Errorf("Expected %d streamed stop times, got %d", expStopTimes, numStopTimes)
+	}
+
+	if numShpPoints != feed.NumShpPoints {
+		t.Errorf("Expected %d streamed shape points, got %d", feed.NumShpPoints, numShpPoints)
+	}
+
+	for id, trip := range streamFeed.Trips {
+		if len(trip.StopTimes) != 0 {
+			t.Errorf("Stop times for trip %s were retained", id)
+		}
+		if trip.Shape != nil && len(trip.Shape.Points) != 0 {
+			t.Errorf("Shape points for trip %s were retained", id)
+		}
+	}
+}
+
+func TestFeedParsingFromMemory(t *testing.T) {
+	files, e := os.ReadDir("./testfeeds/correct/b")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	mapFS := fstest.MapFS{}
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, f := range files {
+		data, e := os.ReadFile(filepath.Join("./testfeeds/correct/b", f.Name()))
+		if e != nil {
+			t.Fatal(e)
+		}
+		mapFS[f.Name()] = &fstest.MapFile{Data: data}
+
+		// put the files into a subfolder to test ZipFix
+		w, e := zw.Create("gtfs/" + f.Name())
+		if e != nil {
+			t.Fatal(e)
+		}
+		w.Write(data)
+	}
+	zw.Close()
+
+	feedDir := NewFeed()
+	if e := feedDir.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	feedFS := NewFeed()
+	if e := feedFS.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	feedZip := NewFeed()
+	feedZip.SetParseOpts(ParseOptions{ZipFix: true})
+	if e := feedZip.ParseBytes(buf.Bytes()); e != nil {
+		t.Fatal(e)
+	}
+
+	for _, feed := range []*Feed{feedFS, feedZip} {
+		if len(feed.Trips) != len(feedDir.Trips) || len(feed.Stops) != len(feedDir.Stops) || feed.NumShpPoints != feedDir.NumShpPoints {
+			t.Errorf("Expected %d trips, %d stops and %d shape points, got %d, %d and %d", len(feedDir.Trips), len(feedDir.Stops), feedDir.NumShpPoints, len(feed.Trips), len(feed.Stops), feed.NumShpPoints)
+		}
+	}
+
+	feedNoFix := NewFeed()
+	if e := feedNoFix.ParseBytes(buf.Bytes()); e == nil {
+		t.Error("Parse successful, but GTFS files are in a subfolder and ZipFix was not set!")
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed := NewFeed()
+
+	done := make(map[string]int)
+	feed.SetProgressHandler(func(p ParseProgress) {
+		if p.Done {
+			done[p.File] = p.Rows
+		}
+		if p.File == "routes.txt" {
+			cancel()
+		}
+	})
+
+	e := feed.ParseContext(ctx, "./testfeeds/correct/b")
+
+	if !errors.Is(e, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", e)
+	}
+
+	if done["stops.txt"] != 13 {
+		t.Errorf("Expected 13 rows for stops.txt, got %d", done["stops.txt"])
+	}
+
+	if _, ok := done["trips.txt"]; ok {
+		t.Error("Parsing continued after cancellation")
+	}
+}
+
+func TestParallelParsing(t *testing.T) {
+	for _, path := range []string{"./testfeeds/correct/a", "./testfeeds/correct/b", "./testfeeds/correct/addflds", "./testfeeds/fail/a"} {
+		for _, opts := range []ParseOptions{{}, {DropErroneous: true, KeepAddFlds: true}} {
+			feed := NewFeed()
+			feed.SetParseOpts(opts)
+			e := feed.Parse(path)
+
+			opts.Workers = 4
+			parFeed := NewFeed()
+			parFeed.SetParseOpts(opts)
+			parE := parFeed.Parse(path)
+
+			if (e == nil) != (parE == nil) || (e != nil && e.Error() != parE.Error()) {
+				t.Errorf("%s: expected error %v, got %v", path, e, parE)
+				continue
+			}
+
+			if e != nil {
+				continue
+			}
+
+			if len(feed.Trips) != len(parFeed.Trips) || len(feed.Stops) != len(parFeed.Stops) || len(feed.Services) != len(parFeed.Services) ||
+				feed.NumStopTimes != parFeed.NumStopTimes || feed.NumShpPoints != parFeed.NumShpPoints || feed.ErrorStats != parFeed.ErrorStats {
+				t.Errorf("%s: parallel parse differs from sequential parse", path)
+			}
+
+			for id, trip := range feed.Trips {
+				if len(trip.StopTimes) != len(parFeed.Trips[id].StopTimes) {
+					t.Errorf("%s: expected %d stop times for trip %s, got %d", path, len(trip.StopTimes), id, len(parFeed.Trips[id].StopTimes))
+				}
+			}
+		}
+	}
+}
+
+func TestSinglePassParsing(t *testing.T) {
+	for _, path := range []string{"./testfeeds/correct/a", "./testfeeds/correct/b", "./testfeeds/correct/addflds", "./testfeeds/fail/a"} {
+		for _, opts := range []ParseOptions{{}, {DropErroneous: true, KeepAddFlds: true}, {AssumeCleanCsv: true}} {
+			feed := NewFeed()
+			feed.SetParseOpts(opts)
+			e := feed.Parse(path)
+
+			opts.SinglePass = true
+			spFeed := NewFeed()
+			spFeed.SetParseOpts(opts)
+			spE := spFeed.Parse(path)
+
+			if (e == nil) != (spE == nil) {
+				t.Errorf("%s: expected error %v, got %v", path, e, spE)
+				continue
+			}
+
+			if e != nil {
+				continue
+			}
+
+			if len(feed.Trips) != len(spFeed.Trips) || len(feed.Shapes) != len(spFeed.Shapes) || feed.NumShpPoints != spFeed.NumShpPoints {
+				t.Errorf("%s: single pass parse differs from default parse", path)
+			}
+
+			for id, trip := range feed.Trips {
+				spTrip := spFeed.Trips[id]
+				if spTrip == nil || spTrip.ID != id || len(trip.StopTimes) != len(spTrip.StopTimes) {
+					t.Errorf("%s: stop times of trip %s differ", path, id)
+					continue
+				}
+				for i := range trip.StopTimes {
+					if trip.StopTimes[i].Stop.ID != spTrip.StopTimes[i].Stop.ID || trip.StopTimes[i].Sequence() != spTrip.StopTimes[i].Sequence() {
+						t.Errorf("%s: stop time %d of trip %s differs", path, i, id)
+					}
+				}
+			}
+
+			for id, shape := range feed.Shapes {
+				if len(shape.Points) != len(spFeed.Shapes[id].Points) {
+					t.Errorf("%s: points of shape %s differ", path, id)
+				}
+			}
+		}
+	}
+}
+
+// generates a zipped feed with numTrips trips with 30 stop times each
+func benchmarkFeed(b *testing.B, numTrips int) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	write := func(name string, fill func(w *bytes.Buffer)) {
+		f := new(bytes.Buffer)
+		fill(f)
+		w, e := zw.Create(name)
+		if e != nil {
+			b.Fatal(e)
+		}
+		w.Write(f.Bytes())
+	}
+
+	write("agency.txt", func(w *bytes.Buffer) {
+		w.WriteString("agency_id,agency_name,agency_url,agency_timezone\nA,Agency,http://example.com,Europe/Berlin\n")
+	})
+	write("calendar.txt", func(w *bytes.Buffer) {
+		w.WriteString("service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nS,1,1,1,1,1,1,1,20200101,20201231\n")
+	})
+	write("routes.txt", func(w *bytes.Buffer) {
+		w.WriteString("route_id,agency_id,route_short_name,route_type\nR,A,1,3\n")
+	})
+	write("stops.txt", func(w *bytes.Buffer) {
+		w.WriteString("stop_id,stop_name,stop_lat,stop_lon\n")
+		for i := 0; i < 30; i++ {
+			fmt.Fprintf(w, "s%d,Stop %d,%f,%f\n", i, i, 48+float64(i)/100, 7+float64(i)/100)
+		}
+	})
+	write("shapes.txt", func(w *bytes.Buffer) {
+		w.WriteString("shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\n")
+		for i := 0; i < numTrips/10; i++ {
+			for j := 0; j < 100; j++ {
+				fmt.Fprintf(w, "sh%d,%f,%f,%d\n", i, 48+float64(j)/300, 7+float64(j)/300, j)
+			}
+		}
+	})
+	write("trips.txt", func(w *bytes.Buffer) {
+		w.WriteString("route_id,service_id,trip_id,shape_id\n")
+		for i := 0; i < numTrips; i++ {
+			fmt.Fprintf(w, "R,S,t%d,sh%d\n", i, i/10)
+		}
+	})
+	write("stop_times.txt", func(w *bytes.Buffer) {
+		w.WriteString("trip_id,arrival_time,departure_time,stop_id,stop_sequence\n")
+		for i := 0; i < numTrips; i++ {
+			for j := 0; j < 30; j++ {
+				fmt.Fprintf(w, "t%d,%02d:%02d:00,%02d:%02d:00,s%d,%d\n", i, 6+j/60, j%60, 6+j/60, j%60, j, j)
+			}
+		}
+	})
+
+	zw.Close()
+	return buf.Bytes()
+}
+
+func benchmarkParse(b *testing.B, opts ParseOptions) {
+	data := benchmarkFeed(b, 5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		feed := NewFeed()
+		feed.SetParseOpts(opts)
+		if e := feed.ParseBytes(data); e != nil {
+			b.Fatal(e)
+		}
+	}
+}
+
+func BenchmarkParseReserve(b *testing.B) {
+	benchmarkParse(b, ParseOptions{})
+}
+
+func BenchmarkParseSinglePass(b *testing.B) {
+	benchmarkParse(b, ParseOptions{SinglePass: true})
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, path := range []string{"./testfeeds/correct/a", "./testfeeds/correct/b", "./testfeeds/correct/addflds"} {
+		feed := NewFeed()
+		feed.SetParseOpts(ParseOptions{KeepAddFlds: true})
+		if e := feed.Parse(path); e != nil {
+			t.Fatal(e)
+		}
+
+		buf := new(bytes.Buffer)
+		if e := feed.SaveSnapshot(buf); e != nil {
+			t.Fatal(e)
+		}
+
+		loaded := NewFeed()
+		if e := loaded.LoadSnapshot(bytes.NewReader(buf.Bytes())); e != nil {
+			t.Fatal(e)
+		}
+
+		// shape distances may be NaN, compare the snapshots of the trips
+		reBuf := new(bytes.Buffer)
+		if e := loaded.SaveSnapshot(reBuf); e != nil {
+			t.Fatal(e)
+		}
+
+		if !bytes.Equal(buf.Bytes(), reBuf.Bytes()) {
+			t.Errorf("%s: snapshot of loaded snapshot differs", path)
+		}
+
+		if len(feed.Trips) != len(loaded.Trips) || !reflect.DeepEqual(feed.Stops, loaded.Stops) ||
+			!reflect.DeepEqual(feed.FareAttributes, loaded.FareAttributes) || len(feed.Pathways) != len(loaded.Pathways) ||
+			!reflect.DeepEqual(feed.FeedInfos, loaded.FeedInfos) || !reflect.DeepEqual(feed.Attributions, loaded.Attributions) {
+			t.Errorf("%s: loaded snapshot differs from parsed feed", path)
+		}
+
+		if !reflect.DeepEqual(feed.AgenciesAddFlds, loaded.AgenciesAddFlds) || !reflect.DeepEqual(feed.ShapesAddFlds, loaded.ShapesAddFlds) ||
+			!reflect.DeepEqual(feed.ColOrders, loaded.ColOrders) || feed.ErrorStats != loaded.ErrorStats || feed.NumStopTimes != loaded.NumStopTimes {
+			t.Errorf("%s: additional fields or statistics of loaded snapshot differ", path)
+		}
+
+		for id, trip := range loaded.Trips {
+			if trip.Route != loaded.Routes[trip.Route.ID] || (trip.Shape != nil && trip.Shape != loaded.Shapes[trip.Shape.ID]) {
+				t.Errorf("%s: references of trip %s not restored", path, id)
+			}
+			for _, st := range trip.StopTimes {
+				if st.Stop != loaded.Stops[st.Stop.ID] {
+					t.Errorf("%s: stop reference of trip %s not restored", path, id)
+				}
+			}
+		}
+	}
+
+	if e := NewFeed().LoadSnapshot(bytes.NewReader([]byte("GTFSSNAP\x01\x05"))); e == nil {
+		t.Error("Truncated snapshot was loaded without error")
+	}
+
+	// a count of 2^40 attributions, and a string of 2^40 bytes
+	for _, data := range []string{"GTFSSNAP\x03\x80\x80\x80\x80\x80\x20", "GTFSSNAP\x03\x01\x00\x80\x80\x80\x80\x80\x20"} {
+		if e := NewFeed().LoadSnapshot(bytes.NewReader([]byte(data))); e == nil || !strings.Contains(e.Error(), "exceeds the maximum") {
+			t.Errorf("Expected error for corrupt length, got %v", e)
+		}
+	}
+}
+
+func TestCompactStopTimes(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	compFeed := NewFeed()
+	compFeed.SetParseOpts(ParseOptions{CompactStopTimes: true})
+	if e := compFeed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	for id, trip := range feed.Trips {
+		compTrip := compFeed.Trips[id]
+		if len(trip.StopTimes) > 0 && (compTrip.CompactStopTimes == nil || len(compTrip.StopTimes) != 0) {
+			t.Errorf("Stop times of trip %s not stored compactly", id)
+		}
+
+		if trip.NumStopTimes() != compTrip.NumStopTimes() {
+			t.Errorf("Expected %d stop times for trip %s, got %d", trip.NumStopTimes(), id, compTrip.NumStopTimes())
+			continue
+		}
+
+		for i, st := range trip.StopTimes {
+			cst := compTrip.GetStopTime(i)
+			if st.Stop.ID != cst.Stop.ID || st.ArrivalTime != cst.ArrivalTime || st.DepartureTime != cst.DepartureTime ||
+				st.Seq != cst.Seq || st.PickupDropOff != cst.PickupDropOff || *st.Headsign != *cst.Headsign ||
+				st.HasDistanceTraveled() != cst.HasDistanceTraveled() || (st.HasDistanceTraveled() && st.ShapeDistTraveled != cst.ShapeDistTraveled) {
+				t.Errorf("Stop time %d of trip %s differs: %v, %v", i, id, st, cst)
+			}
+		}
+	}
+
+	compFeed.ExpandStopTimes()
+
+	for id, trip := range compFeed.Trips {
+		if trip.CompactStopTimes != nil || len(trip.StopTimes) != len(feed.Trips[id].StopTimes) {
+			t.Errorf("Stop times of trip %s not expanded", id)
+		}
+	}
+}
+
+func TestInternStrings(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	if feed.MemoryStats().InternLookups != 0 {
+		t.Error("Expected no interning by default")
+	}
+
+	internFeed := NewFeed()
+	internFeed.SetParseOpts(ParseOptions{InternStrings: true})
+	if e := internFeed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	for id, stop := range feed.Stops {
+		if !reflect.DeepEqual(stop, internFeed.Stops[id]) {
+			t.Errorf("Stop %s differs: %v, %v", id, stop, internFeed.Stops[id])
+		}
+	}
+
+	for id, trip := range feed.Trips {
+		itrip := internFeed.Trips[id]
+		if *trip.Headsign != *itrip.Headsign || len(trip.StopTimes) != len(itrip.StopTimes) {
+			t.Errorf("Trip %s differs", id)
+			continue
+		}
+		for i, st := range trip.StopTimes {
+			if *st.Headsign != *itrip.StopTimes[i].Headsign {
+				t.Errorf("Headsign of stop time %d of trip %s differs", i, id)
+			}
+		}
+	}
+
+	stats := internFeed.MemoryStats()
+	if stats.InternedStrings == 0 || stats.InternHits == 0 || stats.InternSavedBytes == 0 {
+		t.Errorf("Expected interning statistics, got %+v", stats)
+	}
+	if stats.StopTimes != feed.MemoryStats().StopTimes {
+		t.Errorf("Expected %d stop times, got %d", feed.MemoryStats().StopTimes, stats.StopTimes)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	valid := map[string]gtfs.Time{
+		"08:05:09":   {Hour: 8, Minute: 5, Second: 9},
+		"8:05:09":    {Hour: 8, Minute: 5, Second: 9},
+		"23:59:59":   {Hour: 23, Minute: 59, Second: 59},
+		"130:00:00":  {Hour: 130},
+		"1000:30:00": {Hour: 1000, Minute: 30},
+	}
+
+	for str, exp := range valid {
+		tm, e := gtfs.ParseTime(str)
+		if e != nil || tm != exp {
+			t.Errorf("Expected %v for '%s', got %v (%v)", exp, str, tm, e)
+		}
+		if tm.String() != str && tm.String() != "0"+str {
+			t.Errorf("Expected '%s', got '%s'", str, tm.String())
+		}
+	}
+
+	for _, str := range []string{"", "8:5:09", "08:05", "08:05:9", "08:60:00", "08:00:60", "+8:00:00", "-1:00:00", "008:00:00", "08:00:00 ", "08-00-00", "99999:00:00"} {
+		if _, e := gtfs.ParseTime(str); e == nil {
+			t.Errorf("Expected error for '%s'", str)
+		}
+	}
+
+	// non-standard times in feeds are accepted with a warning
+	warns := 0
+	feed := NewFeed()
+	feed.SetWarningHandler(func(error) { warns++ })
+	if e := feed.ParseFS(testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR,S,T\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT,008:00:00,008:00:00,S1,1\nT,08:60:00,08:60:00,S2,2\n",
+	})); e != nil {
+		t.Fatal(e)
+	}
+	if st := feed.Trips["T"].StopTimes; warns != 4 || st[0].DepartureTime.String() != "08:00:00" || st[1].ArrivalTime.String() != "09:00:00" {
+		t.Errorf("Unexpected non-standard times %v (%d warnings)", st, warns)
+	}
+
+	feed = NewFeed()
+	if e := feed.ParseFS(testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR,S,T\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT,8:5:09,8:5:09,S1,1\n",
+	})); e == nil {
+		t.Error("Expected error for '8:5:09'")
+	}
+
+	a, _ := gtfs.ParseTime("126:30:00")
+	b := a.Add(2*time.Hour + 45*time.Second)
+	if b != (gtfs.Time{Hour: 128, Minute: 30, Second: 45}) || b.Sub(a) != 2*time.Hour+45*time.Second {
+		t.Errorf("Unexpected time arithmetic result %v", b)
+	}
+
+	if c := a.Add(-1500 * time.Millisecond); c != (gtfs.Time{Hour: 126, Minute: 29, Second: 58}) {
+		t.Errorf("Expected 126:29:58, got %v", c)
+	}
+
+	if !a.Before(b) || !b.After(a) || a.Compare(a) != 0 || !gtfs.EmptyTime().Before(a) || !gtfs.EmptyTime().Add(time.Hour).Empty() {
+		t.Error("Unexpected time comparison result")
+	}
+}
+
+func TestRouteTypes(t *testing.T) {
+	for _, rt := range gtfs.RouteTypes() {
+		if len(rt.Name()) == 0 || len(rt.Category()) == 0 {
+			t.Errorf("Expected name and category for route type %d", rt)
+		}
+
+		if rt.IsBasic() {
+			ext, ok := rt.ExtendedType()
+			if b, ok2 := ext.BasicType(); !ok || !ok2 || b != rt || ext.IsBasic() {
+				t.Errorf("Expected route type %d to map back to itself via %d", rt, ext)
+			}
+		}
+	}
+
+	if gtfs.RouteType(1701).Name() != "Cable Car" || gtfs.RouteType(999).IsValid() || gtfs.RouteType(999).Name() != "" {
+		t.Error("Unexpected route type names")
+	}
+
+	exp := map[int16]int16{3: 3, 109: 2, 116: 7, 405: 12, 717: 3, 1021: 4, 1305: 6, 1100: 2, 999: 2}
+	for ext, basic := range exp {
+		if gtfs.GetTypeFromExtended(ext) != basic {
+			t.Errorf("Expected basic type %d for %d, got %d", basic, ext, gtfs.GetTypeFromExtended(ext))
+		}
+	}
+
+	if !gtfs.RouteType(3).Matches(704) || !gtfs.RouteType(704).Matches(3) || gtfs.RouteType(704).Matches(700) || gtfs.RouteType(2).Matches(3) {
+		t.Error("Unexpected route type matching")
+	}
+}
+
+func TestLanguageTags(t *testing.T) {
+	valid := map[string]string{
+		"de":            "de",
+		"DE-ch":         "de-CH",
+		"zh-hant-tw":    "zh-Hant-TW",
+		"sr_latn":       "sr-Latn",
+		"es-419":        "es-419",
+		"iw":            "he",
+		"mul":           "mul",
+		"de-CH-1996":    "de-CH-1996",
+		"en-US-u-ca-gr": "en-US-u-ca-gr",
+		"x-private":     "x-private",
+	}
+
+	for str, exp := range valid {
+		tag, e := gtfs.NewLanguageTag(str)
+		if e != nil || tag.String() != exp {
+			t.Errorf("Expected '%s' for '%s', got '%s' (%v)", exp, str, tag, e)
+		}
+	}
+
+	for _, str := range []string{"d", "deu1", "xx", "de-", "de--CH", "de-CH-a", "en-x", "de-Latn-Latn", "toolongtag"} {
+		if _, e := gtfs.NewLanguageTag(str); e == nil {
+			t.Errorf("Expected error for '%s'", str)
+		}
+	}
+
+	tag, _ := gtfs.NewLanguageTag("zh-Hant-TW")
+	if tag.Language() != "zh" || tag.Script() != "Hant" || tag.Region() != "TW" || tag.Parent().String() != "zh-Hant" {
+		t.Errorf("Unexpected subtags of '%s'", tag)
+	}
+
+	de, _ := gtfs.NewLanguageTag("de")
+	fr, _ := gtfs.NewLanguageTag("fr")
+	deCH, _ := gtfs.NewLanguageTag("de-CH-1996")
+	trs := []*gtfs.Translation{{FieldName: "stop_name", Language: de, Translation: "Genf"}, {FieldName: "stop_name", Language: fr, Translation: "Genève"}}
+
+	if tr, ok := gtfs.Translate(trs, "stop_name", deCH); !ok || tr != "Genf" {
+		t.Errorf("Expected fallback translation 'Genf', got '%s'", tr)
+	}
+	if _, ok := gtfs.Translate(trs, "stop_desc", deCH); ok {
+		t.Error("Expected no translation")
+	}
+	if !de.Matches(deCH) || deCH.Matches(de) {
+		t.Error("Unexpected tag matching")
+	}
+
+	// invalid feed_lang values are only accepted with UseDefValueOnError
+	fsys := testFeedFS(t, "./testfeeds/correct/b", map[string]string{"feed_info.txt": "feed_publisher_name,feed_publisher_url,feed_lang\nPub,http://example.com,xx\n"})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(fsys); e == nil {
+		t.Error("Expected error for invalid feed_lang")
+	}
+
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{UseDefValueOnError: true})
+	if e := feed.ParseFS(fsys); e != nil || len(feed.FeedInfos) != 1 || !feed.FeedInfos[0].Lang.IsEmpty() {
+		t.Errorf("Expected empty feed_lang (%v)", e)
+	}
+
+	// empty feed_lang values are replaced by EmptyStringRepl, even if it
+	// is not a valid tag
+	fsys["feed_info.txt"] = &fstest.MapFile{Data: []byte("feed_publisher_name,feed_publisher_url,feed_lang\nPub,http://example.com,\n")}
+	for repl, exp := range map[string]string{"en": "en", "-": ""} {
+		feed = NewFeed()
+		feed.SetParseOpts(ParseOptions{EmptyStringRepl: repl})
+		if e := feed.ParseFS(fsys); e != nil || len(feed.FeedInfos) != 1 || feed.FeedInfos[0].Lang.String() != exp {
+			t.Errorf("Expected feed_lang '%s' for replacement '%s' (%v)", exp, repl, e)
+		}
+	}
+}
+
+// testFeedFS returns the files of a test feed directory, with some files
+// replaced by the given contents
+func testFeedFS(t *testing.T, dir string, files map[string]string) fstest.MapFS {
+	entries, e := os.ReadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	ret := fstest.MapFS{}
+	for _, f := range entries {
+		data, e := os.ReadFile(filepath.Join(dir, f.Name()))
+		if e != nil {
+			t.Fatal(e)
+		}
+		ret[f.Name()] = &fstest.MapFile{Data: data}
+	}
+
+	for name, data := range files {
+		ret[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+
+	return ret
+}
+
+func TestFarePrices(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	fa := feed.FareAttributes["p"]
+	if fa.Price.String() != "1.25" || fa.CurrencyType.String() != "USD" || fa.CurrencyType.MinorUnits() != 2 {
+		t.Errorf("Unexpected fare attribute price %s %s", fa.Price, fa.CurrencyType)
+	}
+
+	sum := fa.Price.Add(feed.FareAttributes["a"].Price).Mul(3)
+	if exp, _ := gtfs.ParseDecimal("19.5"); !sum.Equals(exp) || sum.String() != "19.50" {
+		t.Errorf("Expected 19.50, got %s", sum)
+	}
+
+	for str, exp := range map[string]string{"0.5": "0.5", "-1,25": "-1.25", ".05": "0.05", "12": "12", "+3.000": "3.000"} {
+		if d, e := gtfs.ParseDecimal(str); e != nil || d.String() != exp {
+			t.Errorf("Expected %s for '%s', got %s (%v)", exp, str, d, e)
+		}
+	}
+
+	for _, str := range []string{"", ".", "1.2.3", "1e5", "abc", "99999999999999999999"} {
+		if _, e := gtfs.ParseDecimal(str); e == nil {
+			t.Errorf("Expected error for '%s'", str)
+		}
+	}
+
+	jpy, _ := gtfs.NewCurrency("jpy")
+	bhd, _ := gtfs.NewCurrency("BHD")
+	d, _ := gtfs.ParseDecimal("2.5")
+	neg, _ := gtfs.ParseDecimal("-2.5")
+	if jpy.Format(d) != "3 JPY" || jpy.Round(neg).String() != "-3" || bhd.Format(d) != "2.500 BHD" {
+		t.Errorf("Unexpected currency rounding: %s, %s", jpy.Format(d), bhd.Format(d))
+	}
+
+	if _, e := gtfs.NewCurrency("ABC"); e == nil {
+		t.Error("Expected error for invalid currency")
+	}
+
+	if d.Cmp(neg) != 1 || neg.Cmp(d) != -1 || d.Sub(d).Sign() != 0 {
+		t.Error("Unexpected decimal comparison result")
+	}
+}
+
+func TestFareCalculator(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	leg := func(route, from, to string, dep string) FareLeg {
+		tm, _ := gtfs.ParseTime(dep)
+		return FareLeg{Route: feed.Routes[route], From: feed.Stops[from], To: feed.Stops[to], Departure: tm}
+	}
+
+	fc := NewFareCalculator(feed)
+	res, e := fc.Calculate([]FareLeg{leg("AB", "BEATTY_AIRPORT", "BULLFROG", "08:00:00"), leg("BFC", "BULLFROG", "FUR_CREEK_RES", "09:00:00")})
+	if e != nil || len(res) != 1 || res[0].Total.String() != "2.50" || len(res[0].Parts) != 2 || res[0].Parts[1].Fare.ID != "p" || res[0].Parts[1].FirstLeg != 1 {
+		t.Errorf("Unexpected fare result %+v (%v)", res, e)
+	}
+
+	if _, e := fc.Calculate([]FareLeg{leg("AB2", "BEATTY_AIRPORT", "BULLFROG", "08:00:00")}); e == nil {
+		t.Error("Expected no fare for route without fare rules")
+	}
+
+	// zone based fares with transfers
+	zones := map[string]string{"BEATTY_AIRPORT": "1", "BULLFROG": "1", "FUR_CREEK_RES": "2", "STAGECOACH": "3"}
+	for id, z := range zones {
+		feed.Stops[id].ZoneID = z
+	}
+
+	price := func(s string) gtfs.Decimal {
+		d, _ := gtfs.ParseDecimal(s)
+		return d
+	}
+
+	feed.FareAttributes = map[string]*gtfs.FareAttribute{
+		"single": {ID: "single", Price: price("2"), Transfers: 0, Rules: []*gtfs.FareAttributeRule{{OriginID: "1", DestinationID: "1"}, {OriginID: "1", DestinationID: "2"}, {OriginID: "2", DestinationID: "3"}}},
+		"day":    {ID: "day", Price: price("3.5"), Transfers: -1, TransferDuration: 7200, Rules: []*gtfs.FareAttributeRule{{ContainsID: "1"}, {ContainsID: "2"}, {ContainsID: "3"}}},
+	}
+
+	fc = NewFareCalculator(feed)
+	legs := []FareLeg{leg("AB", "BEATTY_AIRPORT", "BULLFROG", "08:00:00"), leg("BFC", "BULLFROG", "FUR_CREEK_RES", "08:30:00"), leg("STBA", "FUR_CREEK_RES", "STAGECOACH", "09:00:00")}
+
+	if res, e := fc.Calculate(legs); e != nil || len(res) != 1 || res[0].Total.String() != "3.5" || len(res[0].Parts) != 1 || res[0].Parts[0].Transfers != 2 {
+		t.Errorf("Expected day ticket, got %+v (%v)", res, e)
+	}
+
+	// the day ticket expired before the last ride, and does not cover
+	// the first two rides alone (they don't pass zone 3)
+	legs[2].Departure, _ = gtfs.ParseTime("11:00:00")
+	if res, e := fc.Calculate(legs); e != nil || len(res) != 1 || res[0].Total.String() != "6" || len(res[0].Parts) != 3 || res[0].Parts[2].Fare.ID != "single" {
+		t.Errorf("Expected single tickets, got %+v (%v)", res, e)
+	}
+
+	// fares are combined and compared per currency only. The rule
+	// without route_id of "usd" does not lift its route restriction.
+	usd, _ := gtfs.NewCurrency("USD")
+	eur, _ := gtfs.NewCurrency("EUR")
+	feed.FareAttributes = map[string]*gtfs.FareAttribute{
+		"eur": {ID: "eur", Price: price("2"), CurrencyType: eur, Transfers: 0, Rules: []*gtfs.FareAttributeRule{{Route: feed.Routes["AB"]}, {Route: feed.Routes["BFC"]}}},
+		"usd": {ID: "usd", Price: price("1"), CurrencyType: usd, Transfers: 0, Rules: []*gtfs.FareAttributeRule{{Route: feed.Routes["AB"]}, {OriginID: "1"}}},
+	}
+
+	fc = NewFareCalculator(feed)
+	if res, e := fc.Calculate(legs[:2]); e != nil || len(res) != 1 || res[0].Total.String() != "4" || res[0].Currency != eur || len(res[0].Parts) != 2 {
+		t.Errorf("Expected two EUR tickets, got %+v (%v)", res, e)
+	}
+	if res, e := fc.Calculate(legs[:1]); e != nil || len(res) != 2 || res[0].Total.String() != "2" || res[0].Currency != eur || res[1].Total.String() != "1" || res[1].Currency != usd {
+		t.Errorf("Expected a EUR and a USD ticket, got %+v (%v)", res, e)
+	}
+}
+
+func TestStats(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/b"); e != nil {
+		t.Fatal(e)
+	}
+
+	stats := feed.Stats()
+
+	numStopTimes := 0
+	for _, trip := range feed.Trips {
+		numStopTimes += len(trip.StopTimes)
+	}
+
+	if stats.Trips != len(feed.Trips) || stats.StopTimes != numStopTimes || stats.StopsByLocationType[0] != 9 || stats.TripsByAgency["DTA"] != len(feed.Trips) {
+		t.Errorf("Unexpected counts: %d trips, %d stop times, %v stops, %v trips by agency", stats.Trips, stats.StopTimes, stats.StopsByLocationType, stats.TripsByAgency)
+	}
+
+	if stats.ValidFrom.String() != "20070101" || stats.FirstDeparture.String() != "06:00:00" || len(stats.Days) == 0 || stats.BusiestDay.IsEmpty() {
+		t.Errorf("Unexpected validity %s, first departure %s", stats.ValidFrom, stats.FirstDeparture)
+	}
+
+	for _, d := range stats.Days {
+		if d.Trips > 0 && (d.ServiceKm <= 0 || d.ServiceHours <= 0) {
+			t.Errorf("Expected service km and hours on %s", d.Date)
+		}
+	}
+
+	for _, h := range stats.Headways {
+		n := 0
+		for _, c := range h.Histogram {
+			n += c
+		}
+		if n != h.Departures-1 || h.Min > h.Median || h.Median > h.Max {
+			t.Errorf("Unexpected headways for route %s: %+v", h.Route, h)
+		}
+	}
+
+	j, e := stats.JSON()
+	if e != nil || !bytes.Contains(j, []byte(`"valid_from": "20070101"`)) {
+		t.Errorf("Unexpected JSON output (%v)", e)
+	}
+
+	md := &bytes.Buffer{}
+	if e := stats.WriteMarkdown(md); e != nil || !bytes.Contains(md.Bytes(), []byte("| Trips | 13 |")) {
+		t.Errorf("Unexpected Markdown output (%v)", e)
+	}
+}
+
+func TestWriteZip(t *testing.T) {
+	for _, path := range []string{"./testfeeds/correct/a", "./testfeeds/correct/b", "./testfeeds/correct/addflds"} {
+		feed := NewFeed()
+		feed.SetParseOpts(ParseOptions{KeepAddFlds: true})
+		if e := feed.Parse(path); e != nil {
+			t.Fatal(e)
+		}
+
+		buf := new(bytes.Buffer)
+		if e := feed.WriteZip(buf); e != nil {
+			t.Fatal(e)
+		}
+
+		written := NewFeed()
+		written.SetParseOpts(ParseOptions{KeepAddFlds: true})
+		if e := written.ParseBytes(buf.Bytes()); e != nil {
+			t.Fatalf("%s: %s", path, e.Error())
+		}
+
+		if len(feed.Stops) != len(written.Stops) || len(feed.Trips) != len(written.Trips) || len(feed.Services) != len(written.Services) ||
+			len(feed.FareAttributes) != len(written.FareAttributes) || len(feed.Shapes) != len(written.Shapes) || len(feed.Transfers) != len(written.Transfers) {
+			t.Errorf("%s: written feed differs from parsed feed", path)
+		}
+
+		// writing the re-parsed feed must give the same archive
+		reBuf := new(bytes.Buffer)
+		if e := written.WriteZip(reBuf); e != nil {
+			t.Fatal(e)
+		}
+
+		if !bytes.Equal(buf.Bytes(), reBuf.Bytes()) {
+			t.Errorf("%s: writing the written feed gives a different archive", path)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/a"); e != nil {
+		t.Fatal(e)
+	}
+
+	srv := httptest.NewServer(NewHandler(feed))
+	defer srv.Close()
+
+	get := func(path string, status int, v interface{}) {
+		res, e := http.Get(srv.URL + path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %d", path, status, res.StatusCode)
+		}
+		if v != nil {
+			if e := json.NewDecoder(res.Body).Decode(v); e != nil {
+				t.Fatalf("%s: %s", path, e.Error())
+			}
+		}
+	}
+
+	var stop map[string]interface{}
+	get("/stops/BULLFROG", http.StatusOK, &stop)
+	if stop["name"] != "Bullfrog (Demo)" {
+		t.Errorf("unexpected stop %v", stop)
+	}
+
+	get("/stops/NOPE", http.StatusNotFound, nil)
+	get("/stops/BULLFROG", http.StatusOK, nil)
+
+	var stops []map[string]interface{}
+	get("/stops?q=bullfrog", http.StatusOK, &stops)
+	if len(stops) != 1 || stops[0]["id"] != "BULLFROG" {
+		t.Errorf("unexpected search result %v", stops)
+	}
+
+	get("/stops?lat=36.88108&lon=-116.81797&radius=100", http.StatusOK, &stops)
+	if len(stops) != 1 || stops[0]["id"] != "BULLFROG" || stops[0]["distance"].(float64) > 1 {
+		t.Errorf("unexpected location search result %v", stops)
+	}
+
+	get("/stops?lat=abc&lon=1", http.StatusBadRequest, nil)
+
+	var routes []map[string]interface{}
+	get("/agencies/DTA/routes", http.StatusOK, &routes)
+	if len(routes) != len(feed.Routes) {
+		t.Errorf("expected %d routes, got %d", len(feed.Routes), len(routes))
+	}
+
+	var trip struct {
+		ID        string
+		StopTimes []map[string]interface{} `json:"stop_times"`
+	}
+	get("/trips/STBA", http.StatusOK, &trip)
+	if trip.ID != "STBA" || len(trip.StopTimes) != 2 || trip.StopTimes[1]["arrival_time"] != "06:20:00" {
+		t.Errorf("unexpected trip %v", trip)
+	}
+
+	// STBA runs every 30 minutes from 6:00 to 22:00
+	var deps []map[string]interface{}
+	get("/stops/BEATTY_AIRPORT/departures?date=20080101&from=21:00:00&limit=1000", http.StatusOK, &deps)
+	n := 0
+	for _, d := range deps {
+		if d["trip_id"] == "STBA" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Errorf("expected 2 departures of STBA after 21:00, got %d", n)
+	}
+
+	get("/stops/BEATTY_AIRPORT/departures", http.StatusBadRequest, nil)
+	get("/stops/BEATTY_AIRPORT/departures?date=20200101", http.StatusOK, &deps)
+	if len(deps) != 0 {
+		t.Errorf("expected no departures outside of the service period, got %d", len(deps))
+	}
+
+	// the first run starts at midnight, its arrival at S1 is clamped
+	feed = NewFeed()
+	if e := feed.ParseFS(testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt":       "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\n",
+		"trips.txt":       "route_id,service_id,trip_id\nR,S,T\n",
+		"stop_times.txt":  "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT,05:55:00,06:00:00,S1,1\nT,06:10:00,06:10:00,S2,2\n",
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs\nT,00:00:00,01:00:00,1800\n",
+	})); e != nil {
+		t.Fatal(e)
+	}
+
+	freqSrv := httptest.NewServer(NewHandler(feed))
+	defer freqSrv.Close()
+
+	res, e := http.Get(freqSrv.URL + "/stops/S1/departures?date=20240101")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer res.Body.Close()
+	if e := json.NewDecoder(res.Body).Decode(&deps); e != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response %d: %v", res.StatusCode, e)
+	}
+	if len(deps) != 2 || deps[0]["arrival_time"] != "00:00:00" || deps[0]["departure_time"] != "00:00:00" || deps[1]["arrival_time"] != "00:25:00" {
+		t.Errorf("unexpected departures %v", deps)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	feed := NewFeed()
+	if e := feed.Parse("./testfeeds/correct/a"); e != nil {
+		t.Fatal(e)
+	}
+
+	stops := feed.StopsGeoJSON(GeoJSONOptions{})
+	if len(stops.Features) != len(feed.Stops) {
+		t.Errorf("expected %d stops, got %d", len(feed.Stops), len(stops.Features))
+	}
+
+	stops = feed.StopsGeoJSON(GeoJSONOptions{BBox: &[4]float64{-116.82, 36.88, -116.81, 36.89}})
+	if len(stops.Features) != 1 || stops.Features[0].ID != "BULLFROG" {
+		t.Errorf("unexpected stops in bounding box: %v", stops.Features)
+	}
+
+	shapes := feed.ShapesGeoJSON(GeoJSONOptions{})
+	if len(shapes.Features) != len(feed.Shapes) {
+		t.Errorf("expected %d shapes, got %d", len(feed.Shapes), len(shapes.Features))
+	}
+	if c := shapes.Features[0].Geometry.Coordinates.([][]float64); len(c) != 6 || c[1][0] != 0.5 || c[1][1] != 0.6 {
+		t.Errorf("unexpected shape coordinates %v", c)
+	}
+
+	routes := feed.RoutesGeoJSON(GeoJSONOptions{Agencies: map[string]bool{"DTA": true}})
+	found := false
+	for _, f := range routes.Features {
+		if f.ID == "CITY2" && f.Properties["route_text_color"] != "#000000" {
+			t.Errorf("unexpected route properties %v", f.Properties)
+		}
+		if f.ID == "AB" {
+			found = true
+			if lines := f.Geometry.Coordinates.([][][]float64); len(lines) != 1 || len(lines[0]) != 6 {
+				t.Errorf("expected the shape as route geometry, got %v", lines)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("route AB not exported")
+	}
+
+	if trips := feed.TripsGeoJSON(GeoJSONOptions{RouteTypes: map[int16]bool{2: true}}); len(trips.Features) != 0 {
+		t.Errorf("expected no rail trips, got %d", len(trips.Features))
+	}
+
+	trips := feed.TripsGeoJSON(GeoJSONOptions{RouteTypes: map[int16]bool{3: true}})
+	if len(trips.Features) == 0 {
+		t.Errorf("expected bus trips")
+	}
+
+	buf := new(bytes.Buffer)
+	if _, e := trips.WriteTo(buf); e != nil {
+		t.Fatal(e)
+	}
+
+	var parsed GeoJSONFeatureCollection
+	if e := json.Unmarshal(buf.Bytes(), &parsed); e != nil || parsed.Type != "FeatureCollection" || len(parsed.Features) != len(trips.Features) {
+		t.Errorf("could not read written GeoJSON: %v", e)
+	}
+
+	// shapes are not kept with DryRun
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{DryRun: true})
+	if e := feed.Parse("./testfeeds/correct/a"); e != nil {
+		t.Fatal(e)
+	}
+	if shapes := feed.ShapesGeoJSON(GeoJSONOptions{}); len(shapes.Features) != 0 {
+		t.Errorf("expected no shapes with DryRun, got %d", len(shapes.Features))
+	}
+}
+
+func TestPolygonClip(t *testing.T) {
+	polys, e := ParsePolygonsWKT("SRID=4326;MULTIPOLYGON (((0 -0.1, 0.3 -0.1, 0.3 0.1, 0 0.1, 0 -0.1)), ((0.7 -0.1, 1 -0.1, 1 0.1, 0.7 0.1, 0.7 -0.1)))")
+	if e != nil || len(polys) != 2 {
+		t.Fatalf("could not parse WKT: %v", e)
+	}
+
+	gjPolys, e := ParsePolygonsGeoJSON([]byte(`{"type": "Feature", "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, -0.1], [0.3, -0.1], [0.3, 0.1], [0, 0.1], [0, -0.1]]], [[[0.7, -0.1], [1, -0.1], [1, 0.1], [0.7, 0.1], [0.7, -0.1]]]]}}`))
+	if e != nil || !reflect.DeepEqual(polys, gjPolys) {
+		t.Errorf("GeoJSON and WKT polygons differ: %v", e)
+	}
+
+	if _, e := ParsePolygonsWKT("POLYGON ((0 0, 1 0, 1 1)"); e == nil {
+		t.Error("expected error for unbalanced WKT")
+	}
+
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt":  "stop_id,stop_name,stop_lat,stop_lon\nS0,S0,0,-0.1\nS1,S1,0,0.1\nS2,S2,0,0.2\nS3,S3,0,0.5\nS4,S4,0,0.8\nS5,S5,0,0.9\n",
+		"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence,shape_dist_traveled\nSH,0,0,1,0\nSH,0,0.25,2,25\nSH,0,0.5,3,50\nSH,0,0.75,4,75\nSH,0,1,5,100\n",
+		"trips.txt":  "route_id,service_id,trip_id,shape_id\nR,S,T,SH\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T,05:50:00,05:50:00,S0,0\nT,06:00:00,06:00:00,S1,1\nT,06:10:00,06:10:00,S2,2\nT,06:20:00,06:20:00,S3,3\nT,06:30:00,06:30:00,S4,4\nT,06:40:00,06:40:00,S5,5\n",
+		"frequencies.txt": "trip_id,start_time,end_time,headway_secs,note\nT,05:50:00,07:00:00,1800,x\n",
+	})
+
+	feed := NewFeed()
+	feed.SetParseOpts(ParseOptions{PolygonFilter: polys, ClipToPolygons: true, KeepAddFlds: true})
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(feed.Trips) != 2 || feed.Trips["T"] == nil || feed.Trips["T_2"] == nil {
+		t.Fatalf("expected trips T and T_2, got %d trips", len(feed.Trips))
+	}
+
+	a, b := feed.Trips["T"], feed.Trips["T_2"]
+	if len(a.StopTimes) != 2 || a.StopTimes[1].Stop.ID != "S2" || len(b.StopTimes) != 2 || b.StopTimes[0].Stop.ID != "S4" {
+		t.Errorf("unexpected stop times of cut trips")
+	}
+
+	if a.Shape == nil || b.Shape == nil || a.Shape.ID != "SH" || b.Shape.ID != "SH_2" {
+		t.Fatalf("expected shapes SH and SH_2")
+	}
+
+	last := a.Shape.Points[len(a.Shape.Points)-1]
+	if len(a.Shape.Points) != 3 || math.Abs(float64(last.Lon)-0.3) > 1e-6 || math.Abs(float64(last.DistTraveled)-30) > 1e-3 {
+		t.Errorf("unexpected clipped shape %v", a.Shape.Points)
+	}
+
+	if first := b.Shape.Points[0]; len(b.Shape.Points) != 3 || math.Abs(float64(first.Lon)-0.7) > 1e-6 || first.Sequence != 1 {
+		t.Errorf("unexpected clipped shape %v", b.Shape.Points)
+	}
+
+	// frequencies refer to the departure at the clipped S0
+	if a.Frequencies == nil || len(*a.Frequencies) != 1 || (*a.Frequencies)[0].StartTime.String() != "06:00:00" {
+		t.Errorf("expected frequencies of T to be shifted")
+	}
+
+	if b.Frequencies == nil || len(*b.Frequencies) != 1 || (*b.Frequencies)[0].StartTime.String() != "06:30:00" {
+		t.Errorf("expected frequencies of T_2 to be shifted")
+	}
+
+	for _, tr := range []*gtfs.Trip{a, b} {
+		flds := feed.FrequenciesAddFlds["note"][tr.ID]
+		if len(flds) != 1 || flds[(*tr.Frequencies)[0]] != "x" {
+			t.Errorf("unexpected additional frequency fields of %s: %v", tr.ID, flds)
+		}
+	}
+
+	// without clipping, the trip keeps its stop times inside the polygons
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{PolygonFilter: polys})
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(feed.Trips) != 1 || len(feed.Trips["T"].StopTimes) != 4 || len(feed.Shapes["SH"].Points) != 4 {
+		t.Errorf("unexpected result of unclipped polygon filter")
+	}
+
+	// shapes are not kept with DryRun
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{PolygonFilter: polys, ClipToPolygons: true, DryRun: true})
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Errorf("unexpected error with DryRun: %v", e)
+	}
+}
+
+func TestStationHierarchy(t *testing.T) {
+	files := func(stops string) fstest.MapFS {
+		return testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+			"trips.txt":      "route_id,service_id,trip_id\nR,S,T\n",
+			"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT,06:00:00,06:00:00,P1,1\nT,06:10:00,06:10:00,Q1,2\n",
+			"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" + stops,
+		})
+	}
+
+	valid := "ST,Main,50,8,1,\nP1,Main,50,8,0,ST\nE,Main,50,8,2,ST\nB,Main,50,8,4,P1\n" +
+		"Q1,Market,50.0003,8,0,\nQ2,Market,50,8,0,\nQ3,Market,51,8,0,\n"
+
+	feed := NewFeed()
+	if e := feed.ParseFS(files(valid)); e != nil {
+		t.Fatal(e)
+	}
+
+	if errs := feed.ValidateStationHierarchy(); len(errs) != 0 {
+		t.Errorf("unexpected hierarchy errors %v", errs)
+	}
+
+	// boarding area with a station as parent
+	feed = NewFeed()
+	e := feed.ParseFS(files(valid + "B2,Main,50,8,4,ST\n"))
+	var he *StationHierarchyErr
+	if !errors.As(e, &he) || he.Kind() != HierarchyWrongParentType || he.StopId() != "B2" {
+		t.Errorf("expected wrong parent type error for B2, got %v", e)
+	}
+
+	// the boarding area is dropped together with its erroneous platform
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{DropErroneous: true})
+	if e := feed.ParseFS(files(valid + "P2,Main,50,8,0,E\nB3,Main,50,8,4,P2\n")); e != nil {
+		t.Fatal(e)
+	}
+	if feed.Stops["P2"] != nil || feed.Stops["B3"] != nil || feed.ErrorStats.DroppedStops != 2 {
+		t.Errorf("expected P2 and B3 to be dropped, dropped %d stops", feed.ErrorStats.DroppedStops)
+	}
+
+	feed.Stops["ST"].ParentStation = feed.Stops["P1"]
+	feed.Stops["ST"].LocationType = 0
+	feed.Stops["P1"].LocationType = 1
+	feed.Stops["P1"].ParentStation = feed.Stops["ST"]
+	found := false
+	for _, e := range feed.ValidateStationHierarchy() {
+		if errors.As(e, &he) && he.Kind() == HierarchyCycle {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected cycle error")
+	}
+
+	// Q1 and Q2 are about 33m apart, Q3 is far away
+	feed = NewFeed()
+	if e := feed.ParseFS(files(valid)); e != nil {
+		t.Fatal(e)
+	}
+
+	if n := feed.GenerateParentStations(100); n != 1 {
+		t.Fatalf("expected 1 generated station, got %d", n)
+	}
+
+	st := feed.Stops["Q1"].ParentStation
+	if st == nil || st != feed.Stops["Q2"].ParentStation || feed.Stops["Q3"].ParentStation != nil || st.LocationType != 1 || st.Name != "Market" {
+		t.Errorf("unexpected generated parent stations")
+	}
+
+	if errs := feed.ValidateStationHierarchy(); len(errs) != 0 {
+		t.Errorf("unexpected hierarchy errors %v", errs)
+	}
+}
+
+func TestStationGraph(t *testing.T) {
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"trips.txt":      "route_id,service_id,trip_id\nR,S,T\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT,06:00:00,06:00:00,P1,1\nT,06:10:00,06:10:00,P2,2\n",
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon,location_type,parent_station\n" +
+			"ST,Main,50,8,1,\nE,Entrance,50,8,2,ST\nN,,,,3,ST\nP1,Platform 1,50,8,0,ST\nP2,Platform 2,50,8,0,ST\n",
+		"pathways.txt": "pathway_id,from_stop_id,to_stop_id,pathway_mode,is_bidirectional,traversal_time,signposted_as,reversed_signposted_as\n" +
+			"W1,E,N,1,1,60,Platforms,Exit\nS1,N,P1,2,1,30,Platform 1,Exit\nL1,N,P1,5,1,90,Platform 1,Exit\nX1,N,P2,1,0,20,Platform 2,\n",
+	})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	g := NewStationGraph(feed)
+
+	path := g.ShortestPath(feed.Stops["E"], feed.Stops["P1"], PathOptions{})
+	if path == nil || path.Time != 90 || len(path.Pathways) != 2 || path.Pathways[1].ID != "S1" {
+		t.Errorf("expected path via stairs, got %v", path)
+	}
+
+	path = g.ShortestPath(feed.Stops["E"], feed.Stops["P1"], PathOptions{Accessible: true})
+	if path == nil || path.Time != 150 || path.Pathways[1].ID != "L1" {
+		t.Errorf("expected accessible path via elevator, got %v", path)
+	}
+
+	path = g.ShortestPath(feed.Stops["P1"], feed.Stops["E"], PathOptions{})
+	if path == nil || !reflect.DeepEqual(path.Signposts(), []string{"Exit", "Exit"}) || path.Stops[1].ID != "N" {
+		t.Errorf("unexpected path from platform, got %v", path)
+	}
+
+	if path := g.ShortestPath(feed.Stops["P2"], feed.Stops["E"], PathOptions{}); path != nil {
+		t.Errorf("expected no path from P2, got %v", path)
+	}
+
+	errs := g.CheckReachability(PathOptions{})
+	if len(errs) != 1 || errs[0].Error() != "No entrance of station ST can be reached from platform P2." {
+		t.Errorf("unexpected reachability errors %v", errs)
+	}
+}
+
+func TestSpeedLimits(t *testing.T) {
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\nS3,S3,0.1,0.02\nS4,S4,0,0.03\n",
+		"trips.txt": "route_id,service_id,trip_id\nR,S,T\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T,06:00:00,06:00:00,S1,1\nT,06:01:00,06:01:00,S2,2\nT,06:02:00,06:02:00,S3,3\nT,06:03:00,06:03:00,S4,4\n",
+	})
+
+	parse := func(opts ParseOptions) (*Feed, error) {
+		feed := NewFeed()
+		feed.SetParseOpts(opts)
+		return feed, feed.ParseFS(mapFS)
+	}
+
+	if _, e := parse(ParseOptions{}); e != nil {
+		t.Errorf("unexpected error without speed limits: %v", e)
+	}
+
+	if _, e := parse(ParseOptions{SpeedLimits: DefaultSpeedLimits()}); e == nil || !strings.Contains(e.Error(), "implausible speed") {
+		t.Errorf("expected implausible speed error, got %v", e)
+	}
+
+	feed, e := parse(ParseOptions{SpeedLimits: DefaultSpeedLimits(), DropErroneous: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if st := feed.Trips["T"].StopTimes; len(st) != 3 || st[2].Stop.ID != "S4" || feed.ErrorStats.DroppedStopTimes != 1 {
+		t.Errorf("expected stop time at S3 to be dropped")
+	}
+
+	feed, e = parse(ParseOptions{SpeedLimits: DefaultSpeedLimits(), UseDefValueOnError: true})
+	if e != nil || len(feed.Trips["T"].StopTimes) != 4 {
+		t.Errorf("expected all stop times to be kept: %v", e)
+	}
+
+	// S1 and S2 are 1.1 km apart, with a travel time of 0
+	limits := DefaultSpeedLimits()
+	mapFS["stop_times.txt"] = &fstest.MapFile{Data: []byte("trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+		"T,06:00:00,06:00:00,S1,1\nT,06:00:00,06:00:00,S2,2\n")}
+	if _, e := parse(ParseOptions{SpeedLimits: limits}); e == nil || !strings.Contains(e.Error(), "travel time is 0") {
+		t.Errorf("expected zero travel time error, got %v", e)
+	}
+
+	limits.MaxZeroTimeDist = 2000
+	if _, e := parse(ParseOptions{SpeedLimits: limits}); e != nil {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	if limits.maxSpeed(700) != 150 || limits.maxSpeed(1400) != 50 || limits.maxSpeed(42) != 500 {
+		t.Errorf("unexpected speed limits for extended route types")
+	}
+}
+
+func TestStopShapeDistances(t *testing.T) {
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"stops.txt":  "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0.1\nS2,S2,0.01,0.6\nS3,S3,0,0.9\nS4,S4,0.0005,0.2\n",
+		"shapes.txt": "shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence\nSH,0,0,1\nSH,0,0.5,2\nSH,0,1,3\n",
+		"trips.txt":  "route_id,service_id,trip_id,shape_id\nR,S,T1,SH\nR,S,T2,SH\nR,S,T3,SH\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,06:00:00,06:00:00,S1,1\nT1,06:10:00,06:10:00,S2,2\nT1,06:20:00,06:20:00,S3,3\n" +
+			"T2,06:00:00,06:00:00,S1,1\nT2,06:10:00,06:10:00,S2,2\nT2,06:20:00,06:20:00,S3,3\n" +
+			"T3,06:00:00,06:00:00,S3,1\nT3,06:10:00,06:10:00,S4,2\n",
+	})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	errs := feed.CheckStopShapeDistances(100)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+
+	var se *StopShapeErr
+	if !errors.As(errs[0], &se) || se.TripId() != "T1" || se.StopId() != "S2" || math.Abs(se.Distance()-1112) > 1 {
+		t.Errorf("unexpected error %v", errs[0])
+	}
+
+	// S4 is close to the shape, but before S3
+	if !errors.As(errs[1], &se) || se.TripId() != "T3" || se.StopId() != "S4" || !strings.Contains(se.Error(), "in order") {
+		t.Errorf("unexpected error %v", errs[1])
+	}
+
+	if n := feed.SnapStopsToShapes(); n != 3 {
+		t.Errorf("expected 3 updated trips, got %d", n)
+	}
+
+	want := []float64{11119, 66717, 100075}
+	for i, st := range feed.Trips["T1"].StopTimes {
+		if math.Abs(float64(st.ShapeDistTraveled)-want[i]) > 1 {
+			t.Errorf("expected shape_dist_traveled %f for stop %s, got %f", want[i], st.Stop.ID, st.ShapeDistTraveled)
+		}
+	}
+
+	if st := feed.Trips["T3"].StopTimes; st[1].ShapeDistTraveled < st[0].ShapeDistTraveled {
+		t.Errorf("expected stops of T3 to be matched in order")
+	}
+
+	// compact stop times are updated, even if their pattern is shared
+	feed = NewFeed()
+	feed.SetParseOpts(ParseOptions{CompactStopTimes: true})
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	if n := feed.SnapStopsToShapes(); n != 3 {
+		t.Errorf("expected 3 updated trips, got %d", n)
+	}
+
+	for _, tid := range []string{"T1", "T2"} {
+		for i := range want {
+			if st := feed.Trips[tid].GetStopTime(i); math.Abs(float64(st.ShapeDistTraveled)-want[i]) > 1 {
+				t.Errorf("expected shape_dist_traveled %f for stop %s of %s, got %f", want[i], st.Stop.ID, tid, st.ShapeDistTraveled)
+			}
+		}
+	}
+
+	// given measures in km are kept, the missing one is interpolated
+	mapFS["shapes.txt"] = &fstest.MapFile{Data: []byte("shape_id,shape_pt_lat,shape_pt_lon,shape_pt_sequence,shape_dist_traveled\nSH,0,0,1,0\nSH,0,0.5,2,\nSH,0,1,3,111.195\n")}
+	feed = NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	feed.SnapStopsToShapes()
+
+	if pts := feed.Shapes["SH"].Points; pts[0].DistTraveled != 0 || math.Abs(float64(pts[1].DistTraveled)-55.597) > 1e-3 || pts[2].DistTraveled != 111.195 {
+		t.Errorf("unexpected shape measures %v", pts)
+	}
+
+	for i, st := range feed.Trips["T1"].StopTimes {
+		if math.Abs(float64(st.ShapeDistTraveled)-want[i]/1000) > 1e-2 {
+			t.Errorf("expected shape_dist_traveled %f for stop %s, got %f", want[i]/1000, st.Stop.ID, st.ShapeDistTraveled)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"S,1,1,1,1,1,1,1,20240101,20240131\nW,0,0,0,0,0,1,1,20240101,20240131\n",
+		"stops.txt": "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\nS3,S3,0,0.05\n",
+		"trips.txt": "route_id,service_id,trip_id,block_id\nR,S,T1,B\nR,S,T2,B\nR,W,T3,B\nR,S,T4,B\nR,S,T5,\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"T1,06:00:00,06:00:00,S1,1\nT1,06:30:00,06:30:00,S2,2\n" +
+			"T2,06:40:00,06:40:00,S2,1\nT2,07:00:00,07:00:00,S1,2\n" +
+			"T3,06:50:00,06:50:00,S1,1\nT3,07:20:00,07:20:00,S3,2\n" +
+			"T4,08:00:00,08:00:00,S3,1\nT4,08:30:00,08:30:00,S1,2\n" +
+			"T5,08:00:00,08:00:00,S3,1\nT5,08:30:00,08:30:00,S1,2\n",
+	})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	blocks := feed.Blocks(gtfs.NewDate(1, 1, 2024))
+	if len(blocks) != 1 || blocks[0].ID != "B" || len(blocks[0].Trips) != 3 {
+		t.Fatalf("expected block B with 3 trips, got %v", blocks)
+	}
+	if bt := blocks[0].Trips[2]; bt.Trip.ID != "T4" || bt.Start != 8*3600 || bt.End != 8*3600+1800 {
+		t.Errorf("unexpected last trip of block %v", bt)
+	}
+
+	if blocks := feed.Blocks(gtfs.NewDate(6, 1, 2024)); len(blocks) != 1 || len(blocks[0].Trips) != 4 {
+		t.Errorf("expected 4 trips on saturday")
+	}
+
+	errs := feed.ValidateBlocks(100)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "T2 of block B ends at stop S1, but the next trip T4 starts at stop S3") || !strings.HasSuffix(errs[0].Error(), "on 20240101.") {
+		t.Errorf("unexpected error %v", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), "T2 (06:40:00-07:00:00) and T3 (06:50:00-07:20:00) of block B overlap on 20240106") {
+		t.Errorf("unexpected error %v", errs[1])
+	}
+}
+
+func TestCalendarCoverage(t *testing.T) {
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"feed_info.txt": "feed_publisher_name,feed_publisher_url,feed_lang,feed_start_date,feed_end_date\nP,http://example.com,en,20240101,20240131\n",
+		"calendar.txt": "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\n" +
+			"S,1,1,1,1,1,0,0,20240103,20240131\nX,0,0,0,0,0,0,0,20240101,20240131\n",
+		"stops.txt":      "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\n",
+		"trips.txt":      "route_id,service_id,trip_id\nR,S,T1\nR,X,T2\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,06:00:00,06:00:00,S1,1\nT1,06:10:00,06:10:00,S2,2\nT2,06:00:00,06:00:00,S1,1\nT2,06:10:00,06:10:00,S2,2\n",
+	})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	errs := feed.CheckCalendarCoverage(gtfs.NewDate(25, 1, 2024), 7)
+
+	want := []string{
+		"Service X is never active, but is used by 1 trip(s): T2.",
+		"Services start on 20240103, after the start date 20240101 given in feed_info.txt.",
+		"No service from 20240106 to 20240107.",
+		"No service from 20240113 to 20240114.",
+		"No service from 20240120 to 20240121.",
+		"No service from 20240127 to 20240128.",
+		"Feed expires on 20240131, in 6 days.",
+	}
+
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("expected error %q, got %q", want[i], e.Error())
+		}
+	}
+
+	var ie *InactiveServiceErr
+	if !errors.As(errs[0], &ie) || ie.ServiceId() != "X" || !reflect.DeepEqual(ie.TripIds(), []string{"T2"}) {
+		t.Errorf("unexpected inactive service error %v", errs[0])
+	}
+
+	if errs := feed.CheckCalendarCoverage(gtfs.Date{}, 7); len(errs) != len(want)-1 {
+		t.Errorf("expected no expiry error without a date, got %v", errs)
+	}
+}
+
+func TestNormalizeServices(t *testing.T) {
+	// service A is given by calendar_dates.txt only: all weekdays in
+	// January 2024 except the 15th, and saturday the 20th
+	calDates := "service_id,date,exception_type\n"
+	for d := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC); d.Month() == 1; d = d.AddDate(0, 0, 1) {
+		if (d.Weekday() != time.Saturday && d.Weekday() != time.Sunday && d.Day() != 15) || d.Day() == 20 {
+			calDates += "A," + d.Format("20060102") + ",1\n"
+		}
+	}
+	calDates += "B,20240115,2\nB,20240120,1\nC,20240201,1\n"
+
+	mapFS := testFeedFS(t, "./testfeeds/correct/base", map[string]string{
+		"calendar.txt":       "service_id,monday,tuesday,wednesday,thursday,friday,saturday,sunday,start_date,end_date\nB,1,1,1,1,1,0,0,20231230,20240131\n",
+		"calendar_dates.txt": calDates,
+		"stops.txt":          "stop_id,stop_name,stop_lat,stop_lon\nS1,S1,0,0\nS2,S2,0,0.01\n",
+		"trips.txt":          "route_id,service_id,trip_id\nR,A,T1\nR,B,T2\nR,C,T3\n",
+		"stop_times.txt":     "trip_id,arrival_time,departure_time,stop_id,stop_sequence\nT1,06:00:00,06:00:00,S1,1\nT1,06:10:00,06:10:00,S2,2\nT2,06:00:00,06:00:00,S1,1\nT2,06:10:00,06:10:00,S2,2\nT3,06:00:00,06:00:00,S1,1\nT3,06:10:00,06:10:00,S2,2\n",
+	})
+
+	feed := NewFeed()
+	if e := feed.ParseFS(mapFS); e != nil {
+		t.Fatal(e)
+	}
+
+	// B is active on the same days, but starts on a saturday before
+	orig := *feed.Services["B"]
+	orig.Exceptions = map[gtfs.Date]bool{gtfs.NewDate(15, 1, 2024): false, gtfs.NewDate(20, 1, 2024): true}
+
+	if n := feed.NormalizeServices(); n != 1 {
+		t.Fatalf("expected 1 merged service, got %d", n)
+	}
+
+	a := feed.Services["A"]
+	if feed.Services["B"] != nil || feed.Trips["T2"].Service != a {
+		t.Errorf("expected B to be merged into A")
+	}
+
+	if a.Daymap != 62 || a.StartDate != gtfs.NewDate(1, 1, 2024) || a.EndDate != gtfs.NewDate(31, 1, 2024) || len(a.Exceptions) != 2 {
+		t.Errorf("unexpected minimized service %v", a)
+	}
+	if !a.Equals(&orig) {
+		t.Errorf("expected minimized service to be active on the same days")
+	}
+
+	c := feed.Services["C"]
+	if c.Daymap != 0 || !c.StartDate.IsEmpty() || len(c.Exceptions) != 1 || !c.IsActiveOn(gtfs.NewDate(1, 2, 2024)) {
+		t.Errorf("expected C to be given by calendar_dates.txt only, got %v", c)
+	}
+}
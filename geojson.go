@@ -0,0 +1,377 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// A GeoJSONFeatureCollection is a GeoJSON FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// A GeoJSONFeature is a GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id,omitempty"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// A GeoJSONGeometry is a GeoJSON Point, LineString or MultiLineString.
+// Coordinates are in lon, lat order.
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSONOptions restrict the features of a GeoJSON export. Empty
+// filters match everything.
+type GeoJSONOptions struct {
+	// IDs of the agencies to export
+	Agencies map[string]bool
+
+	// route types to export, as in ParseOptions.MOTFilter
+	RouteTypes map[int16]bool
+
+	// min lon, min lat, max lon, max lat. Features are exported if at
+	// least one of their coordinates is inside the box.
+	BBox *[4]float64
+}
+
+// WriteTo writes the collection as JSON
+func (fc *GeoJSONFeatureCollection) WriteTo(w io.Writer) (int64, error) {
+	data, e := json.Marshal(fc)
+	if e != nil {
+		return 0, e
+	}
+	n, e := w.Write(data)
+	return int64(n), e
+}
+
+func newFeatureCollection() *GeoJSONFeatureCollection {
+	return &GeoJSONFeatureCollection{"FeatureCollection", make([]GeoJSONFeature, 0)}
+}
+
+// matchesRoute returns true if the route passes the agency and route
+// type filters
+func (opts *GeoJSONOptions) matchesRoute(r *gtfs.Route) bool {
+	if r == nil {
+		return len(opts.Agencies) == 0 && len(opts.RouteTypes) == 0
+	}
+	if len(opts.Agencies) > 0 && (r.Agency == nil || !opts.Agencies[r.Agency.ID]) {
+		return false
+	}
+	if len(opts.RouteTypes) > 0 && !matchesMOTFilter(opts.RouteTypes, r.Type) {
+		return false
+	}
+	return true
+}
+
+// inBBox returns true if a point is inside the bounding box
+func (opts *GeoJSONOptions) inBBox(lat, lon float32) bool {
+	if opts.BBox == nil {
+		return true
+	}
+	b := opts.BBox
+	return float64(lon) >= b[0] && float64(lat) >= b[1] && float64(lon) <= b[2] && float64(lat) <= b[3]
+}
+
+// anyInBBox returns true if any of the lines has a coordinate inside the
+// bounding box
+func (opts *GeoJSONOptions) anyInBBox(lines ...[][]float64) bool {
+	if opts.BBox == nil {
+		return true
+	}
+	for _, l := range lines {
+		for _, c := range l {
+			if opts.inBBox(float32(c[1]), float32(c[0])) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StopsGeoJSON returns the stops of the feed as Points. If agencies or
+// route types are filtered, only stops served by matching routes (and
+// their parent stations) are exported.
+func (feed *Feed) StopsGeoJSON(opts GeoJSONOptions) *GeoJSONFeatureCollection {
+	fc := newFeatureCollection()
+
+	var served map[*gtfs.Stop]bool
+	if len(opts.Agencies) > 0 || len(opts.RouteTypes) > 0 {
+		served = make(map[*gtfs.Stop]bool)
+		for _, t := range feed.Trips {
+			if !opts.matchesRoute(t.Route) {
+				continue
+			}
+			for i := 0; i < t.NumStopTimes(); i++ {
+				for s := t.GetStopTime(i).Stop; s != nil && !served[s]; s = s.ParentStation {
+					served[s] = true
+				}
+			}
+		}
+	}
+
+	for _, id := range sortedMapKeys(feed.Stops) {
+		s := feed.Stops[id]
+		if served != nil && !served[s] {
+			continue
+		}
+		if !s.HasLatLon() || !opts.inBBox(s.Lat, s.Lon) {
+			continue
+		}
+
+		props := map[string]interface{}{
+			"stop_id":             s.ID,
+			"stop_code":           s.Code,
+			"stop_name":           s.Name,
+			"stop_desc":           s.Desc,
+			"zone_id":             s.ZoneID,
+			"stop_url":            fmtURL(s.URL),
+			"location_type":       s.LocationType,
+			"parent_station":      "",
+			"stop_timezone":       s.Timezone.GetTzString(),
+			"wheelchair_boarding": s.WheelchairBoarding,
+			"level_id":            "",
+			"platform_code":       s.PlatformCode,
+		}
+		if s.ParentStation != nil {
+			props["parent_station"] = s.ParentStation.ID
+		}
+		if s.Level != nil {
+			props["level_id"] = s.Level.ID
+		}
+
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type:       "Feature",
+			ID:         s.ID,
+			Geometry:   GeoJSONGeometry{"Point", []float64{coord(s.Lon), coord(s.Lat)}},
+			Properties: props,
+		})
+	}
+
+	return fc
+}
+
+// ShapesGeoJSON returns the shapes of the feed as LineStrings. If all
+// points of a shape have a shape_dist_traveled, it is given as the third
+// (M) value of each coordinate. If agencies or route types are filtered,
+// only shapes used by trips of matching routes are exported.
+func (feed *Feed) ShapesGeoJSON(opts GeoJSONOptions) *GeoJSONFeatureCollection {
+	fc := newFeatureCollection()
+
+	var used map[*gtfs.Shape]bool
+	if len(opts.Agencies) > 0 || len(opts.RouteTypes) > 0 {
+		used = make(map[*gtfs.Shape]bool)
+		for _, t := range feed.Trips {
+			if t.Shape != nil && opts.matchesRoute(t.Route) {
+				used[t.Shape] = true
+			}
+		}
+	}
+
+	for _, id := range sortedMapKeys(feed.Shapes) {
+		s := feed.Shapes[id]
+		if s == nil || (used != nil && !used[s]) {
+			continue
+		}
+
+		line := shapeLine(s, true)
+		if len(line) < 2 || !opts.anyInBBox(line) {
+			continue
+		}
+
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type:       "Feature",
+			ID:         s.ID,
+			Geometry:   GeoJSONGeometry{"LineString", line},
+			Properties: map[string]interface{}{"shape_id": s.ID},
+		})
+	}
+
+	return fc
+}
+
+// RoutesGeoJSON returns one MultiLineString per route, merged from the
+// distinct geometries of its trips
+func (feed *Feed) RoutesGeoJSON(opts GeoJSONOptions) *GeoJSONFeatureCollection {
+	fc := newFeatureCollection()
+
+	tripsByRoute := make(map[*gtfs.Route][]*gtfs.Trip)
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		tripsByRoute[t.Route] = append(tripsByRoute[t.Route], t)
+	}
+
+	for _, id := range sortedMapKeys(feed.Routes) {
+		r := feed.Routes[id]
+		if !opts.matchesRoute(r) {
+			continue
+		}
+
+		lines := make([][][]float64, 0)
+		seen := make(map[string]bool)
+
+		for _, t := range tripsByRoute[r] {
+			line := tripLine(t)
+			if len(line) < 2 {
+				continue
+			}
+			key := fmt.Sprint(line)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			lines = append(lines, line)
+		}
+
+		if len(lines) == 0 || !opts.anyInBBox(lines...) {
+			continue
+		}
+
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type:       "Feature",
+			ID:         r.ID,
+			Geometry:   GeoJSONGeometry{"MultiLineString", lines},
+			Properties: routeProps(r),
+		})
+	}
+
+	return fc
+}
+
+// TripsGeoJSON returns one LineString per trip, from its shape or, if it
+// has none, from the positions of its stops
+func (feed *Feed) TripsGeoJSON(opts GeoJSONOptions) *GeoJSONFeatureCollection {
+	fc := newFeatureCollection()
+
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		if !opts.matchesRoute(t.Route) {
+			continue
+		}
+
+		line := tripLine(t)
+		if len(line) < 2 || !opts.anyInBBox(line) {
+			continue
+		}
+
+		props := map[string]interface{}{
+			"trip_id":       t.ID,
+			"service_id":    "",
+			"trip_headsign": "",
+			"direction_id":  t.DirectionID,
+			"shape_id":      "",
+		}
+		if t.Route != nil {
+			for k, v := range routeProps(t.Route) {
+				props[k] = v
+			}
+		}
+		if t.Service != nil {
+			props["service_id"] = t.Service.ID
+		}
+		if t.Headsign != nil {
+			props["trip_headsign"] = *t.Headsign
+		}
+		if t.Shape != nil {
+			props["shape_id"] = t.Shape.ID
+		}
+
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type:       "Feature",
+			ID:         t.ID,
+			Geometry:   GeoJSONGeometry{"LineString", line},
+			Properties: props,
+		})
+	}
+
+	return fc
+}
+
+func routeProps(r *gtfs.Route) map[string]interface{} {
+	ret := map[string]interface{}{
+		"route_id":         r.ID,
+		"agency_id":        "",
+		"route_short_name": r.ShortName,
+		"route_long_name":  r.LongName,
+		"route_type":       r.Type,
+		"route_color":      "",
+		"route_text_color": "",
+	}
+	if r.Agency != nil {
+		ret["agency_id"] = r.Agency.ID
+	}
+	if len(r.Color) > 0 {
+		ret["route_color"] = "#" + r.Color
+	}
+	if len(r.TextColor) > 0 {
+		ret["route_text_color"] = "#" + r.TextColor
+	}
+	return ret
+}
+
+// shapeLine returns the coordinates of a shape, with M values if
+// requested and present for all points
+func shapeLine(s *gtfs.Shape, withM bool) [][]float64 {
+	pts := s.Points
+	if !sort.IsSorted(pts) {
+		pts = append(gtfs.ShapePoints(nil), pts...)
+		sort.Sort(pts)
+	}
+
+	for i := range pts {
+		if !pts[i].HasDistanceTraveled() {
+			withM = false
+			break
+		}
+	}
+
+	ret := make([][]float64, 0, len(pts))
+	for _, p := range pts {
+		if withM {
+			ret = append(ret, []float64{coord(p.Lon), coord(p.Lat), coord(p.DistTraveled)})
+		} else {
+			ret = append(ret, []float64{coord(p.Lon), coord(p.Lat)})
+		}
+	}
+
+	return ret
+}
+
+// tripLine returns the geometry of a trip
+func tripLine(t *gtfs.Trip) [][]float64 {
+	if t.Shape != nil && len(t.Shape.Points) > 1 {
+		return shapeLine(t.Shape, false)
+	}
+
+	ret := make([][]float64, 0, t.NumStopTimes())
+	for i := 0; i < t.NumStopTimes(); i++ {
+		s := t.GetStopTime(i).Stop
+		if s != nil && s.HasLatLon() {
+			ret = append(ret, []float64{coord(s.Lon), coord(s.Lat)})
+		}
+	}
+	return ret
+}
+
+// coord converts a float32 to the float64 with the same shortest decimal
+// representation, to avoid conversion artifacts in the output
+func coord(f float32) float64 {
+	ret, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'f', -1, 32), 64)
+	return ret
+}
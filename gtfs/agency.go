@@ -17,7 +17,7 @@ type Agency struct {
 	Name         string
 	URL          *url.URL
 	Timezone     Timezone
-	Lang         LanguageISO6391
+	Lang         LanguageTag
 	Phone        string
 	FareURL      *url.URL
 	Email        *mail.Address
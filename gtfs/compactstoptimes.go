@@ -0,0 +1,208 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfs
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// offset used for empty times in a TimePattern
+const emptyOffset = math.MinInt32
+
+// A StopPattern holds everything but the times of a sequence of stop
+// times. It is shared by all trips with identical stop sequences.
+type StopPattern struct {
+	Stops         []*Stop
+	Headsigns     []*string
+	Seqs          []int32
+	PickupDropOff []uint8
+	Dists         []float32
+}
+
+// A TimePattern holds the arrival and departure times of a sequence of
+// stop times as offsets in seconds from the first departure. It is
+// shared by all trips with identical relative times.
+type TimePattern struct {
+	Arrivals   []int32
+	Departures []int32
+}
+
+// CompactStopTimes is a memory-compact representation of the stop times
+// of a single trip
+type CompactStopTimes struct {
+	Stops *StopPattern
+	Times *TimePattern
+	Start Time
+}
+
+// Len returns the number of stop times
+func (c *CompactStopTimes) Len() int {
+	return len(c.Stops.Stops)
+}
+
+// StopTime returns the i-th stop time
+func (c *CompactStopTimes) StopTime(i int) StopTime {
+	return StopTime{
+		ArrivalTime:       c.time(c.Times.Arrivals[i]),
+		DepartureTime:     c.time(c.Times.Departures[i]),
+		PickupDropOff:     c.Stops.PickupDropOff[i],
+		Stop:              c.Stops.Stops[i],
+		Headsign:          c.Stops.Headsigns[i],
+		Seq:               c.Stops.Seqs[i],
+		ShapeDistTraveled: c.Stops.Dists[i],
+	}
+}
+
+// StopTimes returns all stop times
+func (c *CompactStopTimes) StopTimes() StopTimes {
+	ret := make(StopTimes, c.Len())
+	for i := range ret {
+		ret[i] = c.StopTime(i)
+	}
+	return ret
+}
+
+func (c *CompactStopTimes) time(offset int32) Time {
+	if offset == emptyOffset {
+		return EmptyTime()
+	}
+	t, _ := NewTimeFromSeconds(c.Start.SecondsSinceMidnight() + int(offset))
+	return t
+}
+
+// A StopTimeCompactor creates CompactStopTimes, sharing stop and time
+// patterns between all stop times compacted by it
+type StopTimeCompactor struct {
+	stopPatterns map[string]*StopPattern
+	timePatterns map[string]*TimePattern
+	buf          []byte
+}
+
+// NewStopTimeCompactor creates a new StopTimeCompactor
+func NewStopTimeCompactor() *StopTimeCompactor {
+	return &StopTimeCompactor{
+		stopPatterns: make(map[string]*StopPattern),
+		timePatterns: make(map[string]*TimePattern),
+	}
+}
+
+// NumStopPatterns returns the number of distinct stop patterns
+func (sc *StopTimeCompactor) NumStopPatterns() int {
+	return len(sc.stopPatterns)
+}
+
+// NumTimePatterns returns the number of distinct time patterns
+func (sc *StopTimeCompactor) NumTimePatterns() int {
+	return len(sc.timePatterns)
+}
+
+// Compact returns the compact representation of stop times
+func (sc *StopTimeCompactor) Compact(sts StopTimes) *CompactStopTimes {
+	c := &CompactStopTimes{Start: EmptyTime()}
+
+	for _, st := range sts {
+		if !st.DepartureTime.Empty() {
+			c.Start = st.DepartureTime
+			break
+		}
+		if !st.ArrivalTime.Empty() {
+			c.Start = st.ArrivalTime
+			break
+		}
+	}
+
+	// stop pattern
+	sc.buf = sc.buf[:0]
+	for i := range sts {
+		st := &sts[i]
+		var stopID *string
+		if st.Stop != nil {
+			stopID = &st.Stop.ID
+		}
+		sc.buf = appendKeyStr(sc.buf, stopID)
+		sc.buf = appendKeyStr(sc.buf, st.Headsign)
+		sc.buf = binary.AppendVarint(sc.buf, int64(st.Seq))
+		sc.buf = append(sc.buf, st.PickupDropOff)
+		sc.buf = binary.LittleEndian.AppendUint32(sc.buf, math.Float32bits(st.ShapeDistTraveled))
+	}
+
+	if p, ok := sc.stopPatterns[string(sc.buf)]; ok {
+		c.Stops = p
+	} else {
+		p := &StopPattern{
+			Stops:         make([]*Stop, len(sts)),
+			Headsigns:     make([]*string, len(sts)),
+			Seqs:          make([]int32, len(sts)),
+			PickupDropOff: make([]uint8, len(sts)),
+			Dists:         make([]float32, len(sts)),
+		}
+		for i := range sts {
+			p.Stops[i] = sts[i].Stop
+			p.Headsigns[i] = sts[i].Headsign
+			p.Seqs[i] = sts[i].Seq
+			p.PickupDropOff[i] = sts[i].PickupDropOff
+			p.Dists[i] = sts[i].ShapeDistTraveled
+		}
+		sc.stopPatterns[string(sc.buf)] = p
+		c.Stops = p
+	}
+
+	// time pattern
+	sc.buf = sc.buf[:0]
+	for i := range sts {
+		sc.buf = binary.AppendVarint(sc.buf, int64(c.offset(sts[i].ArrivalTime)))
+		sc.buf = binary.AppendVarint(sc.buf, int64(c.offset(sts[i].DepartureTime)))
+	}
+
+	if p, ok := sc.timePatterns[string(sc.buf)]; ok {
+		c.Times = p
+	} else {
+		p := &TimePattern{Arrivals: make([]int32, len(sts)), Departures: make([]int32, len(sts))}
+		for i := range sts {
+			p.Arrivals[i] = c.offset(sts[i].ArrivalTime)
+			p.Departures[i] = c.offset(sts[i].DepartureTime)
+		}
+		sc.timePatterns[string(sc.buf)] = p
+		c.Times = p
+	}
+
+	return c
+}
+
+// appendKeyStr appends a length-prefixed string to a pattern key
+func appendKeyStr(buf []byte, s *string) []byte {
+	if s == nil {
+		return append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(*s))+1)
+	return append(buf, *s...)
+}
+
+func (c *CompactStopTimes) offset(t Time) int32 {
+	if t.Empty() {
+		return emptyOffset
+	}
+	return int32(t.SecondsSinceMidnight() - c.Start.SecondsSinceMidnight())
+}
+
+// PatternBytes returns the approximate memory used by all patterns
+func (sc *StopTimeCompactor) PatternBytes() int64 {
+	// stop, headsign, seq, pickup/dropoff, dist
+	perStop := int64(8 + 8 + 4 + 1 + 4)
+	// arrival and departure offset
+	perTime := int64(4 + 4)
+
+	ret := int64(0)
+	for _, p := range sc.stopPatterns {
+		ret += int64(len(p.Stops)) * perStop
+	}
+	for _, p := range sc.timePatterns {
+		ret += int64(len(p.Arrivals)) * perTime
+	}
+	return ret
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ISO 4217 currency codes with their number of minor units
+var validCurrencies = []struct {
+	code       string
+	minorUnits int8
+}{
+	{"AED", 2}, {"AFN", 2}, {"ALL", 2}, {"AMD", 2}, {"ANG", 2}, {"AOA", 2}, {"ARS", 2}, {"AUD", 2},
+	{"AWG", 2}, {"AZN", 2}, {"BAM", 2}, {"BBD", 2}, {"BDT", 2}, {"BGN", 2}, {"BHD", 3}, {"BIF", 0},
+	{"BMD", 2}, {"BND", 2}, {"BOB", 2}, {"BOV", 2}, {"BRL", 2}, {"BSD", 2}, {"BTN", 2}, {"BWP", 2},
+	{"BYN", 2}, {"BZD", 2}, {"CAD", 2}, {"CDF", 2}, {"CHE", 2}, {"CHF", 2}, {"CHW", 2}, {"CLF", 4},
+	{"CLP", 0}, {"CNY", 2}, {"COP", 2}, {"COU", 2}, {"CRC", 2}, {"CUC", 2}, {"CUP", 2}, {"CVE", 2},
+	{"CZK", 2}, {"DJF", 0}, {"DKK", 2}, {"DOP", 2}, {"DZD", 2}, {"EGP", 2}, {"ERN", 2}, {"ETB", 2},
+	{"EUR", 2}, {"FJD", 2}, {"FKP", 2}, {"GBP", 2}, {"GEL", 2}, {"GHS", 2}, {"GIP", 2}, {"GMD", 2},
+	{"GNF", 0}, {"GTQ", 2}, {"GYD", 2}, {"HKD", 2}, {"HNL", 2}, {"HRK", 2}, {"HTG", 2}, {"HUF", 2},
+	{"IDR", 2}, {"ILS", 2}, {"INR", 2}, {"IQD", 3}, {"IRR", 2}, {"ISK", 0}, {"JMD", 2}, {"JOD", 3},
+	{"JPY", 0}, {"KES", 2}, {"KGS", 2}, {"KHR", 2}, {"KMF", 0}, {"KPW", 2}, {"KRW", 0}, {"KWD", 3},
+	{"KYD", 2}, {"KZT", 2}, {"LAK", 2}, {"LBP", 2}, {"LKR", 2}, {"LRD", 2}, {"LSL", 2}, {"LYD", 3},
+	{"MAD", 2}, {"MDL", 2}, {"MGA", 2}, {"MKD", 2}, {"MMK", 2}, {"MNT", 2}, {"MOP", 2}, {"MRU", 2},
+	{"MUR", 2}, {"MVR", 2}, {"MWK", 2}, {"MXN", 2}, {"MXV", 2}, {"MYR", 2}, {"MZN", 2}, {"NAD", 2},
+	{"NGN", 2}, {"NIO", 2}, {"NOK", 2}, {"NPR", 2}, {"NZD", 2}, {"OMR", 3}, {"PAB", 2}, {"PEN", 2},
+	{"PGK", 2}, {"PHP", 2}, {"PKR", 2}, {"PLN", 2}, {"PYG", 0}, {"QAR", 2}, {"RON", 2}, {"RSD", 2},
+	{"RUB", 2}, {"RWF", 0}, {"SAR", 2}, {"SBD", 2}, {"SCR", 2}, {"SDG", 2}, {"SEK", 2}, {"SGD", 2},
+	{"SHP", 2}, {"SLE", 2}, {"SLL", 2}, {"SOS", 2}, {"SRD", 2}, {"SSP", 2}, {"STN", 2}, {"SVC", 2},
+	{"SYP", 2}, {"SZL", 2}, {"THB", 2}, {"TJS", 2}, {"TMT", 2}, {"TND", 3}, {"TOP", 2}, {"TRY", 2},
+	{"TTD", 2}, {"TWD", 2}, {"TZS", 2}, {"UAH", 2}, {"UGX", 0}, {"USD", 2}, {"USN", 2}, {"UYI", 0},
+	{"UYU", 2}, {"UYW", 4}, {"UZS", 2}, {"VED", 2}, {"VES", 2}, {"VND", 0}, {"VUV", 0}, {"WST", 2},
+	{"XAF", 0}, {"XCD", 2}, {"XCG", 2}, {"XOF", 0}, {"XPF", 0}, {"XXX", 0}, {"YER", 2}, {"ZAR", 2},
+	{"ZMW", 2}, {"ZWG", 2}, {"ZWL", 2},
+}
+
+// index of each code in validCurrencies
+var currencyIdx = func() map[string]int16 {
+	ret := make(map[string]int16, len(validCurrencies))
+	for i, c := range validCurrencies {
+		ret[c.code] = int16(i)
+	}
+	return ret
+}()
+
+// A Currency describes a currency according to the ISO 4217 standard. The
+// zero value is the empty currency.
+type Currency struct {
+	c int16 // index in validCurrencies + 1
+}
+
+// NewCurrency creates a new Currency object from an ISO 4217 code
+func NewCurrency(code string) (Currency, error) {
+	if i, ok := currencyIdx[strings.ToUpper(code)]; ok {
+		return Currency{i + 1}, nil
+	}
+	return Currency{}, fmt.Errorf("'%s' is not a valid ISO 4217 currency code, see https://en.wikipedia.org/wiki/ISO_4217", code)
+}
+
+// GetCurrencyString returns the three-letter ISO 4217 code of the currency
+func (c Currency) GetCurrencyString() string {
+	if c.c == 0 {
+		return ""
+	}
+	return validCurrencies[c.c-1].code
+}
+
+// String returns the three-letter ISO 4217 code of the currency
+func (c Currency) String() string {
+	return c.GetCurrencyString()
+}
+
+// IsEmpty returns true if the currency is empty
+func (c Currency) IsEmpty() bool {
+	return c.c == 0
+}
+
+// MinorUnits returns the number of decimal places of the currency's minor
+// unit, e.g. 2 for EUR (cents) or 0 for JPY
+func (c Currency) MinorUnits() int {
+	if c.c == 0 {
+		return 0
+	}
+	return int(validCurrencies[c.c-1].minorUnits)
+}
+
+// Round rounds an amount to the minor unit of the currency
+func (c Currency) Round(d Decimal) Decimal {
+	return d.Round(c.MinorUnits())
+}
+
+// Format returns an amount with exactly as many decimal places as the
+// minor unit of the currency, followed by the currency code
+func (c Currency) Format(d Decimal) string {
+	return c.Round(d).Rescale(c.MinorUnits()).String() + " " + c.GetCurrencyString()
+}
@@ -0,0 +1,245 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maximum number of decimal places of a Decimal
+const maxDecimalScale = 18
+
+// A Decimal is an exact decimal number, like a fare price. It is stored
+// as an integer value v and a scale s, and represents v * 10^-s.
+type Decimal struct {
+	v int64
+	s int8
+}
+
+var errDecimalOverflow = errors.New("decimal overflow")
+
+// NewDecimal returns the decimal v * 10^-scale
+func NewDecimal(v int64, scale int) Decimal {
+	if scale < 0 || scale > maxDecimalScale {
+		panic(fmt.Errorf("decimal scale %d out of range", scale))
+	}
+	return Decimal{v, int8(scale)}
+}
+
+// ParseDecimal parses a decimal number like "1.25" or "-3". A comma is
+// accepted as the decimal separator.
+func ParseDecimal(str string) (Decimal, error) {
+	err := fmt.Errorf("'%s' is not a valid decimal number", str)
+
+	s := str
+	neg := false
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+
+	intPart, fracPart := s, ""
+	if i := strings.IndexAny(s, ".,"); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+
+	if (len(intPart) == 0 && len(fracPart) == 0) || !isDigit(intPart) || !isDigit(fracPart) {
+		return Decimal{}, err
+	}
+
+	if len(fracPart) > maxDecimalScale {
+		return Decimal{}, err
+	}
+
+	v, e := strconv.ParseInt(intPart+fracPart, 10, 64)
+	if e != nil {
+		return Decimal{}, err
+	}
+
+	if neg {
+		v = -v
+	}
+
+	return Decimal{v, int8(len(fracPart))}, nil
+}
+
+// Scale returns the number of decimal places
+func (d Decimal) Scale() int {
+	return int(d.s)
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of d
+func (d Decimal) Sign() int {
+	if d.v < 0 {
+		return -1
+	} else if d.v > 0 {
+		return 1
+	}
+	return 0
+}
+
+// IsZero returns true if d is zero
+func (d Decimal) IsZero() bool {
+	return d.v == 0
+}
+
+// Rescale returns d with the given number of decimal places. Decimal
+// places are cut off if the scale is reduced, see Round for rounding.
+func (d Decimal) Rescale(scale int) Decimal {
+	r, e := d.rescale(scale)
+	if e != nil {
+		panic(e)
+	}
+	return r
+}
+
+// Round rounds d half away from zero to the given number of decimal places
+func (d Decimal) Round(scale int) Decimal {
+	if scale >= int(d.s) {
+		return d
+	}
+
+	p := pow10(int(d.s) - scale)
+	q, r := d.v/p, d.v%p
+	if 2*abs(r) >= p {
+		if d.v < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+
+	return Decimal{q, int8(scale)}
+}
+
+// Add returns d+o. It panics on overflow.
+func (d Decimal) Add(o Decimal) Decimal {
+	a, b := align(d, o)
+	if (b.v > 0 && a.v > math.MaxInt64-b.v) || (b.v < 0 && a.v < math.MinInt64-b.v) {
+		panic(errDecimalOverflow)
+	}
+	return Decimal{a.v + b.v, a.s}
+}
+
+// Sub returns d-o. It panics on overflow.
+func (d Decimal) Sub(o Decimal) Decimal {
+	if o.v == math.MinInt64 {
+		panic(errDecimalOverflow)
+	}
+	return d.Add(Decimal{-o.v, o.s})
+}
+
+// Mul returns d*n. It panics on overflow.
+func (d Decimal) Mul(n int64) Decimal {
+	if n != 0 && (d.v*n/n != d.v || (d.v == -1 && n == math.MinInt64) || (n == -1 && d.v == math.MinInt64)) {
+		panic(errDecimalOverflow)
+	}
+	return Decimal{d.v * n, d.s}
+}
+
+// Cmp returns -1 if d < o, 1 if d > o, and 0 if they are equal
+func (d Decimal) Cmp(o Decimal) int {
+	if d.Sign() != o.Sign() {
+		if d.Sign() < o.Sign() {
+			return -1
+		}
+		return 1
+	}
+
+	a, b := align(d, o)
+	if a.v < b.v {
+		return -1
+	} else if a.v > b.v {
+		return 1
+	}
+	return 0
+}
+
+// Equals returns true if d and o have the same value, regardless of
+// their scale
+func (d Decimal) Equals(o Decimal) bool {
+	return d.Cmp(o) == 0
+}
+
+// Float64 returns the nearest float64 value of d
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// String returns d with exactly Scale() decimal places
+func (d Decimal) String() string {
+	str := strconv.FormatInt(d.v, 10)
+	if d.s == 0 {
+		return str
+	}
+
+	neg := d.v < 0
+	if neg {
+		str = str[1:]
+	}
+
+	if len(str) <= int(d.s) {
+		str = strings.Repeat("0", int(d.s)-len(str)+1) + str
+	}
+
+	str = str[:len(str)-int(d.s)] + "." + str[len(str)-int(d.s):]
+	if neg {
+		return "-" + str
+	}
+	return str
+}
+
+func (d Decimal) rescale(scale int) (Decimal, error) {
+	if scale < 0 || scale > maxDecimalScale {
+		return d, fmt.Errorf("decimal scale %d out of range", scale)
+	}
+
+	if scale <= int(d.s) {
+		return Decimal{d.v / pow10(int(d.s)-scale), int8(scale)}, nil
+	}
+
+	p := pow10(scale - int(d.s))
+	if d.v > math.MaxInt64/p || d.v < math.MinInt64/p {
+		return d, errDecimalOverflow
+	}
+
+	return Decimal{d.v * p, int8(scale)}, nil
+}
+
+// align brings two decimals to the same scale without losing precision
+func align(a, b Decimal) (Decimal, Decimal) {
+	var e error
+	if a.s < b.s {
+		a, e = a.rescale(int(b.s))
+	} else if b.s < a.s {
+		b, e = b.rescale(int(a.s))
+	}
+	if e != nil {
+		panic(e)
+	}
+	return a, b
+}
+
+func pow10(n int) int64 {
+	ret := int64(1)
+	for i := 0; i < n; i++ {
+		ret *= 10
+	}
+	return ret
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -10,8 +10,8 @@ package gtfs
 // certain FareAttributeRules are matched
 type FareAttribute struct {
 	ID               string
-	Price            string
-	CurrencyType     string
+	Price            Decimal
+	CurrencyType     Currency
 	PaymentMethod    int
 	Transfers        int
 	Agency           *Agency
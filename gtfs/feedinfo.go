@@ -15,7 +15,7 @@ import (
 type FeedInfo struct {
 	PublisherName string
 	PublisherURL  *url.URL
-	Lang          string
+	Lang          LanguageTag
 	StartDate     Date
 	EndDate       Date
 	Version       string
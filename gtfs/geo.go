@@ -0,0 +1,36 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfs
+
+import (
+	"math"
+)
+
+// mean earth radius in meters
+const earthRadius = 6371000.0
+
+// Haversine returns the great-circle distance in meters between two
+// points given in degrees
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Length returns the length of the shape in meters
+func (shape *Shape) Length() float64 {
+	ret := 0.0
+	for i := 1; i < len(shape.Points); i++ {
+		a, b := &shape.Points[i-1], &shape.Points[i]
+		ret += Haversine(float64(a.Lat), float64(a.Lon), float64(b.Lat), float64(b.Lon))
+	}
+	return ret
+}
@@ -13,7 +13,21 @@ import (
 
 var validISO6391 = []string{"ab", "aa", "af", "ak", "sq", "am", "ar", "an", "hy", "as", "av", "ae", "ay", "az", "bm", "ba", "eu", "be", "bn", "bh", "bi", "bs", "br", "bg", "my", "ca", "ch", "ce", "ny", "zh", "cv", "kw", "co", "cr", "hr", "cs", "da", "dv", "nl", "dz", "en", "eo", "et", "ee", "fo", "fj", "fi", "fr", "ff", "gl", "ka", "de", "el", "gn", "gu", "ht", "ha", "he", "hz", "hi", "ho", "hu", "ia", "id", "ie", "ga", "ig", "ik", "io", "is", "it", "iu", "ja", "jv", "kl", "kn", "kr", "ks", "kk", "km", "ki", "rw", "ky", "kv", "kg", "ko", "ku", "kj", "la", "lb", "lg", "li", "ln", "lo", "lt", "lu", "lv", "gv", "mk", "mg", "ms", "ml", "mt", "mi", "mr", "mh", "mn", "na", "nv", "nd", "ne", "ng", "nb", "nn", "no", "ii", "nr", "oc", "oj", "cu", "om", "or", "os", "pa", "pi", "fa", "pl", "ps", "pt", "qu", "rm", "rn", "ro", "ru", "sa", "sc", "sd", "se", "sm", "sg", "sr", "gd", "sn", "si", "sk", "sl", "so", "st", "es", "su", "sw", "ss", "sv", "ta", "te", "tg", "th", "ti", "bo", "tk", "tl", "tn", "to", "tr", "ts", "tt", "tw", "ty", "ug", "uk", "ur", "uz", "ve", "vi", "vo", "wa", "cy", "wo", "fy", "xh", "yi", "yo", "za", "zu"}
 
+// index of each code in validISO6391
+var iso6391Idx = func() map[string]int16 {
+	ret := make(map[string]int16, len(validISO6391))
+	for i, l := range validISO6391 {
+		ret[l] = int16(i)
+	}
+	return ret
+}()
+
+// deprecated language subtags and their preferred values
+var langAliases = map[string]string{"iw": "he", "in": "id", "ji": "yi", "jw": "jv", "mo": "ro"}
+
 // A LanguageISO6391 struct describes a language according to the ISO 6391 standard
+//
+// Deprecated: GTFS uses BCP 47 language tags, see LanguageTag
 type LanguageISO6391 struct {
 	l int16
 }
@@ -28,10 +42,214 @@ func (a LanguageISO6391) GetLangString() string {
 
 // NewLanguageISO6391 create a new LanguageISO6391 object
 func NewLanguageISO6391(tofind string) (LanguageISO6391, error) {
-	for i, l := range validISO6391 {
-		if l == strings.ToLower(tofind) {
-			return LanguageISO6391{int16(i)}, nil
-		}
+	if i, ok := iso6391Idx[strings.ToLower(tofind)]; ok {
+		return LanguageISO6391{i}, nil
 	}
 	return LanguageISO6391{-1}, fmt.Errorf("'%s' is not a valid ISO 639-1 code, see https://en.wikipedia.org/wiki/List_of_ISO_639-1_codes", tofind)
 }
+
+// A LanguageTag is an IETF BCP 47 language tag like "de", "de-CH" or
+// "zh-Hant-TW", in canonical form
+type LanguageTag struct {
+	tag string
+}
+
+// NewLanguageTag parses and canonicalizes a BCP 47 language tag. Subtags
+// are brought into their canonical case ("zh-hant-tw" becomes
+// "zh-Hant-TW"), deprecated language codes are replaced ("iw" becomes
+// "he"), and underscores are accepted as separators. An empty string
+// gives the empty tag.
+func NewLanguageTag(str string) (LanguageTag, error) {
+	if len(str) == 0 {
+		return LanguageTag{}, nil
+	}
+
+	subtags := strings.Split(strings.ReplaceAll(str, "_", "-"), "-")
+	err := fmt.Errorf("'%s' is not a valid BCP 47 language tag", str)
+
+	for i, st := range subtags {
+		if len(st) == 0 || len(st) > 8 || !isAlnum(st) {
+			return LanguageTag{}, err
+		}
+		subtags[i] = strings.ToLower(st)
+	}
+
+	// primary language, or private use
+	lang := subtags[0]
+	if lang != "x" {
+		if !isAlpha(lang) || len(lang) == 1 || len(lang) == 4 {
+			return LanguageTag{}, err
+		}
+		if alias, ok := langAliases[lang]; ok {
+			lang = alias
+		}
+		if _, ok := iso6391Idx[lang]; len(lang) == 2 && !ok {
+			return LanguageTag{}, fmt.Errorf("'%s' is not a valid BCP 47 language tag, '%s' is not an ISO 639 language code", str, lang)
+		}
+		subtags[0] = lang
+	}
+
+	// position in the order extlang, script, region, variants,
+	// extensions, private use
+	pos := 0
+	extlangs := 0
+	singleton := false
+
+	for i := 1; i < len(subtags); i++ {
+		st := subtags[i]
+
+		if singleton && len(st) < 2 {
+			// extensions need at least one subtag of length 2-8
+			return LanguageTag{}, err
+		}
+
+		switch {
+		case subtags[0] == "x" || pos == 5:
+			// private use, everything goes
+		case len(st) == 1:
+			if st == "x" {
+				pos = 5
+			} else {
+				pos = 4
+				singleton = true
+				continue
+			}
+		case pos == 4:
+			// extension subtags
+		case pos == 0 && len(st) == 3 && isAlpha(st) && len(lang) <= 3 && extlangs < 3:
+			extlangs++
+		case pos <= 1 && len(st) == 4 && isAlpha(st):
+			subtags[i] = strings.ToUpper(st[:1]) + st[1:]
+			pos = 2
+		case pos <= 2 && ((len(st) == 2 && isAlpha(st)) || (len(st) == 3 && isDigit(st))):
+			subtags[i] = strings.ToUpper(st)
+			pos = 3
+		case pos <= 3 && (len(st) >= 5 || (len(st) == 4 && st[0] >= '0' && st[0] <= '9')):
+			pos = 3
+		default:
+			return LanguageTag{}, err
+		}
+
+		singleton = false
+	}
+
+	if singleton || (len(subtags) > 1 && subtags[len(subtags)-1] == "x") || subtags[0] == "x" && len(subtags) == 1 {
+		// singleton without subtags
+		return LanguageTag{}, err
+	}
+
+	return LanguageTag{strings.Join(subtags, "-")}, nil
+}
+
+// GetLangString returns the canonical string representation of the tag
+func (t LanguageTag) GetLangString() string {
+	return t.tag
+}
+
+// String returns the canonical string representation of the tag
+func (t LanguageTag) String() string {
+	return t.tag
+}
+
+// IsEmpty returns true if the tag is empty
+func (t LanguageTag) IsEmpty() bool {
+	return len(t.tag) == 0
+}
+
+// Language returns the primary language subtag, e.g. "zh" for "zh-Hant-TW"
+func (t LanguageTag) Language() string {
+	if i := strings.IndexByte(t.tag, '-'); i >= 0 {
+		return t.tag[:i]
+	}
+	return t.tag
+}
+
+// Script returns the script subtag, e.g. "Hant" for "zh-Hant-TW", or an
+// empty string
+func (t LanguageTag) Script() string {
+	for _, st := range t.subtags()[1:] {
+		if len(st) == 1 {
+			break
+		}
+		if len(st) == 4 && st[0] >= 'A' && st[0] <= 'Z' {
+			return st
+		}
+	}
+	return ""
+}
+
+// Region returns the region subtag, e.g. "TW" for "zh-Hant-TW", or an
+// empty string
+func (t LanguageTag) Region() string {
+	for _, st := range t.subtags()[1:] {
+		if len(st) == 1 {
+			break
+		}
+		if (len(st) == 2 || len(st) == 3) && st == strings.ToUpper(st) {
+			return st
+		}
+	}
+	return ""
+}
+
+// Parent returns the tag with its last subtag removed, e.g. "zh-Hant" for
+// "zh-Hant-TW", or the empty tag for a tag with only a primary language.
+// This is the fallback order of RFC 4647 lookup matching.
+func (t LanguageTag) Parent() LanguageTag {
+	subtags := t.subtags()
+	if len(subtags) <= 1 {
+		return LanguageTag{}
+	}
+
+	subtags = subtags[:len(subtags)-1]
+
+	// drop singletons left without subtags
+	for len(subtags) > 1 && len(subtags[len(subtags)-1]) == 1 {
+		subtags = subtags[:len(subtags)-1]
+	}
+
+	return LanguageTag{strings.Join(subtags, "-")}
+}
+
+// Matches returns true if t equals o, or if t is a parent of o (e.g. "de"
+// matches "de-CH")
+func (t LanguageTag) Matches(o LanguageTag) bool {
+	if t.IsEmpty() || o.IsEmpty() {
+		return t == o
+	}
+	return t.tag == o.tag || strings.HasPrefix(o.tag, t.tag+"-")
+}
+
+func (t LanguageTag) subtags() []string {
+	if t.IsEmpty() {
+		return []string{""}
+	}
+	return strings.Split(t.tag, "-")
+}
+
+func isAlpha(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !(s[i] >= 'a' && s[i] <= 'z') && !(s[i] >= 'A' && s[i] <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAlpha(s[i:i+1]) && !isDigit(s[i:i+1]) {
+			return false
+		}
+	}
+	return true
+}
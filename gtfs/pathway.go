@@ -22,3 +22,14 @@ type Pathway struct {
 	ReversedSignpostedAs string
 	Translations         []*Translation
 }
+
+// Pathway modes
+const (
+	PathwayWalkway       = 1
+	PathwayStairs        = 2
+	PathwayMovingWalkway = 3
+	PathwayEscalator     = 4
+	PathwayElevator      = 5
+	PathwayFareGate      = 6
+	PathwayExitGate      = 7
+)
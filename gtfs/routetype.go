@@ -0,0 +1,251 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfs
+
+import (
+	"sort"
+)
+
+// RouteType is a basic GTFS route type, or an extended route type from
+// the Hierarchical Vehicle Type (HVT) list
+type RouteType int16
+
+type routeTypeInfo struct {
+	name     string
+	category string
+	basic    RouteType // -1 if there is no equivalent basic type
+}
+
+var routeTypes = map[RouteType]routeTypeInfo{
+	0:    {"Tram, Streetcar, Light rail", "Basic", 0},
+	1:    {"Subway, Metro", "Basic", 1},
+	2:    {"Rail", "Basic", 2},
+	3:    {"Bus", "Basic", 3},
+	4:    {"Ferry", "Basic", 4},
+	5:    {"Cable tram", "Basic", 5},
+	6:    {"Aerial lift", "Basic", 6},
+	7:    {"Funicular", "Basic", 7},
+	11:   {"Trolleybus", "Basic", 11},
+	12:   {"Monorail", "Basic", 12},
+	100:  {"Railway Service", "Railway", 2},
+	101:  {"High Speed Rail Service", "Railway", 2},
+	102:  {"Long Distance Trains", "Railway", 2},
+	103:  {"Inter Regional Rail Service", "Railway", 2},
+	104:  {"Car Transport Rail Service", "Railway", 2},
+	105:  {"Sleeper Rail Service", "Railway", 2},
+	106:  {"Regional Rail Service", "Railway", 2},
+	107:  {"Tourist Railway Service", "Railway", 2},
+	108:  {"Rail Shuttle (Within Complex)", "Railway", 2},
+	109:  {"Suburban Railway", "Railway", 2},
+	110:  {"Replacement Rail Service", "Railway", 2},
+	111:  {"Special Rail Service", "Railway", 2},
+	112:  {"Lorry Transport Rail Service", "Railway", 2},
+	113:  {"All Rail Services", "Railway", 2},
+	114:  {"Cross-Country Rail Service", "Railway", 2},
+	115:  {"Vehicle Transport Rail Service", "Railway", 2},
+	116:  {"Rack and Pinion Railway", "Railway", 7},
+	117:  {"Additional Rail Service", "Railway", 2},
+	200:  {"Coach Service", "Coach", 3},
+	201:  {"International Coach Service", "Coach", 3},
+	202:  {"National Coach Service", "Coach", 3},
+	203:  {"Shuttle Coach Service", "Coach", 3},
+	204:  {"Regional Coach Service", "Coach", 3},
+	205:  {"Special Coach Service", "Coach", 3},
+	206:  {"Sightseeing Coach Service", "Coach", 3},
+	207:  {"Tourist Coach Service", "Coach", 3},
+	208:  {"Commuter Coach Service", "Coach", 3},
+	209:  {"All Coach Services", "Coach", 3},
+	300:  {"Suburban Railway Service", "Suburban Railway", 2},
+	400:  {"Urban Railway Service", "Urban Railway", 1},
+	401:  {"Metro Service", "Urban Railway", 1},
+	402:  {"Underground Service", "Urban Railway", 1},
+	403:  {"Urban Railway Service", "Urban Railway", 1},
+	404:  {"All Urban Railway Services", "Urban Railway", 1},
+	405:  {"Monorail", "Urban Railway", 12},
+	500:  {"Metro Service", "Metro", 1},
+	600:  {"Underground Service", "Underground", 1},
+	700:  {"Bus Service", "Bus", 3},
+	701:  {"Regional Bus Service", "Bus", 3},
+	702:  {"Express Bus Service", "Bus", 3},
+	703:  {"Stopping Bus Service", "Bus", 3},
+	704:  {"Local Bus Service", "Bus", 3},
+	705:  {"Night Bus Service", "Bus", 3},
+	706:  {"Post Bus Service", "Bus", 3},
+	707:  {"Special Needs Bus", "Bus", 3},
+	708:  {"Mobility Bus Service", "Bus", 3},
+	709:  {"Mobility Bus for Registered Disabled", "Bus", 3},
+	710:  {"Sightseeing Bus", "Bus", 3},
+	711:  {"Shuttle Bus", "Bus", 3},
+	712:  {"School Bus", "Bus", 3},
+	713:  {"School and Public Service Bus", "Bus", 3},
+	714:  {"Rail Replacement Bus Service", "Bus", 3},
+	715:  {"Demand and Response Bus Service", "Bus", 3},
+	716:  {"All Bus Services", "Bus", 3},
+	717:  {"Share Taxi Service", "Bus", 3},
+	800:  {"Trolleybus Service", "Trolleybus", 11},
+	900:  {"Tram Service", "Tram", 0},
+	901:  {"City Tram Service", "Tram", 0},
+	902:  {"Local Tram Service", "Tram", 0},
+	903:  {"Regional Tram Service", "Tram", 0},
+	904:  {"Sightseeing Tram Service", "Tram", 0},
+	905:  {"Shuttle Tram Service", "Tram", 0},
+	906:  {"All Tram Services", "Tram", 0},
+	1000: {"Water Transport Service", "Water Transport", 4},
+	1001: {"International Car Ferry Service", "Water Transport", 4},
+	1002: {"National Car Ferry Service", "Water Transport", 4},
+	1003: {"Regional Car Ferry Service", "Water Transport", 4},
+	1004: {"Local Car Ferry Service", "Water Transport", 4},
+	1005: {"International Passenger Ferry Service", "Water Transport", 4},
+	1006: {"National Passenger Ferry Service", "Water Transport", 4},
+	1007: {"Regional Passenger Ferry Service", "Water Transport", 4},
+	1008: {"Local Passenger Ferry Service", "Water Transport", 4},
+	1009: {"Post Boat Service", "Water Transport", 4},
+	1010: {"Train Ferry Service", "Water Transport", 4},
+	1011: {"Road-Link Ferry Service", "Water Transport", 4},
+	1012: {"Airport-Link Ferry Service", "Water Transport", 4},
+	1013: {"Car High-Speed Ferry Service", "Water Transport", 4},
+	1014: {"Passenger High-Speed Ferry Service", "Water Transport", 4},
+	1015: {"Sightseeing Boat Service", "Water Transport", 4},
+	1016: {"School Boat", "Water Transport", 4},
+	1017: {"Cable-Drawn Boat Service", "Water Transport", 4},
+	1018: {"River Bus Service", "Water Transport", 4},
+	1019: {"Scheduled Ferry Service", "Water Transport", 4},
+	1020: {"Shuttle Ferry Service", "Water Transport", 4},
+	1021: {"All Water Transport Services", "Water Transport", 4},
+	1100: {"Air Service", "Air", -1},
+	1101: {"International Air Service", "Air", -1},
+	1102: {"Domestic Air Service", "Air", -1},
+	1103: {"Intercontinental Air Service", "Air", -1},
+	1104: {"Domestic Scheduled Air Service", "Air", -1},
+	1105: {"Shuttle Air Service", "Air", -1},
+	1106: {"Intercontinental Charter Air Service", "Air", -1},
+	1107: {"International Charter Air Service", "Air", -1},
+	1108: {"Round-Trip Charter Air Service", "Air", -1},
+	1109: {"Sightseeing Air Service", "Air", -1},
+	1110: {"Helicopter Air Service", "Air", -1},
+	1111: {"Domestic Charter Air Service", "Air", -1},
+	1112: {"Schengen-Area Air Service", "Air", -1},
+	1113: {"Airship Service", "Air", -1},
+	1114: {"All Air Services", "Air", -1},
+	1200: {"Ferry Service", "Ferry", 4},
+	1300: {"Aerial Lift Service", "Aerial Lift", 6},
+	1301: {"Telecabin Service", "Aerial Lift", 6},
+	1302: {"Cable Car Service", "Aerial Lift", 7},
+	1303: {"Elevator Service", "Aerial Lift", 7},
+	1304: {"Chair Lift Service", "Aerial Lift", 6},
+	1305: {"Drag Lift Service", "Aerial Lift", 6},
+	1306: {"Small Telecabin Service", "Aerial Lift", 6},
+	1307: {"All Telecabin Services", "Aerial Lift", 6},
+	1400: {"Funicular Service", "Funicular", 7},
+	1401: {"Funicular Service", "Funicular", 7},
+	1402: {"All Funicular Services", "Funicular", 7},
+	1500: {"Taxi Service", "Taxi", 3},
+	1501: {"Communal Taxi Service", "Taxi", 3},
+	1502: {"Water Taxi Service", "Taxi", 4},
+	1503: {"Rail Taxi Service", "Taxi", 2},
+	1504: {"Bike Taxi Service", "Taxi", 3},
+	1505: {"Licensed Taxi Service", "Taxi", 3},
+	1506: {"Private Hire Service Vehicle", "Taxi", 3},
+	1507: {"All Taxi Services", "Taxi", 3},
+	1600: {"Self Drive", "Self Drive", -1},
+	1601: {"Hire Car", "Self Drive", -1},
+	1602: {"Hire Van", "Self Drive", -1},
+	1603: {"Hire Motorbike", "Self Drive", -1},
+	1604: {"Hire Cycle", "Self Drive", -1},
+	1700: {"Miscellaneous Service", "Miscellaneous", -1},
+	1701: {"Cable Car", "Miscellaneous", 5},
+	1702: {"Horse-drawn Carriage", "Miscellaneous", -1},
+}
+
+// representative extended types of the basic types
+var extendedRouteTypes = map[RouteType]RouteType{
+	0:  900,
+	1:  400,
+	2:  100,
+	3:  700,
+	4:  1200,
+	5:  1701,
+	6:  1300,
+	7:  1400,
+	11: 800,
+	12: 405,
+}
+
+// RouteTypes returns all known route types in ascending order
+func RouteTypes() []RouteType {
+	ret := make([]RouteType, 0, len(routeTypes))
+	for t := range routeTypes {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
+
+// IsValid returns true if t is a known basic or extended route type
+func (t RouteType) IsValid() bool {
+	_, ok := routeTypes[t]
+	return ok
+}
+
+// IsBasic returns true if t is one of the basic GTFS route types
+func (t RouteType) IsBasic() bool {
+	_, ok := extendedRouteTypes[t]
+	return ok
+}
+
+// Name returns the human-readable name of t, or an empty string if t is
+// not a known route type
+func (t RouteType) Name() string {
+	return routeTypes[t].name
+}
+
+// Category returns the HVT category (e.g. "Railway" or "Bus") of t, or
+// "Basic" for basic route types
+func (t RouteType) Category() string {
+	return routeTypes[t].category
+}
+
+// BasicType returns the basic route type equivalent to t. The second
+// return value is false if t is unknown or there is no equivalent basic
+// type (for example for air services).
+func (t RouteType) BasicType() (RouteType, bool) {
+	info, ok := routeTypes[t]
+	if !ok || info.basic < 0 {
+		return -1, false
+	}
+	return info.basic, true
+}
+
+// ExtendedType returns a representative extended route type of the basic
+// route type t (for example 700 for bus), or t itself if t is already an
+// extended route type. The second return value is false if t is unknown.
+func (t RouteType) ExtendedType() (RouteType, bool) {
+	if !t.IsValid() {
+		return -1, false
+	}
+	if ext, ok := extendedRouteTypes[t]; ok {
+		return ext, true
+	}
+	return t, true
+}
+
+// Matches returns true if t and o describe the same route type, either
+// directly or via their basic types. A basic type matches all extended
+// types mapped to it.
+func (t RouteType) Matches(o RouteType) bool {
+	if t == o {
+		return true
+	}
+
+	if t.IsBasic() == o.IsBasic() {
+		return false
+	}
+
+	bt, ok := t.BasicType()
+	bo, ok2 := o.BasicType()
+	return ok && ok2 && bt == bo
+}
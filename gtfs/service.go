@@ -6,7 +6,10 @@
 
 package gtfs
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // A Service object describes exactly on what days a trip is served
 type Service struct {
@@ -232,3 +235,16 @@ func (d *Service) IsEmpty() bool {
 func (d Date) GetTime() time.Time {
 	return time.Date(int(d.Year()), time.Month(d.Month()), int(d.Day()), 12, 0, 0, 0, time.UTC)
 }
+
+// String returns the date in the GTFS format YYYYMMDD
+func (d Date) String() string {
+	if d.IsEmpty() {
+		return ""
+	}
+	return fmt.Sprintf("%04d%02d%02d", d.Year(), d.Month(), d.Day())
+}
+
+// MarshalText returns the date in the GTFS format YYYYMMDD
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
@@ -7,6 +7,8 @@
 package gtfs
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"time"
 )
@@ -25,13 +27,70 @@ type StopTime struct {
 // StopTimes group multiple StopTime objects
 type StopTimes []StopTime
 
-// Time is a simple GTFS time type
+// Time is a simple GTFS time type. Hours may exceed 23 for trips
+// running past midnight of their service day.
 type Time struct {
-	Hour   int8
+	Hour   int16
 	Minute int8
 	Second int8
 }
 
+// MaxTimeSeconds is the number of seconds of the latest representable Time
+const MaxTimeSeconds = math.MaxInt16*3600 + 59*60 + 59
+
+// EmptyTime returns an empty Time
+func EmptyTime() Time {
+	return Time{-1, -1, -1}
+}
+
+// NewTimeFromSeconds returns the Time the given number of seconds after
+// noon minus 12h of the service day
+func NewTimeFromSeconds(s int) (Time, error) {
+	if s < 0 || s > MaxTimeSeconds {
+		return EmptyTime(), fmt.Errorf("Time of %d seconds out of range", s)
+	}
+	return Time{int16(s / 3600), int8((s / 60) % 60), int8(s % 60)}, nil
+}
+
+// ParseTime parses a time in HH:MM:SS or H:MM:SS format. Hours may have
+// more than two digits, minutes and seconds must be in the range 00-59.
+func ParseTime(str string) (Time, error) {
+	i := 0
+	hour := 0
+
+	for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+		hour = hour*10 + int(str[i]-'0')
+		i++
+		if hour > math.MaxInt16 {
+			return EmptyTime(), fmt.Errorf("Max representable hour is %d", math.MaxInt16)
+		}
+	}
+
+	if i == 0 || (i > 2 && str[0] == '0') {
+		return EmptyTime(), errors.New("Expected H:MM:SS or HH:MM:SS")
+	}
+
+	minute, ok := parseTimeField(str[i:])
+	if !ok {
+		return EmptyTime(), errors.New("Expected H:MM:SS or HH:MM:SS")
+	}
+
+	second, ok := parseTimeField(str[i+3:])
+	if !ok || len(str) != i+6 {
+		return EmptyTime(), errors.New("Expected H:MM:SS or HH:MM:SS")
+	}
+
+	return Time{int16(hour), int8(minute), int8(second)}, nil
+}
+
+// parseTimeField parses a ":MM" or ":SS" prefix of str
+func parseTimeField(str string) (int, bool) {
+	if len(str) < 3 || str[0] != ':' || str[1] < '0' || str[1] > '5' || str[2] < '0' || str[2] > '9' {
+		return 0, false
+	}
+	return int(str[1]-'0')*10 + int(str[2]-'0'), true
+}
+
 func (st *StopTime) Sequence() int {
 	if st.Seq == 0 {
 		return 1
@@ -126,10 +185,78 @@ func (a Time) Equals(b Time) bool {
 }
 
 // SecondsSinceMidnight returns the number of seconds since midnight
+// (more precisely, since noon minus 12h) of the service day
 func (a Time) SecondsSinceMidnight() int {
 	return int(a.Hour)*3600 + int(a.Minute)*60 + int(a.Second)
 }
 
+// Add returns the time a+d, rounded down to full seconds. It panics if
+// the result is not representable. Adding to an empty time yields an
+// empty time.
+func (a Time) Add(d time.Duration) Time {
+	if a.Empty() {
+		return a
+	}
+	secs := d / time.Second
+	if d%time.Second < 0 {
+		secs--
+	}
+	t, e := NewTimeFromSeconds(a.SecondsSinceMidnight() + int(secs))
+	if e != nil {
+		panic(e)
+	}
+	return t
+}
+
+// Sub returns the duration a-b
+func (a Time) Sub(b Time) time.Duration {
+	return time.Duration(a.Minus(b)) * time.Second
+}
+
+// Compare returns -1 if a is before b, 1 if a is after b, and 0 if they
+// are equal. Empty times are before all other times.
+func (a Time) Compare(b Time) int {
+	if a.Empty() || b.Empty() {
+		if a.Empty() == b.Empty() {
+			return 0
+		} else if a.Empty() {
+			return -1
+		}
+		return 1
+	}
+
+	sa, sb := a.SecondsSinceMidnight(), b.SecondsSinceMidnight()
+	if sa < sb {
+		return -1
+	} else if sa > sb {
+		return 1
+	}
+	return 0
+}
+
+// Before returns true if a is before b
+func (a Time) Before(b Time) bool {
+	return a.Compare(b) < 0
+}
+
+// After returns true if a is after b
+func (a Time) After(b Time) bool {
+	return a.Compare(b) > 0
+}
+
+// String returns the time in HH:MM:SS format
+func (a Time) String() string {
+	if a.Empty() {
+		return ""
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", a.Hour, a.Minute, a.Second)
+}
+
+// MarshalText returns the time in HH:MM:SS format
+func (a Time) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
 // GetLocationTime returns the time.Time of the gtfs time on a certain
 // date, for a certain agency (which itself holds a timezone)
 func (a Time) GetLocationTime(d Date, agency *Agency) time.Time {
@@ -9,7 +9,23 @@ package gtfs
 // A Translation holds a single translation for an entity in a GTFS table
 type Translation struct {
 	FieldName   string
-	Language    LanguageISO6391
+	Language    LanguageTag
 	Translation string
 	FieldValue  string
 }
+
+// Translate returns the translation of a field into lang from a list of
+// translations. If there is no translation for lang, less specific tags
+// are tried ("de-CH-1996", then "de-CH", then "de"). The parser does not
+// apply translations itself, Translate has to be called on the
+// translations of an entity.
+func Translate(trs []*Translation, fieldName string, lang LanguageTag) (string, bool) {
+	for tag := lang; !tag.IsEmpty(); tag = tag.Parent() {
+		for _, tr := range trs {
+			if tr.FieldName == fieldName && tr.Language == tag {
+				return tr.Translation, true
+			}
+		}
+	}
+	return "", false
+}
@@ -23,4 +23,34 @@ type Trip struct {
 	DirectionID          int8
 	WheelchairAccessible int8
 	BikesAllowed         int8
+
+	// if set, StopTimes is empty and the stop times are stored here
+	CompactStopTimes *CompactStopTimes
+}
+
+// NumStopTimes returns the number of stop times of this trip, regardless
+// of whether they are stored compactly
+func (t *Trip) NumStopTimes() int {
+	if t.CompactStopTimes != nil {
+		return t.CompactStopTimes.Len()
+	}
+	return len(t.StopTimes)
+}
+
+// GetStopTime returns the i-th stop time of this trip, regardless of
+// whether the stop times are stored compactly
+func (t *Trip) GetStopTime(i int) StopTime {
+	if t.CompactStopTimes != nil {
+		return t.CompactStopTimes.StopTime(i)
+	}
+	return t.StopTimes[i]
+}
+
+// GetStopTimes returns the stop times of this trip. If they are stored
+// compactly, a copy is returned.
+func (t *Trip) GetStopTimes() StopTimes {
+	if t.CompactStopTimes != nil {
+		return t.CompactStopTimes.StopTimes()
+	}
+	return t.StopTimes
 }
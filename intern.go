@@ -0,0 +1,132 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"strings"
+	"sync"
+	"unsafe"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// MemStats holds statistics about the memory used by a feed
+type MemStats struct {
+	// number of distinct interned strings, and their total length
+	InternedStrings int
+	InternedBytes   int64
+
+	// number of strings passed to the interner, and how many of them
+	// were already known
+	InternLookups int64
+	InternHits    int64
+
+	// bytes saved by interning
+	InternSavedBytes int64
+
+	// number of stop times, and the approximate memory used by them
+	StopTimes     int
+	StopTimeBytes int64
+
+	// number of distinct patterns of compactly stored stop times
+	StopPatterns int
+	TimePatterns int
+}
+
+// stringInterner deduplicates strings
+type stringInterner struct {
+	mutex      sync.Mutex
+	strs       map[string]*string
+	bytes      int64
+	lookups    int64
+	hits       int64
+	savedBytes int64
+}
+
+func newStringInterner() *stringInterner {
+	return &stringInterner{strs: make(map[string]*string)}
+}
+
+// get returns a pointer to the interned version of s
+func (si *stringInterner) get(s string) *string {
+	si.mutex.Lock()
+	defer si.mutex.Unlock()
+
+	si.lookups++
+
+	if p, ok := si.strs[s]; ok {
+		si.hits++
+		si.savedBytes += int64(len(s))
+		return p
+	}
+
+	// s is usually part of a CSV line, don't keep the line alive
+	p := new(string)
+	*p = strings.Clone(s)
+	si.strs[*p] = p
+	si.bytes += int64(len(s))
+
+	return p
+}
+
+// intern returns the interned version of s, if interning is enabled
+func (feed *Feed) intern(s string) string {
+	if feed.interner == nil || len(s) == 0 {
+		return s
+	}
+	return *feed.interner.get(s)
+}
+
+// sharedString returns a pointer to s, which is shared with other
+// occurrences of s. Without interning, only consecutive occurrences
+// share a pointer.
+func (feed *Feed) sharedString(s string) *string {
+	if feed.interner != nil {
+		return feed.interner.get(s)
+	}
+
+	if *feed.lastString != s {
+		feed.lastString = &s
+	}
+	return feed.lastString
+}
+
+// MemoryStats returns statistics about the memory used by the feed
+func (feed *Feed) MemoryStats() MemStats {
+	stats := MemStats{}
+
+	if feed.interner != nil {
+		feed.interner.mutex.Lock()
+		stats.InternedStrings = len(feed.interner.strs)
+		stats.InternedBytes = feed.interner.bytes
+		stats.InternLookups = feed.interner.lookups
+		stats.InternHits = feed.interner.hits
+		stats.InternSavedBytes = feed.interner.savedBytes
+		feed.interner.mutex.Unlock()
+	}
+
+	stopTimeSize := int64(unsafe.Sizeof(gtfs.StopTime{}))
+
+	for _, t := range feed.Trips {
+		if t == nil {
+			continue
+		}
+		stats.StopTimes += t.NumStopTimes()
+		stats.StopTimeBytes += int64(cap(t.StopTimes)) * stopTimeSize
+		if t.CompactStopTimes != nil {
+			stats.StopTimeBytes += int64(unsafe.Sizeof(*t.CompactStopTimes))
+		}
+	}
+
+	if feed.compactor != nil {
+		stats.StopPatterns = feed.compactor.NumStopPatterns()
+		stats.TimePatterns = feed.compactor.NumTimePatterns()
+		stats.StopTimeBytes += feed.compactor.PatternBytes()
+	}
+
+	return stats
+}
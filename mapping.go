@@ -684,9 +684,9 @@ func createTranslation(r []string, flds TranslationFields, feed *Feed, prefix st
 
 	tr := new(gtfs.Translation)
 	tr.FieldName = getString(flds.fieldName, r, flds, true, true, "")
-	tr.Translation = getString(flds.translation, r, flds, true, true, "")
-	tr.FieldValue = getString(flds.fieldValue, r, flds, false, false, "")
-	tr.Language = getIsoLangCode(flds.language, r, flds, false, false, feed)
+	tr.Translation = feed.intern(getString(flds.translation, r, flds, true, true, ""))
+	tr.FieldValue = feed.intern(getString(flds.fieldValue, r, flds, false, false, ""))
+	tr.Language = getLanguageTag(flds.language, r, flds, false, false, "", feed)
 
 	tableName := getString(flds.tableName, r, flds, true, true, "")
 
@@ -752,14 +752,14 @@ func createAttribution(r []string, flds AttributionFields, feed *Feed, prefix st
 	a := new(gtfs.Attribution)
 
 	a.ID = prefix + getString(flds.attributionId, r, flds, false, false, "")
-	a.OrganizationName = getString(flds.organizationName, r, flds, true, true, feed.opts.EmptyStringRepl)
+	a.OrganizationName = feed.intern(getString(flds.organizationName, r, flds, true, true, feed.opts.EmptyStringRepl))
 	a.IsProducer = getBool(flds.isProducer, r, flds, false, false, feed.opts.UseDefValueOnError, feed)
 	a.IsOperator = getBool(flds.isOperator, r, flds, false, false, feed.opts.UseDefValueOnError, feed)
 	a.IsAuthority = getBool(flds.isAuthority, r, flds, false, false, feed.opts.UseDefValueOnError, feed)
 
 	a.URL = getURL(flds.attributionUrl, r, flds, false, feed.opts.UseDefValueOnError, feed)
 	a.Email = getMail(flds.attributionEmail, r, flds, false, feed.opts.UseDefValueOnError, feed)
-	a.Phone = getString(flds.attributionPhone, r, flds, false, false, feed.opts.EmptyStringRepl)
+	a.Phone = feed.intern(getString(flds.attributionPhone, r, flds, false, false, feed.opts.EmptyStringRepl))
 
 	routeId := getString(flds.routeId, r, flds, false, false, "")
 	agencyId := getString(flds.agencyId, r, flds, false, false, "")
@@ -805,11 +805,11 @@ func createAgency(r []string, flds AgencyFields, feed *Feed, prefix string) (ag
 	a := new(gtfs.Agency)
 
 	a.ID = prefix + getString(flds.agencyId, r, flds, false, false, "")
-	a.Name = getString(flds.agencyName, r, flds, true, true, feed.opts.EmptyStringRepl)
+	a.Name = feed.intern(getString(flds.agencyName, r, flds, true, true, feed.opts.EmptyStringRepl))
 	a.URL = getURL(flds.agencyUrl, r, flds, true, feed.opts.UseDefValueOnError, feed)
 	a.Timezone = getTimezone(flds.agencyTimezone, r, flds, true, feed.opts.UseDefValueOnError, feed)
-	a.Lang = getIsoLangCode(flds.agencyLang, r, flds, false, feed.opts.UseDefValueOnError, feed)
-	a.Phone = getString(flds.agencyPhone, r, flds, false, false, "")
+	a.Lang = getLanguageTag(flds.agencyLang, r, flds, false, feed.opts.UseDefValueOnError, "", feed)
+	a.Phone = feed.intern(getString(flds.agencyPhone, r, flds, false, false, ""))
 	a.FareURL = getURL(flds.agencyFareUrl, r, flds, false, feed.opts.UseDefValueOnError, feed)
 	a.Email = getMail(flds.agencyEmail, r, flds, false, feed.opts.UseDefValueOnError, feed)
 
@@ -826,7 +826,7 @@ func createFeedInfo(r []string, flds FeedInfoFields, feed *Feed) (fi *gtfs.FeedI
 
 	f.PublisherName = getString(flds.feedPublisherName, r, flds, true, true, feed.opts.EmptyStringRepl)
 	f.PublisherURL = getURL(flds.feedPublisherUrl, r, flds, true, feed.opts.UseDefValueOnError, feed)
-	f.Lang = getString(flds.feedLang, r, flds, true, true, feed.opts.EmptyStringRepl)
+	f.Lang = getLanguageTag(flds.feedLang, r, flds, true, feed.opts.UseDefValueOnError, feed.opts.EmptyStringRepl, feed)
 	f.StartDate = getDate(flds.feedStartDate, r, flds, false, feed.opts.UseDefValueOnError, feed)
 	f.EndDate = getDate(flds.feedEndDate, r, flds, false, feed.opts.UseDefValueOnError, feed)
 	f.Version = getString(flds.feedVersion, r, flds, false, false, "")
@@ -854,8 +854,8 @@ func createFrequency(r []string, flds FrequencyFields, feed *Feed, prefix string
 	}
 
 	a.ExactTimes = getBool(flds.exactTimes, r, flds, false, false, feed.opts.UseDefValueOnError, feed)
-	a.StartTime = getTime(flds.startTime, r, flds)
-	a.EndTime = getTime(flds.endTime, r, flds)
+	a.StartTime = getTime(flds.startTime, r, flds, feed)
+	a.EndTime = getTime(flds.endTime, r, flds, feed)
 
 	if a.StartTime.SecondsSinceMidnight() > a.EndTime.SecondsSinceMidnight() {
 		panic(errors.New("Frequency has start_time > end_time."))
@@ -925,8 +925,8 @@ func createRoute(r []string, flds RouteFields, feed *Feed, prefix string) (route
 		return nil, errors.New("No agency given for route " + a.ID + ", an agency is required as there is more than one agency in agency.txt.")
 	}
 
-	a.ShortName = getString(flds.routeShortName, r, flds, false, false, "")
-	a.LongName = getString(flds.routeLongName, r, flds, false, false, "")
+	a.ShortName = feed.intern(getString(flds.routeShortName, r, flds, false, false, ""))
+	a.LongName = feed.intern(getString(flds.routeLongName, r, flds, false, false, ""))
 
 	if len(a.ShortName) == 0 && len(a.LongName) == 0 {
 		if feed.opts.UseDefValueOnError {
@@ -940,11 +940,11 @@ func createRoute(r []string, flds RouteFields, feed *Feed, prefix string) (route
 		a.LongName = ""
 	}
 
-	a.Desc = getString(flds.routeDesc, r, flds, false, false, "")
+	a.Desc = feed.intern(getString(flds.routeDesc, r, flds, false, false, ""))
 	a.Type = int16(getRangeInt(flds.routeType, r, flds, true, 0, 1702)) // allow extended route types
 	a.URL = getURL(flds.routeUrl, r, flds, false, feed.opts.UseDefValueOnError, feed)
-	a.Color = getColor(flds.routeColor, r, flds, false, "ffffff", feed.opts.UseDefValueOnError, feed)
-	a.TextColor = getColor(flds.routeTextColor, r, flds, false, "000000", feed.opts.UseDefValueOnError, feed)
+	a.Color = feed.intern(getColor(flds.routeColor, r, flds, false, "ffffff", feed.opts.UseDefValueOnError, feed))
+	a.TextColor = feed.intern(getColor(flds.routeTextColor, r, flds, false, "000000", feed.opts.UseDefValueOnError, feed))
 	a.SortOrder = getPositiveIntWithDefault(flds.routeSortOrder, r, flds, -1, feed.opts.UseDefValueOnError, feed)
 	a.ContinuousPickup = int8(getRangeIntWithDefault(flds.continuousPickup, r, flds, 0, 3, 1, feed.opts.UseDefValueOnError, feed))
 	a.ContinuousDropOff = int8(getRangeIntWithDefault(flds.continuousDropOff, r, flds, 0, 3, 1, feed.opts.UseDefValueOnError, feed))
@@ -1029,10 +1029,10 @@ func createStop(r []string, flds StopFields, feed *Feed, prefix string) (s *gtfs
 	parentId := ""
 
 	a.ID = prefix + getString(flds.stopId, r, flds, true, true, "")
-	a.Code = getString(flds.stopCode, r, flds, false, false, "")
+	a.Code = feed.intern(getString(flds.stopCode, r, flds, false, false, ""))
 	a.LocationType = int8(getRangeIntWithDefault(flds.locationType, r, flds, 0, 4, 0, feed.opts.UseDefValueOnError, feed))
-	a.Name = getString(flds.stopName, r, flds, a.LocationType < 3, a.LocationType < 3, feed.opts.EmptyStringRepl)
-	a.Desc = getString(flds.stopDesc, r, flds, false, false, "")
+	a.Name = feed.intern(getString(flds.stopName, r, flds, a.LocationType < 3, a.LocationType < 3, feed.opts.EmptyStringRepl))
+	a.Desc = feed.intern(getString(flds.stopDesc, r, flds, false, false, ""))
 
 	if a.LocationType < 3 {
 		a.Lat = getFloat(flds.stopLat, r, flds, true)
@@ -1082,7 +1082,7 @@ func createStop(r []string, flds StopFields, feed *Feed, prefix string) (s *gtfs
 		panic(fmt.Errorf("Expected coordinate (lat, lon), instead found (0, 0), which is in the middle of the atlantic."))
 	}
 
-	a.ZoneID = prefix + getString(flds.zoneId, r, flds, false, false, "")
+	a.ZoneID = feed.intern(prefix + getString(flds.zoneId, r, flds, false, false, ""))
 	if len(a.ZoneID) == len(prefix) {
 		a.ZoneID = ""
 	}
@@ -1097,7 +1097,7 @@ func createStop(r []string, flds StopFields, feed *Feed, prefix string) (s *gtfs
 		parentId = prefix + getString(flds.parentStation, r, flds, false, false, "")
 	} else {
 		if len(getString(flds.parentStation, r, flds, false, false, "")) > 0 {
-			panic(fmt.Errorf("'parent_station' cannot be defined for location_type=1."))
+			panic(newHierarchyErr(HierarchyStationWithParent, a.ID, prefix+getString(flds.parentStation, r, flds, false, false, ""), "'parent_station' cannot be defined for location_type=1."))
 		}
 	}
 
@@ -1115,7 +1115,7 @@ func createStop(r []string, flds StopFields, feed *Feed, prefix string) (s *gtfs
 		}
 	}
 
-	a.PlatformCode = getString(flds.platformCode, r, flds, false, false, "")
+	a.PlatformCode = feed.intern(getString(flds.platformCode, r, flds, false, false, ""))
 
 	return a, parentId, nil
 }
@@ -1191,8 +1191,8 @@ func createStopTime(r []string, flds StopTimeFields, feed *Feed, prefix string)
 		panic(errors.New("Stop " + a.Stop.ID + " (" + a.Stop.Name + ") has location_type != 0, cannot be used in stop_times.txt!"))
 	}
 
-	a.ArrivalTime = getTime(flds.arrivalTime, r, flds)
-	a.DepartureTime = getTime(flds.departureTime, r, flds)
+	a.ArrivalTime = getTime(flds.arrivalTime, r, flds, feed)
+	a.DepartureTime = getTime(flds.departureTime, r, flds, feed)
 
 	if a.ArrivalTime.Empty() && !a.DepartureTime.Empty() {
 		if feed.opts.UseDefValueOnError {
@@ -1219,10 +1219,7 @@ func createStopTime(r []string, flds StopTimeFields, feed *Feed, prefix string)
 
 	// only store headsigns that are different to the default trip headsign
 	if len(headsign) > 0 && headsign != *trip.Headsign {
-		if *feed.lastString != headsign {
-			feed.lastString = &headsign
-		}
-		a.Headsign = feed.lastString
+		a.Headsign = feed.sharedString(headsign)
 	}
 
 	a.SetPickup(uint8(getRangeInt(flds.pickupType, r, flds, false, 0, 3)))
@@ -1244,7 +1241,7 @@ func createStopTime(r []string, flds StopTimeFields, feed *Feed, prefix string)
 		feed.warn(locErr)
 	}
 
-	trip.StopTimes = append(trip.StopTimes, a)
+	feed.addStopTime(trip, a)
 
 	return trip, &a, nil
 }
@@ -1289,18 +1286,15 @@ func createTrip(r []string, flds TripFields, feed *Feed, prefix string) (t *gtfs
 	a.Headsign = &feed.emptyString
 
 	if len(headsign) > 0 {
-		if *feed.lastString != headsign {
-			feed.lastString = &headsign
-		}
-		a.Headsign = feed.lastString
+		a.Headsign = feed.sharedString(headsign)
 	}
 
-	shortName := getString(flds.tripShortName, r, flds, false, false, "")
+	shortName := feed.intern(getString(flds.tripShortName, r, flds, false, false, ""))
 	if len(shortName) > 0 {
 		a.ShortName = &shortName
 	}
 	a.DirectionID = int8(getRangeIntWithDefault(flds.directionId, r, flds, 0, 1, -1, feed.opts.UseDefValueOnError, feed))
-	blockid := prefix + getString(flds.blockId, r, flds, false, false, "")
+	blockid := feed.intern(prefix + getString(flds.blockId, r, flds, false, false, ""))
 	if len(blockid) != len(prefix) {
 		a.BlockID = &blockid
 	}
@@ -1344,15 +1338,9 @@ func reserveShapePoint(r []string, flds ShapeFields, feed *Feed, prefix string)
 	lat := getFloat(flds.shapePtLat, r, flds, true)
 	lon := getFloat(flds.shapePtLon, r, flds, true)
 
-	// check if any defined PolygonFilter contains the shape point
-	contains := true
-	for _, poly := range feed.opts.PolygonFilter {
-		contains = false
-		if poly.PolyContains(float64(lon), float64(lat)) {
-			contains = true
-			break
-		}
-	}
+	// check if any defined PolygonFilter contains the shape point, shapes
+	// are clipped after parsing if ClipToPolygons is set
+	contains := feed.opts.ClipToPolygons || feed.insidePolygonFilter(float64(lon), float64(lat))
 
 	if val, ok := feed.Shapes[shapeID]; ok {
 		shape = val
@@ -1389,6 +1377,11 @@ func createShapePoint(r []string, flds ShapeFields, feed *Feed, prefix string) (
 		shape = feed.lastShape
 	} else {
 		shape = feed.Shapes[shapeID]
+		if shape == nil {
+			// no reservation pass was done for this shape
+			shape = &gtfs.Shape{ID: shapeID}
+			feed.Shapes[shapeID] = shape
+		}
 		feed.lastShape = shape
 	}
 
@@ -1416,15 +1409,9 @@ func createShapePoint(r []string, flds ShapeFields, feed *Feed, prefix string) (
 		panic(fmt.Errorf("Expected coordinate (lat, lon), instead found (0, 0), which is in the middle of the atlantic."))
 	}
 
-	// check if any defined PolygonFilter contains the shape point
-	contains := true
-	for _, poly := range feed.opts.PolygonFilter {
-		contains = false
-		if poly.PolyContains(float64(lon), float64(lat)) {
-			contains = true
-			break
-		}
-	}
+	// check if any defined PolygonFilter contains the shape point, shapes
+	// are clipped after parsing if ClipToPolygons is set
+	contains := feed.opts.ClipToPolygons || feed.insidePolygonFilter(float64(lon), float64(lat))
 
 	if !contains {
 		return shape, nil, nil
@@ -1437,7 +1424,7 @@ func createShapePoint(r []string, flds ShapeFields, feed *Feed, prefix string) (
 		DistTraveled: dist,
 	}
 
-	shape.Points = append(shape.Points, p)
+	feed.addShapePoint(shape, p)
 
 	return shape, &p, nil
 }
@@ -1452,11 +1439,11 @@ func createFareAttribute(r []string, flds FareAttributeFields, feed *Feed, prefi
 	a := new(gtfs.FareAttribute)
 
 	a.ID = prefix + getString(flds.fareId, r, flds, true, true, "")
-	a.Price = getString(flds.price, r, flds, false, false, "")
-	if feed.opts.UseDefValueOnError {
-		a.CurrencyType = getString(flds.currencyType, r, flds, true, true, "XXX")
-	} else {
-		a.CurrencyType = getString(flds.currencyType, r, flds, true, true, "")
+	a.Price = getPrice(flds.price, r, flds, feed.opts.UseDefValueOnError, feed)
+	a.CurrencyType = getCurrency(flds.currencyType, r, flds, feed.opts.UseDefValueOnError, feed)
+
+	if a.Price.Scale() > a.CurrencyType.MinorUnits() {
+		feed.warn(fmt.Errorf("Price '%s' has more decimal places than the minor unit of currency %s", a.Price, a.CurrencyType))
 	}
 	a.PaymentMethod = getRangeInt(flds.paymentMethod, r, flds, false, 0, 1)
 	a.Transfers = getRangeIntWithDefault(flds.transfers, r, flds, 0, 2, -1, feed.opts.UseDefValueOnError, feed)
@@ -1531,9 +1518,9 @@ func createFareRule(r []string, flds FareRuleFields, feed *Feed, prefix string)
 		}
 	}
 
-	rule.OriginID = prefix + getString(flds.originId, r, flds, false, false, "")
-	rule.DestinationID = prefix + getString(flds.destinationId, r, flds, false, false, "")
-	rule.ContainsID = prefix + getString(flds.containsId, r, flds, false, false, "")
+	rule.OriginID = feed.intern(prefix + getString(flds.originId, r, flds, false, false, ""))
+	rule.DestinationID = feed.intern(prefix + getString(flds.destinationId, r, flds, false, false, ""))
+	rule.ContainsID = feed.intern(prefix + getString(flds.containsId, r, flds, false, false, ""))
 
 	fareattr.Rules = append(fareattr.Rules, rule)
 
@@ -1664,8 +1651,8 @@ func createPathway(r []string, flds PathwayFields, feed *Feed, prefix string) (t
 	width := getNullablePositiveFloat(flds.minWidth, r, flds, feed.opts.UseDefValueOnError, feed)
 	a.MinWidth = width
 
-	a.SignpostedAs = getString(flds.signpostedAs, r, flds, false, false, "")
-	a.ReversedSignpostedAs = getString(flds.reversedSignpostedAs, r, flds, false, false, "")
+	a.SignpostedAs = feed.intern(getString(flds.signpostedAs, r, flds, false, false, ""))
+	a.ReversedSignpostedAs = feed.intern(getString(flds.reversedSignpostedAs, r, flds, false, false, ""))
 
 	return a, nil
 }
@@ -1684,7 +1671,7 @@ func createLevel(r []string, flds LevelFields, feed *Feed, idprefix string) (t *
 	if math.IsNaN(float64(a.Index)) {
 		a.Index = 0
 	}
-	a.Name = getString(flds.levelName, r, flds, false, false, "")
+	a.Name = feed.intern(getString(flds.levelName, r, flds, false, false, ""))
 
 	return a, nil
 }
@@ -1801,21 +1788,79 @@ func getTimezone(id int, r []string, flds Fields, req bool, ignErrs bool, feed *
 	return emptyTz
 }
 
-func getIsoLangCode(id int, r []string, flds Fields, req bool, ignErrs bool, feed *Feed) gtfs.LanguageISO6391 {
-	if id >= 0 && id < len(r) && len(r[id]) > 0 {
-		l, e := gtfs.NewLanguageISO6391(r[id])
-		if e != nil && (req || !ignErrs) {
+func getLanguageTag(id int, r []string, flds Fields, req bool, ignErrs bool, emptyrepl string, feed *Feed) gtfs.LanguageTag {
+	val := ""
+	if id >= 0 && id < len(r) {
+		val = r[id]
+	}
+
+	if len(val) == 0 && req && id >= 0 && len(emptyrepl) > 0 {
+		// the replacement is not required to be a valid tag
+		l, e := gtfs.NewLanguageTag(emptyrepl)
+		if e != nil {
+			feed.warn(e)
+		}
+		return l
+	}
+
+	if len(val) > 0 {
+		l, e := gtfs.NewLanguageTag(val)
+		if e != nil && !ignErrs {
 			panic(e)
 		} else if e != nil {
 			feed.warn(e)
-			return l
+			return gtfs.LanguageTag{}
 		}
 		return l
+	} else if req && id >= 0 {
+		panic(fmt.Errorf("Expected non-empty string for field '%s'", flds.FldName(id)))
 	} else if req {
 		panic(fmt.Errorf("Expected required field '%s'", flds.FldName(id)))
 	}
-	l, _ := gtfs.NewLanguageISO6391("")
-	return l
+	return gtfs.LanguageTag{}
+}
+
+func getCurrency(id int, r []string, flds Fields, ignErrs bool, feed *Feed) gtfs.Currency {
+	var locErr error
+	if id >= 0 && id < len(r) && len(r[id]) > 0 {
+		c, e := gtfs.NewCurrency(r[id])
+		if e == nil {
+			return c
+		}
+		locErr = e
+	} else if id >= 0 {
+		locErr = fmt.Errorf("Expected non-empty string for field '%s'", flds.FldName(id))
+	} else {
+		locErr = fmt.Errorf("Expected required field '%s'", flds.FldName(id))
+	}
+
+	if ignErrs {
+		feed.warn(locErr)
+		c, _ := gtfs.NewCurrency("XXX")
+		return c
+	}
+	panic(locErr)
+}
+
+func getPrice(id int, r []string, flds Fields, ignErrs bool, feed *Feed) gtfs.Decimal {
+	var locErr error
+	if id >= 0 && id < len(r) && len(r[id]) > 0 {
+		d, e := gtfs.ParseDecimal(r[id])
+		if e == nil && d.Sign() >= 0 {
+			return d
+		}
+		locErr = fmt.Errorf("Expected non-negative decimal number for field '%s', found '%s'", flds.FldName(id), errFldPrep(r[id]))
+	} else if id >= 0 {
+		locErr = fmt.Errorf("Expected non-empty string for field '%s'", flds.FldName(id))
+	} else {
+		locErr = fmt.Errorf("Expected required field '%s'", flds.FldName(id))
+	}
+
+	if ignErrs {
+		feed.warn(locErr)
+		return gtfs.Decimal{}
+	}
+	panic(locErr)
 }
 
 func getColor(id int, r []string, flds Fields, req bool, def string, ignErrs bool, feed *Feed) string {
@@ -1955,42 +2000,54 @@ func getFloat(id int, r []string, flds Fields, req bool) float32 {
 	return -1
 }
 
-func getTime(id int, r []string, flds Fields) gtfs.Time {
+func getTime(id int, r []string, flds Fields, feed *Feed) gtfs.Time {
 	if id < 0 {
 		panic(fmt.Errorf("Expected required field '%s'", flds.FldName(id)))
 	}
 
 	if id >= len(r) || len(r[id]) == 0 {
-		return gtfs.Time{Second: int8(-1), Minute: int8(-1), Hour: int8(-1)}
+		return gtfs.EmptyTime()
 	}
 
-	var hour, minute, second int64
-	parts := strings.Split(r[id], ":")
-	var e error
+	t, e := gtfs.ParseTime(r[id])
 
-	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) != 2 || len(parts[2]) != 2 {
-		e = fmt.Errorf("Expected HH:MM:SS time for field '%s', found '%s' (%s)", flds.FldName(id), errFldPrep(r[id]), e.Error())
+	if e != nil {
+		// times like 008:00:00 or 08:60:00 do not follow the reference,
+		// but were always accepted
+		lt, ok := parseLenientTime(r[id])
+		if !ok {
+			panic(fmt.Errorf("Expected HH:MM:SS time for field '%s', found '%s' (%s)", flds.FldName(id), errFldPrep(r[id]), e.Error()))
+		}
+		feed.warn(fmt.Errorf("Non-standard time '%s' for field '%s' (%s), read as '%s'", errFldPrep(r[id]), flds.FldName(id), e.Error(), lt.String()))
+		return lt
 	}
 
-	if e == nil {
-		hour, e = fastfloat.ParseInt64(parts[0])
-	}
-	if e == nil {
-		minute, e = fastfloat.ParseInt64(parts[1])
-	}
-	if e == nil {
-		second, e = fastfloat.ParseInt64(parts[2])
+	return t
+}
+
+// parseLenientTime parses a time with three colon-separated, non-negative
+// integer fields, the last two of them with two digits
+func parseLenientTime(str string) (gtfs.Time, bool) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 3 || len(parts[0]) == 0 || len(parts[1]) != 2 || len(parts[2]) != 2 {
+		return gtfs.EmptyTime(), false
 	}
 
-	if hour > 127 {
-		panic(fmt.Errorf("Max representable time is '127:59:59', found '%s' for field %s", errFldPrep(r[id]), flds.FldName(id)))
+	secs := int64(0)
+	for i, mul := range []int64{3600, 60, 1} {
+		v, e := fastfloat.ParseInt64(parts[i])
+		if e != nil || v < 0 || v > gtfs.MaxTimeSeconds {
+			return gtfs.EmptyTime(), false
+		}
+		secs += v * mul
 	}
 
-	if e != nil {
-		panic(fmt.Errorf("Expected HH:MM:SS time for field '%s', found '%s' (%s)", flds.FldName(id), errFldPrep(r[id]), e.Error()))
-	} else {
-		return gtfs.Time{Hour: int8(hour), Minute: int8(minute), Second: int8(second)}
+	if secs > gtfs.MaxTimeSeconds {
+		return gtfs.EmptyTime(), false
 	}
+
+	t, _ := gtfs.NewTimeFromSeconds(int(secs))
+	return t, true
 }
 
 func getNullablePositiveFloat(id int, r []string, flds Fields, ignErrs bool, feed *Feed) float32 {
@@ -0,0 +1,127 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"sync"
+)
+
+// parseStep is a single step of the parsing pipeline.
+//
+// Steps of the same group only depend on steps of earlier groups, or on
+// earlier steps of the same chain. If files are parsed concurrently, the
+// chains of a group run in parallel, and the steps of a chain run in
+// order.
+type parseStep struct {
+	group int
+	chain int
+	run   func() error
+}
+
+// concurrent returns true if files are parsed concurrently
+func (feed *Feed) concurrent() bool {
+	return feed.opts.Workers > 1 && feed.stream.empty()
+}
+
+// runSteps runs the parsing steps, either sequentially or concurrently.
+// In both cases, the error of the first failed step (in step order) is
+// returned.
+func (feed *Feed) runSteps(steps []parseStep) error {
+	if !feed.concurrent() {
+		var e error
+		for _, s := range steps {
+			if e == nil {
+				e = s.run()
+			}
+			e = feed.checkpoint(e)
+		}
+		return e
+	}
+
+	for start := 0; start < len(steps); {
+		end := start
+		for end < len(steps) && steps[end].group == steps[start].group {
+			end++
+		}
+
+		if e := feed.checkpoint(feed.runGroup(steps[start:end])); e != nil {
+			return e
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+// runGroup runs the chains of a group concurrently, using at most
+// feed.opts.Workers goroutines. All files opened in the group are closed
+// afterwards.
+func (feed *Feed) runGroup(steps []parseStep) error {
+	chains := make(map[int][]int)
+	order := make([]int, 0)
+
+	for i, s := range steps {
+		if _, ok := chains[s.chain]; !ok {
+			order = append(order, s.chain)
+		}
+		chains[s.chain] = append(chains[s.chain], i)
+	}
+
+	errs := make([]error, len(steps))
+	sem := make(chan struct{}, feed.opts.Workers)
+	var wg sync.WaitGroup
+
+	feed.prefetchQuit = make(chan struct{})
+
+	for _, c := range order {
+		wg.Add(1)
+		go func(idxs []int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			for _, i := range idxs {
+				if feed.ctx != nil && feed.ctx.Err() != nil {
+					errs[i] = feed.ctx.Err()
+				} else {
+					errs[i] = steps[i].run()
+				}
+
+				if errs[i] != nil {
+					return
+				}
+			}
+		}(chains[c])
+	}
+
+	wg.Wait()
+
+	feed.stopPrefetching()
+	feed.closeFiles()
+
+	for _, e := range errs {
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// stopPrefetching stops all CSV read-ahead goroutines and waits for them
+// to finish
+func (feed *Feed) stopPrefetching() {
+	if feed.prefetchQuit == nil {
+		return
+	}
+
+	close(feed.prefetchQuit)
+	feed.prefetchWg.Wait()
+	feed.prefetchQuit = nil
+}
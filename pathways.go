@@ -0,0 +1,341 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// walking speed in m/s and fallback traversal time in seconds, used for
+// pathways without a traversal_time
+const (
+	pathwayWalkSpeed   = 1.2
+	pathwayDefaultTime = 30
+)
+
+// A StationGraph is the graph of all pathways of a feed
+type StationGraph struct {
+	feed  *Feed
+	edges map[*gtfs.Stop][]stationEdge
+}
+
+// stationEdge is a pathway in one direction
+type stationEdge struct {
+	pathway  *gtfs.Pathway
+	to       *gtfs.Stop
+	reversed bool
+	secs     float64
+}
+
+// PathOptions restrict the pathways used for routing
+type PathOptions struct {
+	// avoid stairs and escalators
+	Accessible bool
+
+	// if > 0, avoid pathways with a steeper slope (as a ratio)
+	MaxSlope float32
+
+	// if > 0, avoid pathways known to be narrower (in meters)
+	MinWidth float32
+}
+
+// A StationPath is a route through a station
+type StationPath struct {
+	// the visited stops, including the start and the target
+	Stops []*gtfs.Stop
+
+	// the pathways taken, Reversed[i] is true if Pathways[i] was used
+	// from its ToStop to its FromStop
+	Pathways []*gtfs.Pathway
+	Reversed []bool
+
+	// estimated time in seconds
+	Time int
+}
+
+// NewStationGraph builds the graph of the pathways of a feed.
+// Bidirectional pathways can be used in both directions.
+func NewStationGraph(feed *Feed) *StationGraph {
+	g := &StationGraph{feed: feed, edges: make(map[*gtfs.Stop][]stationEdge)}
+
+	for _, id := range sortedMapKeys(feed.Pathways) {
+		pw := feed.Pathways[id]
+		if pw.FromStop == nil || pw.ToStop == nil {
+			continue
+		}
+
+		secs := pathwayTime(pw)
+
+		g.edges[pw.FromStop] = append(g.edges[pw.FromStop], stationEdge{pw, pw.ToStop, false, secs})
+		if pw.IsBidirectional {
+			g.edges[pw.ToStop] = append(g.edges[pw.ToStop], stationEdge{pw, pw.FromStop, true, secs})
+		}
+	}
+
+	return g
+}
+
+// pathwayTime estimates the traversal time of a pathway in seconds
+func pathwayTime(pw *gtfs.Pathway) float64 {
+	if pw.TraversalTime >= 0 {
+		return float64(pw.TraversalTime)
+	}
+
+	if !math.IsNaN(float64(pw.Length)) && pw.Length > 0 {
+		return float64(pw.Length) / pathwayWalkSpeed
+	}
+
+	if pw.FromStop.HasLatLon() && pw.ToStop.HasLatLon() {
+		d := gtfs.Haversine(float64(pw.FromStop.Lat), float64(pw.FromStop.Lon), float64(pw.ToStop.Lat), float64(pw.ToStop.Lon))
+		if d > 0 {
+			return d / pathwayWalkSpeed
+		}
+	}
+
+	return pathwayDefaultTime
+}
+
+// usable returns true if the pathway may be used with the options
+func (opts *PathOptions) usable(pw *gtfs.Pathway) bool {
+	if opts.Accessible {
+		if pw.Mode == gtfs.PathwayStairs || pw.Mode == gtfs.PathwayEscalator {
+			return false
+		}
+		if pw.Mode != gtfs.PathwayElevator && pw.StairCount != 0 {
+			return false
+		}
+	}
+
+	if opts.MaxSlope > 0 && math.Abs(float64(pw.MaxSlope)) > float64(opts.MaxSlope) {
+		return false
+	}
+
+	if opts.MinWidth > 0 && !math.IsNaN(float64(pw.MinWidth)) && pw.MinWidth > 0 && pw.MinWidth < opts.MinWidth {
+		return false
+	}
+
+	return true
+}
+
+// Signposts returns the signposted text of each pathway of the path, in
+// the direction it was used
+func (p *StationPath) Signposts() []string {
+	ret := make([]string, len(p.Pathways))
+	for i, pw := range p.Pathways {
+		if p.Reversed[i] {
+			ret[i] = pw.ReversedSignpostedAs
+		} else {
+			ret[i] = pw.SignpostedAs
+		}
+	}
+	return ret
+}
+
+// ShortestPath returns the fastest path between two stops, or nil if the
+// target cannot be reached
+func (g *StationGraph) ShortestPath(from, to *gtfs.Stop, opts PathOptions) *StationPath {
+	dist, prev := g.dijkstra(from, to, opts)
+
+	if _, ok := dist[to]; !ok {
+		return nil
+	}
+
+	path := &StationPath{Stops: []*gtfs.Stop{to}, Time: int(math.Round(dist[to]))}
+
+	for s := to; s != from; {
+		e := prev[s]
+		path.Pathways = append(path.Pathways, e.pathway)
+		path.Reversed = append(path.Reversed, e.reversed)
+		if e.reversed {
+			s = e.pathway.ToStop
+		} else {
+			s = e.pathway.FromStop
+		}
+		path.Stops = append(path.Stops, s)
+	}
+
+	// the path was collected from the target
+	for i, j := 0, len(path.Stops)-1; i < j; i, j = i+1, j-1 {
+		path.Stops[i], path.Stops[j] = path.Stops[j], path.Stops[i]
+	}
+	for i, j := 0, len(path.Pathways)-1; i < j; i, j = i+1, j-1 {
+		path.Pathways[i], path.Pathways[j] = path.Pathways[j], path.Pathways[i]
+		path.Reversed[i], path.Reversed[j] = path.Reversed[j], path.Reversed[i]
+	}
+
+	return path
+}
+
+// Reachable returns all stops reachable from a stop, including itself
+func (g *StationGraph) Reachable(from *gtfs.Stop, opts PathOptions) map[*gtfs.Stop]bool {
+	dist, _ := g.dijkstra(from, nil, opts)
+
+	ret := make(map[*gtfs.Stop]bool, len(dist))
+	for s := range dist {
+		ret[s] = true
+	}
+	return ret
+}
+
+// dijkstra computes the times from a stop to all reachable stops, or
+// until the target (if not nil) is settled
+func (g *StationGraph) dijkstra(from, to *gtfs.Stop, opts PathOptions) (map[*gtfs.Stop]float64, map[*gtfs.Stop]stationEdge) {
+	dist := map[*gtfs.Stop]float64{from: 0}
+	prev := make(map[*gtfs.Stop]stationEdge)
+	settled := make(map[*gtfs.Stop]bool)
+
+	pq := &stopQueue{{from, 0}}
+
+	for pq.Len() > 0 {
+		cur := heap.Pop(pq).(stopQueueItem)
+		if settled[cur.stop] {
+			continue
+		}
+		settled[cur.stop] = true
+
+		if cur.stop == to {
+			break
+		}
+
+		for _, e := range g.edges[cur.stop] {
+			if !opts.usable(e.pathway) {
+				continue
+			}
+			d := cur.dist + e.secs
+			if old, ok := dist[e.to]; !ok || d < old {
+				dist[e.to] = d
+				prev[e.to] = e
+				heap.Push(pq, stopQueueItem{e.to, d})
+			}
+		}
+	}
+
+	return dist, prev
+}
+
+// CheckReachability returns an error for each platform of a station with
+// pathways which cannot be reached from an entrance, or from which no
+// entrance can be reached. Platforms with boarding areas are connected if
+// one of their boarding areas is.
+func (g *StationGraph) CheckReachability(opts PathOptions) []error {
+	ret := make([]error, 0)
+	feed := g.feed
+
+	// reverse graph, to find the stops from which an entrance is reachable
+	rev := &StationGraph{edges: make(map[*gtfs.Stop][]stationEdge)}
+	for from, edges := range g.edges {
+		for _, e := range edges {
+			rev.edges[e.to] = append(rev.edges[e.to], stationEdge{e.pathway, from, !e.reversed, e.secs})
+		}
+	}
+
+	withPathways := make(map[*gtfs.Stop]bool)
+	for s := range g.edges {
+		withPathways[stationOf(s)] = true
+	}
+	for _, edges := range g.edges {
+		for _, e := range edges {
+			withPathways[stationOf(e.to)] = true
+		}
+	}
+
+	entrances := make(map[*gtfs.Stop][]*gtfs.Stop)
+	platforms := make(map[*gtfs.Stop][]*gtfs.Stop)
+	boardingAreas := make(map[*gtfs.Stop][]*gtfs.Stop)
+
+	for _, id := range sortedMapKeys(feed.Stops) {
+		s := feed.Stops[id]
+		st := stationOf(s)
+		if st == nil || !withPathways[st] {
+			continue
+		}
+		switch s.LocationType {
+		case 0:
+			platforms[st] = append(platforms[st], s)
+		case 2:
+			entrances[st] = append(entrances[st], s)
+		case 4:
+			boardingAreas[s.ParentStation] = append(boardingAreas[s.ParentStation], s)
+		}
+	}
+
+	for _, id := range sortedMapKeys(feed.Stops) {
+		st := feed.Stops[id]
+		if !withPathways[st] || st.LocationType != 1 {
+			continue
+		}
+
+		if len(entrances[st]) == 0 {
+			ret = append(ret, fmt.Errorf("Station %s has pathways, but no entrance.", st.ID))
+			continue
+		}
+
+		fromEntrance := make(map[*gtfs.Stop]bool)
+		toEntrance := make(map[*gtfs.Stop]bool)
+		for _, en := range entrances[st] {
+			for s := range g.Reachable(en, opts) {
+				fromEntrance[s] = true
+			}
+			for s := range rev.Reachable(en, opts) {
+				toEntrance[s] = true
+			}
+		}
+
+		for _, p := range platforms[st] {
+			nodes := append([]*gtfs.Stop{p}, boardingAreas[p]...)
+			in, out := false, false
+			for _, n := range nodes {
+				in = in || fromEntrance[n]
+				out = out || toEntrance[n]
+			}
+
+			if !in {
+				ret = append(ret, fmt.Errorf("Platform %s of station %s cannot be reached from an entrance.", p.ID, st.ID))
+			}
+			if !out {
+				ret = append(ret, fmt.Errorf("No entrance of station %s can be reached from platform %s.", st.ID, p.ID))
+			}
+		}
+	}
+
+	return ret
+}
+
+// stationOf returns the station a stop belongs to, or nil
+func stationOf(s *gtfs.Stop) *gtfs.Stop {
+	for i := 0; s != nil && i < 3; i++ {
+		if s.LocationType == 1 {
+			return s
+		}
+		s = s.ParentStation
+	}
+	return nil
+}
+
+type stopQueueItem struct {
+	stop *gtfs.Stop
+	dist float64
+}
+
+// stopQueue is a priority queue of stops, ordered by distance
+type stopQueue []stopQueueItem
+
+func (q stopQueue) Len() int            { return len(q) }
+func (q stopQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q stopQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *stopQueue) Push(x interface{}) { *q = append(*q, x.(stopQueueItem)) }
+
+func (q *stopQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,758 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// LoadPolygons reads polygons for the PolygonFilter from a file, which
+// may either contain GeoJSON or WKT
+func LoadPolygons(path string) ([]Polygon, error) {
+	data, e := os.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+
+	var polys []Polygon
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		polys, e = ParsePolygonsGeoJSON(data)
+	} else {
+		polys, e = ParsePolygonsWKT(string(data))
+	}
+
+	if e != nil {
+		return nil, fmt.Errorf("%s: %s", path, e.Error())
+	}
+
+	return polys, nil
+}
+
+type geoJSONInput struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONInput   `json:"geometry"`
+	Geometries  []*geoJSONInput `json:"geometries"`
+	Features    []*geoJSONInput `json:"features"`
+}
+
+// ParsePolygonsGeoJSON returns all Polygons and MultiPolygons of a GeoJSON
+// geometry, feature or feature collection. Other geometries are ignored.
+func ParsePolygonsGeoJSON(data []byte) ([]Polygon, error) {
+	var obj geoJSONInput
+	if e := json.Unmarshal(data, &obj); e != nil {
+		return nil, e
+	}
+
+	ret := make([]Polygon, 0)
+	if e := collectGeoJSONPolygons(&obj, &ret); e != nil {
+		return nil, e
+	}
+
+	if len(ret) == 0 {
+		return nil, errors.New("No polygons found")
+	}
+
+	return ret, nil
+}
+
+func collectGeoJSONPolygons(obj *geoJSONInput, ret *[]Polygon) error {
+	if obj == nil {
+		return nil
+	}
+
+	switch obj.Type {
+	case "FeatureCollection":
+		for _, f := range obj.Features {
+			if e := collectGeoJSONPolygons(f, ret); e != nil {
+				return e
+			}
+		}
+	case "Feature":
+		return collectGeoJSONPolygons(obj.Geometry, ret)
+	case "GeometryCollection":
+		for _, g := range obj.Geometries {
+			if e := collectGeoJSONPolygons(g, ret); e != nil {
+				return e
+			}
+		}
+	case "Polygon":
+		var rings [][][]float64
+		if e := json.Unmarshal(obj.Coordinates, &rings); e != nil {
+			return e
+		}
+		return appendPolygon(rings, ret)
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if e := json.Unmarshal(obj.Coordinates, &polys); e != nil {
+			return e
+		}
+		for _, rings := range polys {
+			if e := appendPolygon(rings, ret); e != nil {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendPolygon appends a polygon given as rings of GeoJSON positions
+func appendPolygon(rings [][][]float64, ret *[]Polygon) error {
+	if len(rings) == 0 {
+		return nil
+	}
+
+	conv := make([][][2]float64, len(rings))
+	for i, r := range rings {
+		conv[i] = make([][2]float64, len(r))
+		for j, p := range r {
+			if len(p) < 2 {
+				return errors.New("Position with less than 2 coordinates")
+			}
+			conv[i][j] = [2]float64{p[0], p[1]}
+		}
+	}
+
+	*ret = append(*ret, NewPolygon(conv[0], conv[1:]))
+	return nil
+}
+
+// ParsePolygonsWKT returns the polygons of a WKT POLYGON or MULTIPOLYGON,
+// an optional SRID prefix is ignored. Coordinates are in lon, lat order.
+func ParsePolygonsWKT(s string) ([]Polygon, error) {
+	if i := strings.IndexByte(s, ';'); i >= 0 && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(s)), "SRID=") {
+		s = s[i+1:]
+	}
+
+	p := &wktParser{s: s}
+	ret := make([]Polygon, 0)
+
+	typ := p.word()
+
+	// dimension, coordinates beyond lon and lat are ignored
+	if w := p.peekWord(); w == "Z" || w == "M" || w == "ZM" {
+		p.word()
+	}
+
+	var e error
+
+	switch typ {
+	case "POLYGON":
+		e = p.polygon(&ret)
+	case "MULTIPOLYGON":
+		if !p.empty() {
+			e = p.list(func() error { return p.polygon(&ret) })
+		}
+	default:
+		return nil, fmt.Errorf("Expected POLYGON or MULTIPOLYGON, found '%s'", typ)
+	}
+
+	if e != nil {
+		return nil, e
+	}
+
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("Unexpected '%s' at position %d", p.s[p.pos:min(len(p.s), p.pos+10)], p.pos)
+	}
+
+	if len(ret) == 0 {
+		return nil, errors.New("No polygons found")
+	}
+
+	return ret, nil
+}
+
+// wktParser is a minimal WKT parser for polygons
+type wktParser struct {
+	s   string
+	pos int
+}
+
+func (p *wktParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *wktParser) peekWord() string {
+	pos := p.pos
+	w := p.word()
+	p.pos = pos
+	return w
+}
+
+func (p *wktParser) word() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z' || p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z') {
+		p.pos++
+	}
+	return strings.ToUpper(p.s[start:p.pos])
+}
+
+func (p *wktParser) empty() bool {
+	if p.peekWord() == "EMPTY" {
+		p.word()
+		return true
+	}
+	return false
+}
+
+func (p *wktParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.s) || p.s[p.pos] != c {
+		return fmt.Errorf("Expected '%c' at position %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+// list parses a parenthesized, comma-separated list
+func (p *wktParser) list(elem func() error) error {
+	if e := p.expect('('); e != nil {
+		return e
+	}
+
+	for {
+		if e := elem(); e != nil {
+			return e
+		}
+		p.skipSpace()
+		if p.pos < len(p.s) && p.s[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		return p.expect(')')
+	}
+}
+
+func (p *wktParser) number() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte("+-.0123456789eE", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	f, e := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if e != nil {
+		return 0, fmt.Errorf("Expected number at position %d", start)
+	}
+	return f, nil
+}
+
+func (p *wktParser) polygon(ret *[]Polygon) error {
+	if p.empty() {
+		return nil
+	}
+
+	rings := make([][][2]float64, 0)
+
+	e := p.list(func() error {
+		ring := make([][2]float64, 0)
+		e := p.list(func() error {
+			x, e := p.number()
+			if e != nil {
+				return e
+			}
+			y, e := p.number()
+			if e != nil {
+				return e
+			}
+			// skip z and m
+			for p.skipSpace(); p.pos < len(p.s) && p.s[p.pos] != ',' && p.s[p.pos] != ')'; p.skipSpace() {
+				if _, e := p.number(); e != nil {
+					return e
+				}
+			}
+			ring = append(ring, [2]float64{x, y})
+			return nil
+		})
+		rings = append(rings, ring)
+		return e
+	})
+
+	if e != nil {
+		return e
+	}
+
+	*ret = append(*ret, NewPolygon(rings[0], rings[1:]))
+	return nil
+}
+
+// insidePolygonFilter returns true if the point is contained in any
+// polygon of the PolygonFilter, or if there is no PolygonFilter
+func (feed *Feed) insidePolygonFilter(lon, lat float64) bool {
+	if len(feed.opts.PolygonFilter) == 0 {
+		return true
+	}
+	for _, poly := range feed.opts.PolygonFilter {
+		if poly.PolyContains(lon, lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// outsideStopTimes are the stop times of a trip dropped because their
+// stops are outside the PolygonFilter
+type outsideStopTimes struct {
+	seqs []int
+
+	// the dropped stop time with the lowest sequence, and its departure
+	firstSeq int
+	firstDep gtfs.Time
+}
+
+// markOutsideStopTime remembers the sequence and the departure of a stop
+// time dropped because its stop is outside the PolygonFilter
+func (feed *Feed) markOutsideStopTime(r []string, flds StopTimeFields, prefix string) {
+	defer func() {
+		// the stop time is dropped anyway
+		recover()
+	}()
+
+	trip := feed.Trips[prefix+getString(flds.tripId, r, flds, true, true, "")]
+	if trip == nil {
+		return
+	}
+
+	if feed.outsideStopTimes == nil {
+		feed.outsideStopTimes = make(map[*gtfs.Trip]*outsideStopTimes)
+	}
+
+	o := feed.outsideStopTimes[trip]
+	if o == nil {
+		o = &outsideStopTimes{firstSeq: -1, firstDep: gtfs.EmptyTime()}
+		feed.outsideStopTimes[trip] = o
+	}
+
+	seq := getRangeInt(flds.stopSequence, r, flds, true, 0, int(^uint32(0)))
+	o.seqs = append(o.seqs, seq)
+
+	if o.firstSeq < 0 || seq < o.firstSeq {
+		o.firstSeq = seq
+		o.firstDep = gtfs.EmptyTime()
+		dep := getTime(flds.departureTime, r, flds, feed)
+		if dep.Empty() {
+			dep = getTime(flds.arrivalTime, r, flds, feed)
+		}
+		o.firstDep = dep
+	}
+}
+
+// clipToPolygons clips shapes to the PolygonFilter and cuts trips which
+// leave and re-enter it
+func (feed *Feed) clipToPolygons() {
+	pieces := make(map[*gtfs.Shape][]*gtfs.Shape)
+
+	// with DryRun, shapes are not kept
+	for _, id := range sortedMapKeys(feed.Shapes) {
+		if shape := feed.Shapes[id]; shape != nil {
+			pieces[shape] = feed.clipShape(shape)
+		}
+	}
+
+	for _, id := range sortedMapKeys(feed.Trips) {
+		if trip := feed.Trips[id]; trip != nil {
+			feed.cutTrip(trip, pieces)
+		}
+	}
+
+	feed.outsideStopTimes = nil
+	feed.CleanTransfers()
+}
+
+// clipShape clips a shape to the PolygonFilter and returns the resulting
+// shapes. The first one keeps the ID of the shape, the others get new IDs.
+func (feed *Feed) clipShape(shape *gtfs.Shape) []*gtfs.Shape {
+	parts := clipLine(shape.Points, feed.opts.PolygonFilter)
+
+	if len(parts) == 0 {
+		feed.DeleteShape(shape.ID)
+		return nil
+	}
+
+	// sequences are renumbered, move the additional fields along
+	oldID := shape.ID
+	addFlds := make(map[string]map[int]string)
+	for k, v := range feed.ShapesAddFlds {
+		if m, ok := v[oldID]; ok {
+			addFlds[k] = m
+			delete(v, oldID)
+		}
+	}
+
+	ret := make([]*gtfs.Shape, len(parts))
+
+	for i, part := range parts {
+		s := shape
+		if i > 0 {
+			s = &gtfs.Shape{ID: feed.freeID(oldID, func(id string) bool { _, ok := feed.Shapes[id]; return ok })}
+			feed.Shapes[s.ID] = s
+		}
+
+		s.Points = make(gtfs.ShapePoints, len(part))
+		for j, cp := range part {
+			s.Points[j] = cp.p
+			s.Points[j].Sequence = uint32(j + 1)
+			if cp.origSeq < 0 {
+				continue
+			}
+			for k, m := range addFlds {
+				if v, ok := m[cp.origSeq]; ok {
+					if feed.ShapesAddFlds[k][s.ID] == nil {
+						feed.ShapesAddFlds[k][s.ID] = make(map[int]string)
+					}
+					feed.ShapesAddFlds[k][s.ID][j+1] = v
+				}
+			}
+		}
+
+		ret[i] = s
+	}
+
+	return ret
+}
+
+// cutTrip splits a trip at the stop times dropped because they were outside
+// of the PolygonFilter. Resulting trips with less than 2 stop times are
+// deleted.
+func (feed *Feed) cutTrip(trip *gtfs.Trip, pieces map[*gtfs.Shape][]*gtfs.Shape) {
+	var outside []int
+	o := feed.outsideStopTimes[trip]
+	if o != nil {
+		outside = o.seqs
+		sort.Ints(outside)
+	}
+
+	sts := trip.StopTimes
+	sort.Sort(sts)
+
+	runs := make([]gtfs.StopTimes, 0, 1)
+	start := 0
+	for i := 1; i <= len(sts); i++ {
+		if i < len(sts) && !hasValueBetween(outside, sts[i-1].Sequence(), sts[i].Sequence()) {
+			continue
+		}
+		if i-start > 1 {
+			runs = append(runs, sts[start:i:i])
+		}
+		start = i
+	}
+
+	if len(runs) == 0 {
+		feed.DeleteTrip(trip.ID)
+		return
+	}
+
+	// frequencies refer to the first departure of the original trip,
+	// which may have been dropped
+	firstDep := sts[0].DepartureTime
+	if o != nil && o.firstSeq >= 0 && o.firstSeq < sts[0].Sequence() {
+		firstDep = o.firstDep
+	}
+
+	origShape := trip.Shape
+	origFreqs := trip.Frequencies
+
+	origFreqAddFlds := make(map[string]map[*gtfs.Frequency]string)
+	for k, v := range feed.FrequenciesAddFlds {
+		if m, ok := v[trip.ID]; ok {
+			origFreqAddFlds[k] = m
+			delete(v, trip.ID)
+		}
+	}
+
+	for i, run := range runs {
+		t := trip
+		if i > 0 {
+			cp := *trip
+			t = &cp
+			t.ID = feed.freeID(trip.ID, func(id string) bool { _, ok := feed.Trips[id]; return ok })
+			feed.Trips[t.ID] = t
+			feed.copyTripAddFlds(trip.ID, t.ID, run)
+		}
+
+		t.StopTimes = run
+		t.Shape = bestShapePiece(pieces[origShape], run)
+
+		if origShape != nil && len(pieces[origShape]) == 0 {
+			t.Shape = nil
+		}
+
+		if origFreqs != nil && !firstDep.Empty() && !run[0].DepartureTime.Empty() && !run[0].DepartureTime.Equals(firstDep) {
+			offset := run[0].DepartureTime.Sub(firstDep)
+			freqs := make([]*gtfs.Frequency, 0, len(*origFreqs))
+			for _, f := range *origFreqs {
+				nf := *f
+				if !nf.StartTime.Empty() {
+					nf.StartTime = nf.StartTime.Add(offset)
+				}
+				if !nf.EndTime.Empty() {
+					nf.EndTime = nf.EndTime.Add(offset)
+				}
+				freqs = append(freqs, &nf)
+				for k, m := range origFreqAddFlds {
+					if val, ok := m[f]; ok {
+						feed.setFreqAddFld(k, t.ID, &nf, val)
+					}
+				}
+			}
+			t.Frequencies = &freqs
+		} else if origFreqs != nil {
+			for _, f := range *origFreqs {
+				for k, m := range origFreqAddFlds {
+					if val, ok := m[f]; ok {
+						feed.setFreqAddFld(k, t.ID, f, val)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (feed *Feed) setFreqAddFld(fld string, tid string, f *gtfs.Frequency, val string) {
+	if feed.FrequenciesAddFlds[fld][tid] == nil {
+		feed.FrequenciesAddFlds[fld][tid] = make(map[*gtfs.Frequency]string)
+	}
+	feed.FrequenciesAddFlds[fld][tid][f] = val
+}
+
+// copyTripAddFlds copies the additional fields of a trip and of the given
+// stop times to a new trip
+func (feed *Feed) copyTripAddFlds(from, to string, sts gtfs.StopTimes) {
+	for _, v := range feed.TripsAddFlds {
+		if val, ok := v[from]; ok {
+			v[to] = val
+		}
+	}
+
+	for _, v := range feed.StopTimesAddFlds {
+		m, ok := v[from]
+		if !ok {
+			continue
+		}
+		for _, st := range sts {
+			if val, ok := m[st.Sequence()]; ok {
+				if v[to] == nil {
+					v[to] = make(map[int]string)
+				}
+				v[to][st.Sequence()] = val
+				delete(m, st.Sequence())
+			}
+		}
+	}
+}
+
+// freeID returns an unused ID of the form id_2, id_3, ...
+func (feed *Feed) freeID(id string, used func(string) bool) string {
+	for i := 2; ; i++ {
+		cand := id + "_" + strconv.Itoa(i)
+		if !used(cand) {
+			return cand
+		}
+	}
+}
+
+// hasValueBetween returns true if the sorted slice contains a value v
+// with a < v < b
+func hasValueBetween(sorted []int, a, b int) bool {
+	i := sort.SearchInts(sorted, a+1)
+	return i < len(sorted) && sorted[i] < b
+}
+
+// bestShapePiece returns the piece of a clipped shape closest to the first
+// and last stop of the stop times
+func bestShapePiece(pieces []*gtfs.Shape, sts gtfs.StopTimes) *gtfs.Shape {
+	if len(pieces) < 2 {
+		if len(pieces) == 1 {
+			return pieces[0]
+		}
+		return nil
+	}
+
+	var best *gtfs.Shape
+	bestDist := math.Inf(1)
+
+	for _, piece := range pieces {
+		d := 0.0
+		for _, st := range []*gtfs.StopTime{&sts[0], &sts[len(sts)-1]} {
+			if st.Stop == nil {
+				continue
+			}
+			minD := math.Inf(1)
+			for _, p := range piece.Points {
+				minD = math.Min(minD, gtfs.Haversine(float64(st.Stop.Lat), float64(st.Stop.Lon), float64(p.Lat), float64(p.Lon)))
+			}
+			d += minD
+		}
+		if d < bestDist {
+			best, bestDist = piece, d
+		}
+	}
+
+	return best
+}
+
+// clippedPoint is a point of a clipped line, origSeq is the sequence of
+// the original point or -1 for points on the polygon border
+type clippedPoint struct {
+	p       gtfs.ShapePoint
+	origSeq int
+}
+
+// clipLine returns the parts of a line inside the polygons, in lon, lat
+// coordinates. Points on the polygon borders are added where the line
+// enters or leaves a polygon.
+func clipLine(pts gtfs.ShapePoints, polys []Polygon) [][]clippedPoint {
+	ret := make([][]clippedPoint, 0)
+
+	inside := func(lon, lat float64) bool {
+		for i := range polys {
+			if polys[i].PolyContains(lon, lat) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(pts) == 1 {
+		if inside(float64(pts[0].Lon), float64(pts[0].Lat)) {
+			ret = append(ret, []clippedPoint{{pts[0], int(pts[0].Sequence)}})
+		}
+		return ret
+	}
+
+	cur := make([]clippedPoint, 0)
+
+	for i := 1; i < len(pts); i++ {
+		a, b := &pts[i-1], &pts[i]
+		ts := segmentIntersections(a, b, polys)
+
+		for j := 1; j < len(ts); j++ {
+			t0, t1 := ts[j-1], ts[j]
+			mid := interpolate(a, b, (t0+t1)/2)
+
+			if !inside(float64(mid.Lon), float64(mid.Lat)) {
+				if len(cur) > 1 {
+					ret = append(ret, cur)
+				}
+				cur = make([]clippedPoint, 0)
+				continue
+			}
+
+			if len(cur) == 0 {
+				cur = append(cur, clippedAt(a, b, t0))
+			}
+			cur = append(cur, clippedAt(a, b, t1))
+		}
+	}
+
+	if len(cur) > 1 {
+		ret = append(ret, cur)
+	}
+
+	return ret
+}
+
+// segmentIntersections returns the sorted positions (between 0 and 1,
+// including both) where the segment a-b crosses a polygon ring
+func segmentIntersections(a, b *gtfs.ShapePoint, polys []Polygon) []float64 {
+	ts := []float64{0, 1}
+
+	ax, ay := float64(a.Lon), float64(a.Lat)
+	bx, by := float64(b.Lon), float64(b.Lat)
+
+	for i := range polys {
+		p := &polys[i]
+		if math.Max(ax, bx) < p.ll[0] || math.Min(ax, bx) > p.ur[0] || math.Max(ay, by) < p.ll[1] || math.Min(ay, by) > p.ur[1] {
+			continue
+		}
+
+		rings := append([][][2]float64{p.OuterRing}, p.InnerRings...)
+		for _, ring := range rings {
+			for j := range ring {
+				c, d := ring[j], ring[(j+1)%len(ring)]
+				if t, ok := segmentIntersection(ax, ay, bx, by, c[0], c[1], d[0], d[1]); ok {
+					ts = append(ts, t)
+				}
+			}
+		}
+	}
+
+	sort.Float64s(ts)
+
+	// remove duplicates
+	ret := ts[:1]
+	for _, t := range ts[1:] {
+		if t-ret[len(ret)-1] > 1e-12 {
+			ret = append(ret, t)
+		}
+	}
+	ret[len(ret)-1] = 1
+
+	return ret
+}
+
+// segmentIntersection returns the position t on segment a-b where it
+// crosses segment c-d, if 0 < t < 1
+func segmentIntersection(ax, ay, bx, by, cx, cy, dx, dy float64) (float64, bool) {
+	rx, ry := bx-ax, by-ay
+	sx, sy := dx-cx, dy-cy
+
+	denom := rx*sy - ry*sx
+	if math.Abs(denom) < 1e-15 {
+		return 0, false
+	}
+
+	t := ((cx-ax)*sy - (cy-ay)*sx) / denom
+	u := ((cx-ax)*ry - (cy-ay)*rx) / denom
+
+	if t <= 0 || t >= 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+
+	return t, true
+}
+
+// clippedAt returns the point at position t of the segment a-b, keeping
+// the original points at 0 and 1
+func clippedAt(a, b *gtfs.ShapePoint, t float64) clippedPoint {
+	if t == 0 {
+		return clippedPoint{*a, int(a.Sequence)}
+	}
+	if t == 1 {
+		return clippedPoint{*b, int(b.Sequence)}
+	}
+	return clippedPoint{interpolate(a, b, t), -1}
+}
+
+// interpolate returns the point at position t of the segment a-b
+func interpolate(a, b *gtfs.ShapePoint, t float64) gtfs.ShapePoint {
+	p := gtfs.ShapePoint{
+		Lat:          a.Lat + float32(t*float64(b.Lat-a.Lat)),
+		Lon:          a.Lon + float32(t*float64(b.Lon-a.Lon)),
+		DistTraveled: float32(math.NaN()),
+	}
+
+	if a.HasDistanceTraveled() && b.HasDistanceTraveled() {
+		p.DistTraveled = a.DistTraveled + float32(t*float64(b.DistTraveled-a.DistTraveled))
+	}
+
+	return p
+}
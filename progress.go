@@ -0,0 +1,136 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+// number of rows between two cancellation checks / progress reports
+const progressInterval = 10000
+
+// ParseProgress describes the progress of parsing a single GTFS file.
+// Note that some files (stop_times.txt, shapes.txt) may be read more
+// than once.
+type ParseProgress struct {
+	File       string
+	Rows       int
+	BytesRead  int64
+	BytesTotal int64 // -1 if unknown
+	Done       bool
+}
+
+// progressReader counts the bytes read from a GTFS file
+type progressReader struct {
+	r     io.Reader
+	name  string
+	read  atomic.Int64
+	total int64
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, e := pr.r.Read(p)
+	pr.read.Add(int64(n))
+	return n, e
+}
+
+// SetProgressHandler sets a function which is periodically called with
+// the parsing progress of the current file. If files are parsed
+// concurrently, the handler is never called concurrently, but reports of
+// different files may be interleaved.
+func (feed *Feed) SetProgressHandler(handler func(ParseProgress)) {
+	feed.progress = handler
+}
+
+// ParseContext parses the GTFS data in the specified folder or ZIP file
+// into the feed. Parsing is aborted with the context error if ctx is
+// cancelled.
+func (feed *Feed) ParseContext(ctx context.Context, path string) error {
+	return feed.PrefixParseContext(ctx, path, "")
+}
+
+// PrefixParseContext parses the GTFS data in the specified folder or ZIP
+// file into the feed, use an id prefix. Parsing is aborted with the
+// context error if ctx is cancelled.
+func (feed *Feed) PrefixParseContext(ctx context.Context, path string, prefix string) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+
+	feed.ctx = ctx
+	defer func() { feed.ctx = nil }()
+
+	return feed.PrefixParse(path, prefix)
+}
+
+// checkpoint is called between two parsing steps. It frees memory and
+// checks whether parsing has been cancelled.
+func (feed *Feed) checkpoint(e error) error {
+	runtime.GC()
+	if e == nil && feed.ctx != nil {
+		return feed.ctx.Err()
+	}
+	return e
+}
+
+// newCsvParser creates a CsvParser for the current file which checks for
+// cancellation and reports progress
+func (feed *Feed) newCsvParser(file io.Reader, silentfail bool, assumeclean bool) CsvParser {
+	pr, _ := file.(*progressReader)
+	return feed.newTrackedCsvParser(file, pr, silentfail, assumeclean)
+}
+
+// newTrackedCsvParser creates a CsvParser for file, which reports the
+// progress of pr
+func (feed *Feed) newTrackedCsvParser(file io.Reader, pr *progressReader, silentfail bool, assumeclean bool) CsvParser {
+	p := NewCsvParser(file, silentfail, assumeclean)
+	if feed.ctx != nil || feed.progress != nil {
+		p.onLine = func(rows int, eof bool) {
+			feed.onLine(pr, rows, eof)
+		}
+	}
+	if feed.concurrent() {
+		p.prefetch(feed.prefetchQuit, &feed.prefetchWg)
+	}
+	return p
+}
+
+func (feed *Feed) onLine(pr *progressReader, rows int, eof bool) {
+	if !eof && rows%progressInterval != 0 {
+		return
+	}
+
+	if !eof && feed.ctx != nil {
+		if e := feed.ctx.Err(); e != nil {
+			// will be caught by the parse functions
+			panic(e)
+		}
+	}
+
+	feed.reportProgress(pr, rows, eof)
+}
+
+func (feed *Feed) reportProgress(pr *progressReader, rows int, done bool) {
+	if feed.progress == nil || pr == nil {
+		return
+	}
+
+	// files may be parsed concurrently
+	feed.progressMutex.Lock()
+	defer feed.progressMutex.Unlock()
+
+	feed.progress(ParseProgress{
+		File:       pr.name,
+		Rows:       rows,
+		BytesRead:  pr.read.Load(),
+		BytesTotal: pr.total,
+		Done:       done,
+	})
+}
@@ -0,0 +1,578 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// default and maximum number of results of list endpoints
+const (
+	apiDefLimit = 50
+	apiMaxLimit = 1000
+)
+
+// apiHandler serves a parsed feed as a JSON API
+type apiHandler struct {
+	feed *Feed
+	mux  *http.ServeMux
+
+	// sorted IDs
+	agencyIDs []string
+	stopIDs   []string
+
+	routesByAgency map[*gtfs.Agency][]*gtfs.Route
+	tripsByRoute   map[*gtfs.Route][]*gtfs.Trip
+	stopTrips      map[*gtfs.Stop][]stopTripRef
+}
+
+// stopTripRef references the i-th stop time of a trip
+type stopTripRef struct {
+	trip *gtfs.Trip
+	i    int
+}
+
+type apiAgency struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	Lang     string `json:"lang,omitempty"`
+	Phone    string `json:"phone,omitempty"`
+	FareURL  string `json:"fare_url,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+type apiStop struct {
+	ID                 string   `json:"id"`
+	Code               string   `json:"code,omitempty"`
+	Name               string   `json:"name"`
+	Desc               string   `json:"desc,omitempty"`
+	Lat                float32  `json:"lat"`
+	Lon                float32  `json:"lon"`
+	LocationType       int8     `json:"location_type"`
+	ParentStation      string   `json:"parent_station,omitempty"`
+	ZoneID             string   `json:"zone_id,omitempty"`
+	WheelchairBoarding int8     `json:"wheelchair_boarding"`
+	PlatformCode       string   `json:"platform_code,omitempty"`
+	Distance           *float64 `json:"distance,omitempty"`
+}
+
+type apiRoute struct {
+	ID        string `json:"id"`
+	AgencyID  string `json:"agency_id,omitempty"`
+	ShortName string `json:"short_name,omitempty"`
+	LongName  string `json:"long_name,omitempty"`
+	Desc      string `json:"desc,omitempty"`
+	Type      int16  `json:"type"`
+	URL       string `json:"url,omitempty"`
+	Color     string `json:"color,omitempty"`
+	TextColor string `json:"text_color,omitempty"`
+}
+
+type apiStopTime struct {
+	StopID        string    `json:"stop_id"`
+	StopName      string    `json:"stop_name"`
+	Sequence      int       `json:"stop_sequence"`
+	ArrivalTime   gtfs.Time `json:"arrival_time"`
+	DepartureTime gtfs.Time `json:"departure_time"`
+	Headsign      string    `json:"headsign,omitempty"`
+	PickupType    uint8     `json:"pickup_type"`
+	DropOffType   uint8     `json:"drop_off_type"`
+}
+
+type apiTrip struct {
+	ID                   string        `json:"id"`
+	RouteID              string        `json:"route_id"`
+	ServiceID            string        `json:"service_id"`
+	Headsign             string        `json:"headsign,omitempty"`
+	ShortName            string        `json:"short_name,omitempty"`
+	DirectionID          *int8         `json:"direction_id,omitempty"`
+	BlockID              string        `json:"block_id,omitempty"`
+	ShapeID              string        `json:"shape_id,omitempty"`
+	WheelchairAccessible int8          `json:"wheelchair_accessible"`
+	BikesAllowed         int8          `json:"bikes_allowed"`
+	StopTimes            []apiStopTime `json:"stop_times,omitempty"`
+}
+
+type apiDeparture struct {
+	TripID         string    `json:"trip_id"`
+	RouteID        string    `json:"route_id"`
+	RouteShortName string    `json:"route_short_name,omitempty"`
+	Headsign       string    `json:"headsign,omitempty"`
+	Sequence       int       `json:"stop_sequence"`
+	ArrivalTime    gtfs.Time `json:"arrival_time"`
+	DepartureTime  gtfs.Time `json:"departure_time"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// NewHandler returns a read-only HTTP handler serving the feed as a JSON
+// API. The feed must not be modified while the handler is in use.
+//
+// Endpoints:
+//
+//	GET /agencies
+//	GET /agencies/{id}
+//	GET /agencies/{id}/routes
+//	GET /stops?q=name&lat=..&lon=..&radius=meters&limit=n
+//	GET /stops/{id}
+//	GET /stops/{id}/departures?date=YYYYMMDD&from=HH:MM:SS&limit=n
+//	GET /routes/{id}
+//	GET /routes/{id}/trips
+//	GET /trips/{id}
+//
+// Departures are those of trips active on the given service date, their
+// times may thus exceed 24:00:00. Frequency-based trips are expanded.
+func NewHandler(feed *Feed) http.Handler {
+	h := &apiHandler{
+		feed:           feed,
+		mux:            http.NewServeMux(),
+		agencyIDs:      sortedMapKeys(feed.Agencies),
+		stopIDs:        sortedMapKeys(feed.Stops),
+		routesByAgency: make(map[*gtfs.Agency][]*gtfs.Route),
+		tripsByRoute:   make(map[*gtfs.Route][]*gtfs.Trip),
+		stopTrips:      make(map[*gtfs.Stop][]stopTripRef),
+	}
+
+	for _, id := range sortedMapKeys(feed.Routes) {
+		r := feed.Routes[id]
+		h.routesByAgency[r.Agency] = append(h.routesByAgency[r.Agency], r)
+	}
+
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		h.tripsByRoute[t.Route] = append(h.tripsByRoute[t.Route], t)
+		for i := 0; i < t.NumStopTimes(); i++ {
+			st := t.GetStopTime(i)
+			if st.Stop != nil {
+				h.stopTrips[st.Stop] = append(h.stopTrips[st.Stop], stopTripRef{t, i})
+			}
+		}
+	}
+
+	h.mux.HandleFunc("GET /agencies", h.agencies)
+	h.mux.HandleFunc("GET /agencies/{id}", h.agency)
+	h.mux.HandleFunc("GET /agencies/{id}/routes", h.agencyRoutes)
+	h.mux.HandleFunc("GET /stops", h.stops)
+	h.mux.HandleFunc("GET /stops/{id}", h.stop)
+	h.mux.HandleFunc("GET /stops/{id}/departures", h.departures)
+	h.mux.HandleFunc("GET /routes/{id}", h.route)
+	h.mux.HandleFunc("GET /routes/{id}/trips", h.routeTrips)
+	h.mux.HandleFunc("GET /trips/{id}", h.trip)
+
+	return h
+}
+
+func (h *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{msg})
+}
+
+// limitParam returns the limit query parameter, or the default limit
+func limitParam(r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if len(s) == 0 {
+		return apiDefLimit, true
+	}
+	l, e := strconv.Atoi(s)
+	if e != nil || l < 1 {
+		return 0, false
+	}
+	return min(l, apiMaxLimit), true
+}
+
+func (h *apiHandler) agencies(w http.ResponseWriter, r *http.Request) {
+	ret := make([]apiAgency, 0, len(h.agencyIDs))
+	for _, id := range h.agencyIDs {
+		ret = append(ret, newAPIAgency(h.feed.Agencies[id]))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *apiHandler) agency(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.feed.Agencies[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such agency")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIAgency(a))
+}
+
+func (h *apiHandler) agencyRoutes(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.feed.Agencies[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such agency")
+		return
+	}
+
+	routes := h.routesByAgency[a]
+
+	// routes without an agency belong to the only agency of a feed
+	if len(h.feed.Agencies) == 1 {
+		routes = append(routes[:len(routes):len(routes)], h.routesByAgency[nil]...)
+		sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	}
+
+	ret := make([]apiRoute, 0, len(routes))
+	for _, rt := range routes {
+		ret = append(ret, newAPIRoute(rt))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *apiHandler) stops(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	limit, ok := limitParam(r)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	name := strings.ToLower(q.Get("q"))
+
+	byLocation := q.Has("lat") || q.Has("lon")
+	var lat, lon float64
+	radius := 1000.0
+
+	if byLocation {
+		var e1, e2 error
+		lat, e1 = strconv.ParseFloat(q.Get("lat"), 64)
+		lon, e2 = strconv.ParseFloat(q.Get("lon"), 64)
+		if e1 != nil || e2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid lat or lon")
+			return
+		}
+		if q.Has("radius") {
+			var e error
+			radius, e = strconv.ParseFloat(q.Get("radius"), 64)
+			if e != nil || radius <= 0 {
+				writeAPIError(w, http.StatusBadRequest, "Invalid radius")
+				return
+			}
+		}
+	}
+
+	ret := make([]apiStop, 0)
+
+	for _, id := range h.stopIDs {
+		s := h.feed.Stops[id]
+		if len(name) > 0 && !strings.Contains(strings.ToLower(s.Name), name) {
+			continue
+		}
+
+		st := newAPIStop(s)
+
+		if byLocation {
+			if !s.HasLatLon() {
+				continue
+			}
+			d := gtfs.Haversine(lat, lon, float64(s.Lat), float64(s.Lon))
+			if d > radius {
+				continue
+			}
+			st.Distance = &d
+		}
+
+		ret = append(ret, st)
+	}
+
+	if byLocation {
+		sort.SliceStable(ret, func(i, j int) bool { return *ret[i].Distance < *ret[j].Distance })
+	}
+
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *apiHandler) stop(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.feed.Stops[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such stop")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIStop(s))
+}
+
+func (h *apiHandler) departures(w http.ResponseWriter, r *http.Request) {
+	s, ok := h.feed.Stops[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such stop")
+		return
+	}
+
+	q := r.URL.Query()
+
+	limit, ok := limitParam(r)
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, "Invalid limit")
+		return
+	}
+
+	t, e := time.Parse("20060102", q.Get("date"))
+	if e != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid or missing date, expected YYYYMMDD")
+		return
+	}
+	date := gtfs.GetGtfsDateFromTime(t)
+
+	from := 0
+	if q.Has("from") {
+		ft, e := gtfs.ParseTime(q.Get("from"))
+		if e != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid from time, expected HH:MM:SS")
+			return
+		}
+		from = ft.SecondsSinceMidnight()
+	}
+
+	ret := make([]apiDeparture, 0)
+
+	for _, ref := range h.stopTrips[s] {
+		if ref.trip.Service == nil || !ref.trip.Service.IsActiveOn(date) {
+			continue
+		}
+
+		st := ref.trip.GetStopTime(ref.i)
+		dep := apiDeparture{
+			TripID:        ref.trip.ID,
+			Sequence:      st.Sequence(),
+			ArrivalTime:   st.ArrivalTime,
+			DepartureTime: st.DepartureTime,
+		}
+		if ref.trip.Route != nil {
+			dep.RouteID = ref.trip.Route.ID
+			dep.RouteShortName = ref.trip.Route.ShortName
+		}
+		if st.Headsign != nil {
+			dep.Headsign = *st.Headsign
+		} else if ref.trip.Headsign != nil {
+			dep.Headsign = *ref.trip.Headsign
+		}
+
+		for _, offset := range tripStartOffsets(ref.trip) {
+			d := dep
+			var okArr, okDep bool
+			d.ArrivalTime, okArr = shiftTime(d.ArrivalTime, offset)
+			d.DepartureTime, okDep = shiftTime(d.DepartureTime, offset)
+			if !okArr || !okDep {
+				continue
+			}
+			if !d.DepartureTime.Empty() && d.DepartureTime.SecondsSinceMidnight() < from {
+				continue
+			}
+			ret = append(ret, d)
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].DepartureTime.Before(ret[j].DepartureTime) })
+
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// tripStartOffsets returns the offsets in seconds of all runs of a trip
+// relative to its stop times
+func tripStartOffsets(t *gtfs.Trip) []int {
+	if t.Frequencies == nil || len(*t.Frequencies) == 0 || t.NumStopTimes() == 0 {
+		return []int{0}
+	}
+
+	first := t.GetStopTime(0).DepartureTime
+	if first.Empty() {
+		first = t.GetStopTime(0).ArrivalTime
+	}
+	if first.Empty() {
+		return []int{0}
+	}
+
+	ret := make([]int, 0)
+	for _, f := range *t.Frequencies {
+		if f.HeadwaySecs <= 0 || f.StartTime.Empty() || f.EndTime.Empty() {
+			continue
+		}
+		for s := f.StartTime.SecondsSinceMidnight(); s < f.EndTime.SecondsSinceMidnight(); s += f.HeadwaySecs {
+			ret = append(ret, s-first.SecondsSinceMidnight())
+		}
+	}
+
+	return ret
+}
+
+// shiftTime returns the time shifted by offset seconds. Times before
+// midnight are clamped to midnight, false is returned if the time is too
+// large to be represented.
+func shiftTime(t gtfs.Time, offset int) (gtfs.Time, bool) {
+	if t.Empty() {
+		return t, true
+	}
+
+	ret, e := gtfs.NewTimeFromSeconds(max(t.SecondsSinceMidnight()+offset, 0))
+	return ret, e == nil
+}
+
+func (h *apiHandler) route(w http.ResponseWriter, r *http.Request) {
+	rt, ok := h.feed.Routes[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such route")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIRoute(rt))
+}
+
+func (h *apiHandler) routeTrips(w http.ResponseWriter, r *http.Request) {
+	rt, ok := h.feed.Routes[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such route")
+		return
+	}
+
+	ret := make([]apiTrip, 0, len(h.tripsByRoute[rt]))
+	for _, t := range h.tripsByRoute[rt] {
+		ret = append(ret, newAPITrip(t, false))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func (h *apiHandler) trip(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.feed.Trips[r.PathValue("id")]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "No such trip")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPITrip(t, true))
+}
+
+func newAPIAgency(a *gtfs.Agency) apiAgency {
+	return apiAgency{
+		ID:       a.ID,
+		Name:     a.Name,
+		URL:      fmtURL(a.URL),
+		Timezone: a.Timezone.GetTzString(),
+		Lang:     a.Lang.GetLangString(),
+		Phone:    a.Phone,
+		FareURL:  fmtURL(a.FareURL),
+		Email:    fmtMail(a.Email),
+	}
+}
+
+func newAPIStop(s *gtfs.Stop) apiStop {
+	ret := apiStop{
+		ID:                 s.ID,
+		Code:               s.Code,
+		Name:               s.Name,
+		Desc:               s.Desc,
+		Lat:                s.Lat,
+		Lon:                s.Lon,
+		LocationType:       s.LocationType,
+		ZoneID:             s.ZoneID,
+		WheelchairBoarding: s.WheelchairBoarding,
+		PlatformCode:       s.PlatformCode,
+	}
+	if s.ParentStation != nil {
+		ret.ParentStation = s.ParentStation.ID
+	}
+	return ret
+}
+
+func newAPIRoute(r *gtfs.Route) apiRoute {
+	ret := apiRoute{
+		ID:        r.ID,
+		ShortName: r.ShortName,
+		LongName:  r.LongName,
+		Desc:      r.Desc,
+		Type:      r.Type,
+		URL:       fmtURL(r.URL),
+		Color:     r.Color,
+		TextColor: r.TextColor,
+	}
+	if r.Agency != nil {
+		ret.AgencyID = r.Agency.ID
+	}
+	return ret
+}
+
+func newAPITrip(t *gtfs.Trip, withStopTimes bool) apiTrip {
+	ret := apiTrip{
+		ID:                   t.ID,
+		WheelchairAccessible: t.WheelchairAccessible,
+		BikesAllowed:         t.BikesAllowed,
+	}
+	if t.Route != nil {
+		ret.RouteID = t.Route.ID
+	}
+	if t.Service != nil {
+		ret.ServiceID = t.Service.ID
+	}
+	if t.Headsign != nil {
+		ret.Headsign = *t.Headsign
+	}
+	if t.ShortName != nil {
+		ret.ShortName = *t.ShortName
+	}
+	if t.DirectionID >= 0 {
+		dir := t.DirectionID
+		ret.DirectionID = &dir
+	}
+	if t.BlockID != nil {
+		ret.BlockID = *t.BlockID
+	}
+	if t.Shape != nil {
+		ret.ShapeID = t.Shape.ID
+	}
+
+	if !withStopTimes {
+		return ret
+	}
+
+	ret.StopTimes = make([]apiStopTime, 0, t.NumStopTimes())
+	for _, st := range t.GetStopTimes() {
+		if st.Stop == nil {
+			continue
+		}
+		ast := apiStopTime{
+			StopID:        st.Stop.ID,
+			StopName:      st.Stop.Name,
+			Sequence:      st.Sequence(),
+			ArrivalTime:   st.ArrivalTime,
+			DepartureTime: st.DepartureTime,
+			PickupType:    st.Pickup(),
+			DropOffType:   st.DropOff(),
+		}
+		if st.Headsign != nil {
+			ast.Headsign = *st.Headsign
+		}
+		ret.StopTimes = append(ret.StopTimes, ast)
+	}
+
+	return ret
+}
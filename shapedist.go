@@ -0,0 +1,352 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// A StopShapeErr is a stop which is too far away from the shape of a trip
+type StopShapeErr struct {
+	tid  string
+	sid  string
+	shid string
+	dist float64
+	msg  string
+}
+
+func (e *StopShapeErr) Error() string {
+	return e.msg
+}
+
+// TripId returns the ID of the trip
+func (e *StopShapeErr) TripId() string {
+	return e.tid
+}
+
+// StopId returns the ID of the offending stop
+func (e *StopShapeErr) StopId() string {
+	return e.sid
+}
+
+// ShapeId returns the ID of the trip's shape
+func (e *StopShapeErr) ShapeId() string {
+	return e.shid
+}
+
+// Distance returns the distance of the stop to the shape in meters
+func (e *StopShapeErr) Distance() float64 {
+	return e.dist
+}
+
+// CheckStopShapeDistances returns a *StopShapeErr for each stop which is
+// more than maxDist meters away from the shape of its trip. Stops which
+// are close to the shape, but cannot be matched to it in the order of the
+// stop times, are reported with the distance to their ordered match.
+// Trips with the same shape and stops are only reported once.
+func (feed *Feed) CheckStopShapeDistances(maxDist float64) []error {
+	ret := make([]error, 0)
+	seen := make(map[string]bool)
+
+	for _, id := range sortedMapKeys(feed.Trips) {
+		trip := feed.Trips[id]
+		if trip.Shape == nil || len(trip.Shape.Points) < 2 {
+			continue
+		}
+
+		stops := tripStops(trip)
+
+		key := trip.Shape.ID + "\x00" + stopIdsKey(stops)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		matches := matchStops(trip.Shape.Points, stops)
+
+		for i, s := range stops {
+			if s == nil || !s.HasLatLon() {
+				continue
+			}
+
+			if d := nearestShapeDist(trip.Shape.Points, s); d > maxDist {
+				ret = append(ret, &StopShapeErr{trip.ID, s.ID, trip.Shape.ID, d,
+					fmt.Sprintf("Stop %s of trip %s is %.0f m away from shape %s.", s.ID, trip.ID, d, trip.Shape.ID)})
+			} else if d := matches[i].dist; d > maxDist {
+				ret = append(ret, &StopShapeErr{trip.ID, s.ID, trip.Shape.ID, d,
+					fmt.Sprintf("Stop %s of trip %s cannot be matched to shape %s in order, the matched position is %.0f m away.", s.ID, trip.ID, trip.Shape.ID, d)})
+			}
+		}
+	}
+
+	return ret
+}
+
+// SnapStopsToShapes matches the stops of all trips with a shape in order
+// to the shape and sets their shape_dist_traveled to the matched
+// positions. Shapes without any shape_dist_traveled get measures in
+// meters, missing measures of other shapes are interpolated along the
+// shape. The number of updated trips is returned.
+func (feed *Feed) SnapStopsToShapes() int {
+	tripsByShape := make(map[*gtfs.Shape][]*gtfs.Trip)
+	for _, id := range sortedMapKeys(feed.Trips) {
+		t := feed.Trips[id]
+		if t != nil && t.Shape != nil && len(t.Shape.Points) > 1 {
+			tripsByShape[t.Shape] = append(tripsByShape[t.Shape], t)
+		}
+	}
+
+	updated := 0
+
+	for shape, trips := range tripsByShape {
+		if !measureShape(shape) {
+			continue
+		}
+
+		for _, t := range trips {
+			if t.NumStopTimes() == 0 {
+				continue
+			}
+
+			// compact stop patterns may be shared with other trips
+			sts := t.StopTimes
+			if t.CompactStopTimes != nil {
+				sts = t.CompactStopTimes.StopTimes()
+			}
+
+			for i, m := range matchStops(shape.Points, tripStops(t)) {
+				p := interpolate(&shape.Points[m.seg], &shape.Points[m.seg+1], m.t)
+				sts[i].ShapeDistTraveled = p.DistTraveled
+			}
+
+			if t.CompactStopTimes != nil {
+				if feed.compactor == nil {
+					feed.compactor = gtfs.NewStopTimeCompactor()
+				}
+				t.CompactStopTimes = feed.compactor.Compact(sts)
+			}
+			updated++
+		}
+	}
+
+	return updated
+}
+
+// measureShape sets the shape_dist_traveled of all shape points which
+// have none. If no point has one, the measures are in meters. Otherwise,
+// they are interpolated along the shape between the given measures, and
+// extrapolated with their ratio to meters before the first and after the
+// last one. False is returned if this ratio is unknown.
+func measureShape(shape *gtfs.Shape) bool {
+	pts := shape.Points
+
+	lengths := make([]float64, len(pts))
+	measured := make([]int, 0, len(pts))
+	for i := range pts {
+		if i > 0 {
+			lengths[i] = lengths[i-1] + gtfs.Haversine(float64(pts[i-1].Lat), float64(pts[i-1].Lon), float64(pts[i].Lat), float64(pts[i].Lon))
+		}
+		if pts[i].HasDistanceTraveled() {
+			measured = append(measured, i)
+		}
+	}
+
+	if len(measured) == len(pts) {
+		return true
+	}
+
+	if len(measured) == 0 {
+		for i := range pts {
+			pts[i].DistTraveled = float32(lengths[i])
+		}
+		return true
+	}
+
+	first, last := measured[0], measured[len(measured)-1]
+	if lengths[last] == lengths[first] {
+		return false
+	}
+	ratio := float64(pts[last].DistTraveled-pts[first].DistTraveled) / (lengths[last] - lengths[first])
+
+	// index into measured of the next measured point
+	next := 0
+	for i := range pts {
+		if next < len(measured) && measured[next] == i {
+			next++
+			continue
+		}
+
+		if next == 0 {
+			pts[i].DistTraveled = pts[first].DistTraveled - float32((lengths[first]-lengths[i])*ratio)
+		} else if next == len(measured) {
+			pts[i].DistTraveled = pts[last].DistTraveled + float32((lengths[i]-lengths[last])*ratio)
+		} else {
+			a, b := measured[next-1], measured[next]
+			t := 0.0
+			if lengths[b] > lengths[a] {
+				t = (lengths[i] - lengths[a]) / (lengths[b] - lengths[a])
+			}
+			pts[i].DistTraveled = pts[a].DistTraveled + float32(t*float64(pts[b].DistTraveled-pts[a].DistTraveled))
+		}
+	}
+
+	return true
+}
+
+// tripStops returns the stops of the stop times of a trip
+func tripStops(t *gtfs.Trip) []*gtfs.Stop {
+	ret := make([]*gtfs.Stop, t.NumStopTimes())
+	for i := range ret {
+		ret[i] = t.GetStopTime(i).Stop
+	}
+	return ret
+}
+
+// stopMatch is the position of a stop on a shape, at t (0..1) on the
+// segment starting at point seg
+type stopMatch struct {
+	seg  int
+	t    float64
+	dist float64
+}
+
+// projectOnSegment returns the position (0..1) of the point on the
+// segment a-b closest to the stop
+func projectOnSegment(a, b *gtfs.ShapePoint, s *gtfs.Stop) float64 {
+	// local equirectangular projection
+	scale := math.Cos(float64(s.Lat) * math.Pi / 180)
+
+	ax, ay := float64(a.Lon)*scale, float64(a.Lat)
+	bx, by := float64(b.Lon)*scale, float64(b.Lat)
+	px, py := float64(s.Lon)*scale, float64(s.Lat)
+
+	dx, dy := bx-ax, by-ay
+	l := dx*dx + dy*dy
+	if l == 0 {
+		return 0
+	}
+
+	return math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l))
+}
+
+// distOnSegment returns the distance in meters between the stop and the
+// position t on the segment a-b
+func distOnSegment(a, b *gtfs.ShapePoint, t float64, s *gtfs.Stop) float64 {
+	if s == nil || !s.HasLatLon() {
+		return 0
+	}
+	p := interpolate(a, b, t)
+	return gtfs.Haversine(float64(s.Lat), float64(s.Lon), float64(p.Lat), float64(p.Lon))
+}
+
+// nearestShapeDist returns the distance of the stop to the shape in meters
+func nearestShapeDist(pts gtfs.ShapePoints, s *gtfs.Stop) float64 {
+	ret := math.Inf(1)
+	for k := 0; k+1 < len(pts); k++ {
+		t := projectOnSegment(&pts[k], &pts[k+1], s)
+		ret = math.Min(ret, distOnSegment(&pts[k], &pts[k+1], t, s))
+	}
+	return ret
+}
+
+// matchStops matches the stops in order to a shape with at least 2
+// points, minimizing the sum of the distances between the stops and
+// their positions. Stops without coordinates are matched anywhere in
+// order.
+func matchStops(pts gtfs.ShapePoints, stops []*gtfs.Stop) []stopMatch {
+	n, m := len(stops), len(pts)-1
+	if n == 0 {
+		return nil
+	}
+
+	// cost of the best match of the first i stops with stop i on segment
+	// k, the position of stop i there, and the segment of stop i-1
+	cost := make([]float64, m)
+	pos := make([][]float64, n)
+	from := make([][]int32, n)
+
+	proj := func(i, k int) float64 {
+		if stops[i] == nil || !stops[i].HasLatLon() {
+			return 0
+		}
+		return projectOnSegment(&pts[k], &pts[k+1], stops[i])
+	}
+
+	pos[0] = make([]float64, m)
+	from[0] = make([]int32, m)
+	for k := 0; k < m; k++ {
+		pos[0][k] = proj(0, k)
+		cost[k] = distOnSegment(&pts[k], &pts[k+1], pos[0][k], stops[0])
+		from[0][k] = -1
+	}
+
+	next := make([]float64, m)
+
+	for i := 1; i < n; i++ {
+		pos[i] = make([]float64, m)
+		from[i] = make([]int32, m)
+
+		best, bestK := math.Inf(1), -1
+
+		for k := 0; k < m; k++ {
+			t := proj(i, k)
+
+			// stop i-1 on the same segment, stop i may not be before it
+			sameT := math.Max(t, pos[i-1][k])
+			c := cost[k] + distOnSegment(&pts[k], &pts[k+1], sameT, stops[i])
+			pos[i][k], from[i][k] = sameT, int32(k)
+
+			// stop i-1 on an earlier segment
+			if bestK >= 0 {
+				if c2 := best + distOnSegment(&pts[k], &pts[k+1], t, stops[i]); c2 < c {
+					c = c2
+					pos[i][k], from[i][k] = t, int32(bestK)
+				}
+			}
+
+			next[k] = c
+
+			if cost[k] < best {
+				best, bestK = cost[k], k
+			}
+		}
+
+		cost, next = next, cost
+	}
+
+	k := 0
+	for j := 1; j < m; j++ {
+		if cost[j] < cost[k] {
+			k = j
+		}
+	}
+
+	ret := make([]stopMatch, n)
+	for i := n - 1; i >= 0; i-- {
+		t := pos[i][k]
+		ret[i] = stopMatch{k, t, distOnSegment(&pts[k], &pts[k+1], t, stops[i])}
+		k = int(from[i][k])
+	}
+
+	return ret
+}
+
+// stopIdsKey returns a key for a sequence of stops
+func stopIdsKey(stops []*gtfs.Stop) string {
+	var b strings.Builder
+	for _, s := range stops {
+		if s != nil {
+			b.WriteString(s.ID)
+		}
+		b.WriteByte(0)
+	}
+	return b.String()
+}
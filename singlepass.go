@@ -0,0 +1,117 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// number of elements allocated at once by an arena
+const arenaChunkSize = 1 << 16
+
+// arena hands out contiguous slices of a shared buffer to the owners
+// (trips or shapes) whose rows are read one after the other. This avoids
+// a separate pass for counting the rows of each owner, without the
+// overhead of growing a slice per owner.
+type arena[S ~[]E, E any] struct {
+	buf   S
+	owner any
+	start int
+}
+
+// add appends v to the slice *dst of owner
+func (a *arena[S, E]) add(owner any, dst *S, v E) {
+	if owner != a.owner {
+		if len(*dst) > 0 {
+			// the rows of this owner are not contiguous, *dst is capped
+			// so appending to it will copy
+			a.owner = nil
+			*dst = append(*dst, v)
+			return
+		}
+		a.owner = owner
+		a.start = len(a.buf)
+	}
+
+	if len(a.buf) == cap(a.buf) {
+		// move the current owner's part to a new chunk
+		size := arenaChunkSize
+		if 2*(len(a.buf)-a.start) > size {
+			size = 2 * (len(a.buf) - a.start)
+		}
+		buf := make(S, 0, size)
+		a.buf = append(buf, a.buf[a.start:]...)
+		a.start = 0
+	}
+
+	a.buf = append(a.buf, v)
+	*dst = a.buf[a.start:len(a.buf):len(a.buf)]
+}
+
+// reserveStopTimesFirst returns true if the stop times of each trip are
+// counted before stop_times.txt is parsed
+func (feed *Feed) reserveStopTimesFirst() bool {
+	return !feed.opts.SinglePass && !feed.streamStopTimes()
+}
+
+// reserveShapesFirst returns true if the points of each shape are
+// counted before shapes.txt is parsed
+func (feed *Feed) reserveShapesFirst() bool {
+	return !feed.opts.SinglePass && !feed.streamShapes()
+}
+
+func (feed *Feed) addStopTime(trip *gtfs.Trip, st gtfs.StopTime) {
+	if feed.opts.SinglePass && !feed.streamStopTimes() {
+		feed.stopTimeArena.add(trip, &trip.StopTimes, st)
+	} else {
+		trip.StopTimes = append(trip.StopTimes, st)
+	}
+}
+
+func (feed *Feed) addShapePoint(shape *gtfs.Shape, p gtfs.ShapePoint) {
+	if feed.opts.SinglePass && !feed.streamShapes() {
+		feed.shapePointArena.add(shape, &shape.Points, p)
+	} else {
+		shape.Points = append(shape.Points, p)
+	}
+}
+
+// peekHeader returns the CSV header of r without consuming it
+func peekHeader(r *bufio.Reader) []string {
+	buf, _ := r.Peek(r.Size())
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+
+	p := NewCsvParser(bytes.NewReader(buf), true, false)
+	return p.GetHeader()
+}
+
+// openStopTimes opens stop_times.txt once for reading both the header and
+// the rows. Rows are read in clean CSV mode if possible.
+func (feed *Feed) openStopTimes() (CsvParser, error) {
+	file, e := feed.getFile("stop_times.txt")
+
+	if e != nil {
+		return CsvParser{}, e
+	}
+
+	br := bufio.NewReaderSize(file, 64*1024)
+
+	hasHeadsign := false
+	for _, h := range peekHeader(br) {
+		if h == "stop_headsign" {
+			hasHeadsign = true
+		}
+	}
+
+	pr, _ := file.(*progressReader)
+	return feed.newTrackedCsvParser(br, pr, feed.opts.DropErroneous, feed.opts.AssumeCleanCsv && !hasHeadsign), nil
+}
@@ -0,0 +1,1426 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"sort"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+const snapshotMagic = "GTFSSNAP"
+
+// version of the snapshot format, increase on every change
+const snapshotVersion = 3
+
+// maxSnapshotLen is the maximum length of a string and the maximum number
+// of elements of a slice or map in a snapshot. Larger lengths are only
+// found in corrupt snapshots and are rejected before allocating.
+const maxSnapshotLen = 1 << 26
+
+// SaveSnapshot writes the parsed feed to w in a compact binary format,
+// which can be read back with LoadSnapshot. Pointer relationships between
+// entities, additional fields, column orders and error statistics are
+// preserved. References to entities which are not contained in the feed
+// are written as nil.
+func (feed *Feed) SaveSnapshot(w io.Writer) error {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), strs: make(map[string]uint64)}
+
+	sw.w.WriteString(snapshotMagic)
+	sw.uint(snapshotVersion)
+
+	sw.writeFeed(feed)
+
+	return sw.w.Flush()
+}
+
+// LoadSnapshot replaces the contents of the feed with a snapshot written
+// by SaveSnapshot. Parse options and handlers are kept.
+func (feed *Feed) LoadSnapshot(r io.Reader) (err error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), empty: &feed.emptyString}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Could not load snapshot: %v", r)
+		}
+	}()
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, e := io.ReadFull(sr.r, magic); e != nil || string(magic) != snapshotMagic {
+		return errors.New("Could not load snapshot: not a feed snapshot")
+	}
+
+	if v := sr.uint(); v != snapshotVersion {
+		return fmt.Errorf("Could not load snapshot: unsupported version %d, expected %d", v, snapshotVersion)
+	}
+
+	loaded := NewFeed()
+	sr.readFeed(loaded)
+
+	feed.Agencies = loaded.Agencies
+	feed.Stops = loaded.Stops
+	feed.Routes = loaded.Routes
+	feed.Trips = loaded.Trips
+	feed.Services = loaded.Services
+	feed.FareAttributes = loaded.FareAttributes
+	feed.Shapes = loaded.Shapes
+	feed.Levels = loaded.Levels
+	feed.Pathways = loaded.Pathways
+	feed.Transfers = loaded.Transfers
+	feed.FeedInfos = loaded.FeedInfos
+	feed.StopsAddFlds = loaded.StopsAddFlds
+	feed.AgenciesAddFlds = loaded.AgenciesAddFlds
+	feed.RoutesAddFlds = loaded.RoutesAddFlds
+	feed.TripsAddFlds = loaded.TripsAddFlds
+	feed.StopTimesAddFlds = loaded.StopTimesAddFlds
+	feed.FrequenciesAddFlds = loaded.FrequenciesAddFlds
+	feed.ShapesAddFlds = loaded.ShapesAddFlds
+	feed.FareRulesAddFlds = loaded.FareRulesAddFlds
+	feed.LevelsAddFlds = loaded.LevelsAddFlds
+	feed.PathwaysAddFlds = loaded.PathwaysAddFlds
+	feed.FareAttributesAddFlds = loaded.FareAttributesAddFlds
+	feed.TransfersAddFlds = loaded.TransfersAddFlds
+	feed.FeedInfosAddFlds = loaded.FeedInfosAddFlds
+	feed.AttributionsAddFlds = loaded.AttributionsAddFlds
+	feed.TranslationsAddFlds = loaded.TranslationsAddFlds
+	feed.Attributions = loaded.Attributions
+	feed.ErrorStats = loaded.ErrorStats
+	feed.NumShpPoints = loaded.NumShpPoints
+	feed.NumStopTimes = loaded.NumStopTimes
+	feed.ColOrders = loaded.ColOrders
+	feed.compactor = loaded.compactor
+	feed.lastTrip = nil
+	feed.lastShape = nil
+
+	return nil
+}
+
+// sortedKeys returns the keys of m in sorted order, and the index of
+// each entity in this order
+func sortedKeys[T any](m map[string]*T) ([]string, map[*T]uint64) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	idx := make(map[*T]uint64, len(m))
+	for i, k := range keys {
+		if m[k] != nil {
+			idx[m[k]] = uint64(i)
+		}
+	}
+
+	return keys, idx
+}
+
+// addToIndex adds p to the index, if it is not already contained
+func addToIndex[T any](idx map[*T]uint64, order *[]*T, p *T) {
+	if p == nil {
+		return
+	}
+	if _, ok := idx[p]; !ok {
+		idx[p] = uint64(len(*order))
+		*order = append(*order, p)
+	}
+}
+
+type snapshotWriter struct {
+	w    *bufio.Writer
+	strs map[string]uint64
+	buf  [binary.MaxVarintLen64]byte
+
+	agencies     map[*gtfs.Agency]uint64
+	levels       map[*gtfs.Level]uint64
+	stops        map[*gtfs.Stop]uint64
+	routes       map[*gtfs.Route]uint64
+	services     map[*gtfs.Service]uint64
+	shapes       map[*gtfs.Shape]uint64
+	trips        map[*gtfs.Trip]uint64
+	fareAttrs    map[*gtfs.FareAttribute]uint64
+	attributions map[*gtfs.Attribution]uint64
+	translations map[*gtfs.Translation]uint64
+	frequencies  map[*gtfs.Frequency]uint64
+	fareRules    map[*gtfs.FareAttributeRule]uint64
+	feedInfos    map[*gtfs.FeedInfo]uint64
+	transfers    map[gtfs.TransferKey]uint64
+}
+
+func (sw *snapshotWriter) uint(v uint64) {
+	n := binary.PutUvarint(sw.buf[:], v)
+	sw.w.Write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) int(v int64) {
+	n := binary.PutVarint(sw.buf[:], v)
+	sw.w.Write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) bool(v bool) {
+	if v {
+		sw.w.WriteByte(1)
+	} else {
+		sw.w.WriteByte(0)
+	}
+}
+
+func (sw *snapshotWriter) f32(v float32) {
+	binary.LittleEndian.PutUint32(sw.buf[:4], math.Float32bits(v))
+	sw.w.Write(sw.buf[:4])
+}
+
+// str writes a string. Strings already written before are written as a
+// reference to the first occurrence.
+func (sw *snapshotWriter) str(s string) {
+	if i, ok := sw.strs[s]; ok {
+		sw.uint(i + 1)
+		return
+	}
+
+	sw.strs[s] = uint64(len(sw.strs))
+	sw.uint(0)
+	sw.uint(uint64(len(s)))
+	sw.w.WriteString(s)
+}
+
+func (sw *snapshotWriter) strPtr(s *string) {
+	sw.bool(s != nil)
+	if s != nil {
+		sw.str(*s)
+	}
+}
+
+// length writes the length of a slice, keeping nil and empty slices apart
+func (sw *snapshotWriter) length(isNil bool, l int) {
+	if isNil {
+		sw.uint(0)
+	} else {
+		sw.uint(uint64(l) + 1)
+	}
+}
+
+func (sw *snapshotWriter) url(u *url.URL) {
+	sw.bool(u != nil)
+	if u != nil {
+		sw.str(u.String())
+	}
+}
+
+func (sw *snapshotWriter) mail(m *mail.Address) {
+	sw.bool(m != nil)
+	if m != nil {
+		sw.str(m.Name)
+		sw.str(m.Address)
+	}
+}
+
+func (sw *snapshotWriter) date(d gtfs.Date) {
+	sw.w.WriteByte(d.Day())
+	sw.w.WriteByte(d.Month())
+	sw.w.WriteByte(uint8(d.Year() - 1900))
+}
+
+func (sw *snapshotWriter) time(t gtfs.Time) {
+	sw.int(int64(t.Hour))
+	sw.w.WriteByte(uint8(t.Minute))
+	sw.w.WriteByte(uint8(t.Second))
+}
+
+// writeRef writes a reference to an entity, 0 is nil
+func writeRef[T any](sw *snapshotWriter, idx map[*T]uint64, p *T) {
+	if i, ok := idx[p]; ok && p != nil {
+		sw.uint(i + 1)
+	} else {
+		sw.uint(0)
+	}
+}
+
+// writeKeys writes the keys of an entity map, and whether the entity is nil
+func writeKeys[T any](sw *snapshotWriter, keys []string, m map[string]*T) {
+	sw.uint(uint64(len(keys)))
+	for _, k := range keys {
+		sw.str(k)
+		sw.bool(m[k] != nil)
+	}
+}
+
+func (sw *snapshotWriter) translationRefs(ts []*gtfs.Translation) {
+	sw.length(ts == nil, len(ts))
+	for _, t := range ts {
+		writeRef(sw, sw.translations, t)
+	}
+}
+
+func (sw *snapshotWriter) attributionRefs(as []*gtfs.Attribution) {
+	sw.length(as == nil, len(as))
+	for _, a := range as {
+		writeRef(sw, sw.attributions, a)
+	}
+}
+
+// structFields writes all fields of a struct holding only ints or string
+// slices, like ErrStats and ColOrders
+func (sw *snapshotWriter) structFields(v reflect.Value) {
+	sw.uint(uint64(v.NumField()))
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Int:
+			sw.int(f.Int())
+		case reflect.Slice:
+			sw.length(f.IsNil(), f.Len())
+			for j := 0; j < f.Len(); j++ {
+				sw.str(f.Index(j).String())
+			}
+		}
+	}
+}
+
+func (sw *snapshotWriter) writeFeed(feed *Feed) {
+	agencyKeys, agencies := sortedKeys(feed.Agencies)
+	levelKeys, levels := sortedKeys(feed.Levels)
+	stopKeys, stops := sortedKeys(feed.Stops)
+	routeKeys, routes := sortedKeys(feed.Routes)
+	serviceKeys, services := sortedKeys(feed.Services)
+	shapeKeys, shapes := sortedKeys(feed.Shapes)
+	tripKeys, trips := sortedKeys(feed.Trips)
+	fareAttrKeys, fareAttrs := sortedKeys(feed.FareAttributes)
+	pathwayKeys, _ := sortedKeys(feed.Pathways)
+
+	sw.agencies, sw.levels, sw.stops, sw.routes = agencies, levels, stops, routes
+	sw.services, sw.shapes, sw.trips, sw.fareAttrs = services, shapes, trips, fareAttrs
+
+	// attributions and translations may be shared between entities
+	sw.attributions = make(map[*gtfs.Attribution]uint64)
+	attributions := make([]*gtfs.Attribution, 0)
+	sw.translations = make(map[*gtfs.Translation]uint64)
+	translations := make([]*gtfs.Translation, 0)
+
+	for _, a := range feed.Attributions {
+		addToIndex(sw.attributions, &attributions, a)
+	}
+	for _, k := range agencyKeys {
+		if a := feed.Agencies[k]; a != nil {
+			for _, at := range a.Attributions {
+				addToIndex(sw.attributions, &attributions, at)
+			}
+			for _, t := range a.Translations {
+				addToIndex(sw.translations, &translations, t)
+			}
+		}
+	}
+	for _, k := range levelKeys {
+		if l := feed.Levels[k]; l != nil {
+			for _, t := range l.Translations {
+				addToIndex(sw.translations, &translations, t)
+			}
+		}
+	}
+	for _, k := range stopKeys {
+		if s := feed.Stops[k]; s != nil {
+			for _, t := range s.Translations {
+				addToIndex(sw.translations, &translations, t)
+			}
+		}
+	}
+	for _, k := range routeKeys {
+		if r := feed.Routes[k]; r != nil {
+			for _, at := range r.Attributions {
+				addToIndex(sw.attributions, &attributions, at)
+			}
+		}
+	}
+	for _, k := range tripKeys {
+		if t := feed.Trips[k]; t != nil {
+			if t.Attributions != nil {
+				for _, at := range *t.Attributions {
+					addToIndex(sw.attributions, &attributions, at)
+				}
+			}
+			if t.Translations != nil {
+				for _, tr := range *t.Translations {
+					addToIndex(sw.translations, &translations, tr)
+				}
+			}
+		}
+	}
+	for _, k := range pathwayKeys {
+		if p := feed.Pathways[k]; p != nil {
+			for _, t := range p.Translations {
+				addToIndex(sw.translations, &translations, t)
+			}
+		}
+	}
+
+	sw.uint(uint64(len(attributions)))
+	for _, a := range attributions {
+		sw.str(a.ID)
+		sw.str(a.OrganizationName)
+		sw.bool(a.IsProducer)
+		sw.bool(a.IsOperator)
+		sw.bool(a.IsAuthority)
+		sw.mail(a.Email)
+		sw.url(a.URL)
+		sw.str(a.Phone)
+	}
+
+	sw.uint(uint64(len(translations)))
+	for _, t := range translations {
+		sw.str(t.FieldName)
+		sw.str(t.Language.GetLangString())
+		sw.str(t.Translation)
+		sw.str(t.FieldValue)
+	}
+
+	writeKeys(sw, agencyKeys, feed.Agencies)
+	for _, k := range agencyKeys {
+		a := feed.Agencies[k]
+		if a == nil {
+			continue
+		}
+		sw.str(a.ID)
+		sw.str(a.Name)
+		sw.url(a.URL)
+		sw.str(a.Timezone.GetTzString())
+		sw.str(a.Lang.GetLangString())
+		sw.str(a.Phone)
+		sw.url(a.FareURL)
+		sw.mail(a.Email)
+		sw.attributionRefs(a.Attributions)
+		sw.translationRefs(a.Translations)
+	}
+
+	writeKeys(sw, levelKeys, feed.Levels)
+	for _, k := range levelKeys {
+		l := feed.Levels[k]
+		if l == nil {
+			continue
+		}
+		sw.str(l.ID)
+		sw.f32(l.Index)
+		sw.str(l.Name)
+		sw.translationRefs(l.Translations)
+	}
+
+	writeKeys(sw, stopKeys, feed.Stops)
+	for _, k := range stopKeys {
+		s := feed.Stops[k]
+		if s == nil {
+			continue
+		}
+		sw.str(s.ID)
+		sw.str(s.Code)
+		sw.str(s.Name)
+		sw.str(s.Desc)
+		sw.f32(s.Lat)
+		sw.f32(s.Lon)
+		sw.int(int64(s.LocationType))
+		sw.int(int64(s.WheelchairBoarding))
+		sw.str(s.ZoneID)
+		sw.url(s.URL)
+		writeRef(sw, stops, s.ParentStation)
+		sw.translationRefs(s.Translations)
+		writeRef(sw, levels, s.Level)
+		sw.str(s.PlatformCode)
+		sw.str(s.Timezone.GetTzString())
+	}
+
+	writeKeys(sw, routeKeys, feed.Routes)
+	for _, k := range routeKeys {
+		r := feed.Routes[k]
+		if r == nil {
+			continue
+		}
+		sw.str(r.ID)
+		writeRef(sw, agencies, r.Agency)
+		sw.str(r.ShortName)
+		sw.str(r.LongName)
+		sw.str(r.Desc)
+		sw.int(int64(r.Type))
+		sw.url(r.URL)
+		sw.str(r.Color)
+		sw.str(r.TextColor)
+		sw.int(int64(r.SortOrder))
+		sw.int(int64(r.ContinuousPickup))
+		sw.int(int64(r.ContinuousDropOff))
+		sw.attributionRefs(r.Attributions)
+	}
+
+	writeKeys(sw, serviceKeys, feed.Services)
+	for _, k := range serviceKeys {
+		s := feed.Services[k]
+		if s == nil {
+			continue
+		}
+		sw.str(s.ID)
+		sw.w.WriteByte(s.Daymap)
+		sw.date(s.StartDate)
+		sw.date(s.EndDate)
+
+		dates := make([]gtfs.Date, 0, len(s.Exceptions))
+		for d := range s.Exceptions {
+			dates = append(dates, d)
+		}
+		sort.Slice(dates, func(i, j int) bool {
+			return dates[i].GetTime().Before(dates[j].GetTime())
+		})
+
+		sw.length(s.Exceptions == nil, len(dates))
+		for _, d := range dates {
+			sw.date(d)
+			sw.bool(s.Exceptions[d])
+		}
+	}
+
+	writeKeys(sw, shapeKeys, feed.Shapes)
+	for _, k := range shapeKeys {
+		s := feed.Shapes[k]
+		if s == nil {
+			continue
+		}
+		sw.str(s.ID)
+		sw.length(s.Points == nil, len(s.Points))
+		for _, p := range s.Points {
+			sw.f32(p.Lat)
+			sw.f32(p.Lon)
+			sw.uint(uint64(p.Sequence))
+			sw.f32(p.DistTraveled)
+		}
+	}
+
+	sw.frequencies = make(map[*gtfs.Frequency]uint64)
+	numFreqs := uint64(0)
+
+	writeKeys(sw, tripKeys, feed.Trips)
+	for _, k := range tripKeys {
+		t := feed.Trips[k]
+		if t == nil {
+			continue
+		}
+		writeRef(sw, routes, t.Route)
+		writeRef(sw, services, t.Service)
+		sw.strPtr(t.Headsign)
+		writeRef(sw, shapes, t.Shape)
+		sw.str(t.ID)
+		sw.strPtr(t.ShortName)
+		sw.strPtr(t.BlockID)
+
+		// compactly stored stop times are written expanded
+		sw.bool(t.CompactStopTimes != nil)
+		sts := t.GetStopTimes()
+
+		sw.length(sts == nil, len(sts))
+		for i := range sts {
+			st := &sts[i]
+			sw.time(st.ArrivalTime)
+			sw.time(st.DepartureTime)
+			sw.w.WriteByte(st.PickupDropOff)
+			writeRef(sw, stops, st.Stop)
+			sw.strPtr(st.Headsign)
+			sw.int(int64(st.Seq))
+			sw.f32(st.ShapeDistTraveled)
+		}
+
+		if t.Frequencies == nil {
+			sw.length(true, 0)
+		} else {
+			sw.length(false, len(*t.Frequencies))
+			for _, f := range *t.Frequencies {
+				sw.frequencies[f] = numFreqs
+				numFreqs++
+				sw.time(f.StartTime)
+				sw.time(f.EndTime)
+				sw.int(int64(f.HeadwaySecs))
+				sw.bool(f.ExactTimes)
+			}
+		}
+
+		if t.Attributions == nil {
+			sw.length(true, 0)
+		} else {
+			sw.attributionRefs(*t.Attributions)
+		}
+
+		if t.Translations == nil {
+			sw.length(true, 0)
+		} else {
+			sw.translationRefs(*t.Translations)
+		}
+
+		sw.int(int64(t.DirectionID))
+		sw.int(int64(t.WheelchairAccessible))
+		sw.int(int64(t.BikesAllowed))
+	}
+
+	sw.fareRules = make(map[*gtfs.FareAttributeRule]uint64)
+	numFareRules := uint64(0)
+
+	writeKeys(sw, fareAttrKeys, feed.FareAttributes)
+	for _, k := range fareAttrKeys {
+		fa := feed.FareAttributes[k]
+		if fa == nil {
+			continue
+		}
+		sw.str(fa.ID)
+		sw.str(fa.Price.String())
+		sw.str(fa.CurrencyType.GetCurrencyString())
+		sw.int(int64(fa.PaymentMethod))
+		sw.int(int64(fa.Transfers))
+		writeRef(sw, agencies, fa.Agency)
+		sw.int(int64(fa.TransferDuration))
+		sw.length(fa.Rules == nil, len(fa.Rules))
+		for _, r := range fa.Rules {
+			sw.fareRules[r] = numFareRules
+			numFareRules++
+			writeRef(sw, routes, r.Route)
+			sw.str(r.OriginID)
+			sw.str(r.DestinationID)
+			sw.str(r.ContainsID)
+		}
+	}
+
+	writeKeys(sw, pathwayKeys, feed.Pathways)
+	for _, k := range pathwayKeys {
+		p := feed.Pathways[k]
+		if p == nil {
+			continue
+		}
+		sw.str(p.ID)
+		writeRef(sw, stops, p.FromStop)
+		writeRef(sw, stops, p.ToStop)
+		sw.w.WriteByte(p.Mode)
+		sw.bool(p.IsBidirectional)
+		sw.f32(p.Length)
+		sw.int(int64(p.TraversalTime))
+		sw.int(int64(p.StairCount))
+		sw.f32(p.MaxSlope)
+		sw.f32(p.MinWidth)
+		sw.str(p.SignpostedAs)
+		sw.str(p.ReversedSignpostedAs)
+		sw.translationRefs(p.Translations)
+	}
+
+	// order transfers by their references
+	transfers := make([]gtfs.TransferKey, 0, len(feed.Transfers))
+	for tk := range feed.Transfers {
+		transfers = append(transfers, tk)
+	}
+	tkRefs := func(tk gtfs.TransferKey) [6]uint64 {
+		ref := func(i uint64, ok bool) uint64 {
+			if ok {
+				return i + 1
+			}
+			return 0
+		}
+		var r [6]uint64
+		i, ok := stops[tk.FromStop]
+		r[0] = ref(i, ok)
+		i, ok = stops[tk.ToStop]
+		r[1] = ref(i, ok)
+		i, ok = routes[tk.FromRoute]
+		r[2] = ref(i, ok)
+		i, ok = routes[tk.ToRoute]
+		r[3] = ref(i, ok)
+		i, ok = trips[tk.FromTrip]
+		r[4] = ref(i, ok)
+		i, ok = trips[tk.ToTrip]
+		r[5] = ref(i, ok)
+		return r
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		a, b := tkRefs(transfers[i]), tkRefs(transfers[j])
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	sw.transfers = make(map[gtfs.TransferKey]uint64, len(transfers))
+	sw.uint(uint64(len(transfers)))
+	for i, tk := range transfers {
+		sw.transfers[tk] = uint64(i)
+		writeRef(sw, stops, tk.FromStop)
+		writeRef(sw, stops, tk.ToStop)
+		writeRef(sw, routes, tk.FromRoute)
+		writeRef(sw, routes, tk.ToRoute)
+		writeRef(sw, trips, tk.FromTrip)
+		writeRef(sw, trips, tk.ToTrip)
+		tv := feed.Transfers[tk]
+		sw.int(int64(tv.TransferType))
+		sw.int(int64(tv.MinTransferTime))
+	}
+
+	sw.feedInfos = make(map[*gtfs.FeedInfo]uint64)
+	sw.length(feed.FeedInfos == nil, len(feed.FeedInfos))
+	for i, fi := range feed.FeedInfos {
+		sw.feedInfos[fi] = uint64(i)
+		sw.str(fi.PublisherName)
+		sw.url(fi.PublisherURL)
+		sw.str(fi.Lang.GetLangString())
+		sw.date(fi.StartDate)
+		sw.date(fi.EndDate)
+		sw.str(fi.Version)
+		sw.mail(fi.ContactEmail)
+		sw.url(fi.ContactURL)
+	}
+
+	sw.attributionRefs(feed.Attributions)
+
+	writeAddFlds(sw, feed.StopsAddFlds)
+	writeAddFlds(sw, feed.AgenciesAddFlds)
+	writeAddFlds(sw, feed.RoutesAddFlds)
+	writeAddFlds(sw, feed.TripsAddFlds)
+	writeSeqAddFlds(sw, feed.StopTimesAddFlds)
+	writeNestedRefAddFlds(sw, feed.FrequenciesAddFlds, sw.frequencies)
+	writeSeqAddFlds(sw, feed.ShapesAddFlds)
+	writeNestedRefAddFlds(sw, feed.FareRulesAddFlds, sw.fareRules)
+	writeAddFlds(sw, feed.LevelsAddFlds)
+	writeAddFlds(sw, feed.PathwaysAddFlds)
+	writeAddFlds(sw, feed.FareAttributesAddFlds)
+	writeTransferAddFlds(sw, feed.TransfersAddFlds)
+	writeRefAddFlds(sw, feed.FeedInfosAddFlds, sw.feedInfos)
+	writeRefAddFlds(sw, feed.AttributionsAddFlds, sw.attributions)
+	writeRefAddFlds(sw, feed.TranslationsAddFlds, sw.translations)
+
+	sw.structFields(reflect.ValueOf(feed.ErrorStats))
+	sw.int(int64(feed.NumShpPoints))
+	sw.int(int64(feed.NumStopTimes))
+	sw.structFields(reflect.ValueOf(feed.ColOrders))
+}
+
+func sortedFlds[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeAddFlds(sw *snapshotWriter, m map[string]map[string]string) {
+	sw.uint(uint64(len(m)))
+	for _, fld := range sortedFlds(m) {
+		sw.str(fld)
+		sw.uint(uint64(len(m[fld])))
+		for _, id := range sortedFlds(m[fld]) {
+			sw.str(id)
+			sw.str(m[fld][id])
+		}
+	}
+}
+
+func writeSeqAddFlds(sw *snapshotWriter, m map[string]map[string]map[int]string) {
+	sw.uint(uint64(len(m)))
+	for _, fld := range sortedFlds(m) {
+		sw.str(fld)
+		sw.uint(uint64(len(m[fld])))
+		for _, id := range sortedFlds(m[fld]) {
+			sw.str(id)
+			seqs := make([]int, 0, len(m[fld][id]))
+			for seq := range m[fld][id] {
+				seqs = append(seqs, seq)
+			}
+			sort.Ints(seqs)
+			sw.uint(uint64(len(seqs)))
+			for _, seq := range seqs {
+				sw.int(int64(seq))
+				sw.str(m[fld][id][seq])
+			}
+		}
+	}
+}
+
+// writeRefMap writes a map keyed by entity references, ordered by the
+// references. Entities not in idx are skipped.
+func writeRefMap[T any](sw *snapshotWriter, m map[*T]string, idx map[*T]uint64) {
+	refs := make([]uint64, 0, len(m))
+	vals := make(map[uint64]string, len(m))
+	for p, v := range m {
+		if i, ok := idx[p]; ok {
+			refs = append(refs, i)
+			vals[i] = v
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+
+	sw.uint(uint64(len(refs)))
+	for _, i := range refs {
+		sw.uint(i)
+		sw.str(vals[i])
+	}
+}
+
+func writeRefAddFlds[T any](sw *snapshotWriter, m map[string]map[*T]string, idx map[*T]uint64) {
+	sw.uint(uint64(len(m)))
+	for _, fld := range sortedFlds(m) {
+		sw.str(fld)
+		writeRefMap(sw, m[fld], idx)
+	}
+}
+
+func writeNestedRefAddFlds[T any](sw *snapshotWriter, m map[string]map[string]map[*T]string, idx map[*T]uint64) {
+	sw.uint(uint64(len(m)))
+	for _, fld := range sortedFlds(m) {
+		sw.str(fld)
+		sw.uint(uint64(len(m[fld])))
+		for _, id := range sortedFlds(m[fld]) {
+			sw.str(id)
+			writeRefMap(sw, m[fld][id], idx)
+		}
+	}
+}
+
+func writeTransferAddFlds(sw *snapshotWriter, m map[string]map[gtfs.TransferKey]string) {
+	sw.uint(uint64(len(m)))
+	for _, fld := range sortedFlds(m) {
+		sw.str(fld)
+		refs := make([]uint64, 0, len(m[fld]))
+		vals := make(map[uint64]string, len(m[fld]))
+		for tk, v := range m[fld] {
+			if i, ok := sw.transfers[tk]; ok {
+				refs = append(refs, i)
+				vals[i] = v
+			}
+		}
+		sort.Slice(refs, func(i, j int) bool { return refs[i] < refs[j] })
+
+		sw.uint(uint64(len(refs)))
+		for _, i := range refs {
+			sw.uint(i)
+			sw.str(vals[i])
+		}
+	}
+}
+
+type snapshotReader struct {
+	r     *bufio.Reader
+	strs  []*string
+	empty *string
+
+	agencies     []*gtfs.Agency
+	levels       []*gtfs.Level
+	stops        []*gtfs.Stop
+	routes       []*gtfs.Route
+	services     []*gtfs.Service
+	shapes       []*gtfs.Shape
+	trips        []*gtfs.Trip
+	fareAttrs    []*gtfs.FareAttribute
+	attributions []*gtfs.Attribution
+	translations []*gtfs.Translation
+	frequencies  []*gtfs.Frequency
+	fareRules    []*gtfs.FareAttributeRule
+	feedInfos    []*gtfs.FeedInfo
+	transfers    []gtfs.TransferKey
+}
+
+func (sr *snapshotReader) uint() uint64 {
+	v, e := binary.ReadUvarint(sr.r)
+	if e != nil {
+		panic(e)
+	}
+	return v
+}
+
+func (sr *snapshotReader) int() int64 {
+	v, e := binary.ReadVarint(sr.r)
+	if e != nil {
+		panic(e)
+	}
+	return v
+}
+
+func (sr *snapshotReader) byte() uint8 {
+	b, e := sr.r.ReadByte()
+	if e != nil {
+		panic(e)
+	}
+	return b
+}
+
+func (sr *snapshotReader) bool() bool {
+	return sr.byte() != 0
+}
+
+func (sr *snapshotReader) f32() float32 {
+	var buf [4]byte
+	if _, e := io.ReadFull(sr.r, buf[:]); e != nil {
+		panic(e)
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(buf[:]))
+}
+
+// strRef reads a string and returns a pointer to it, which is shared by
+// all occurrences of this string
+func (sr *snapshotReader) strRef() *string {
+	i := sr.uint()
+	if i > 0 {
+		if i > uint64(len(sr.strs)) {
+			panic(errors.New("invalid string reference"))
+		}
+		return sr.strs[i-1]
+	}
+
+	l := sr.count()
+	buf := make([]byte, l)
+	if _, e := io.ReadFull(sr.r, buf); e != nil {
+		panic(e)
+	}
+
+	s := new(string)
+	*s = string(buf)
+	if len(*s) == 0 {
+		s = sr.empty
+	}
+
+	sr.strs = append(sr.strs, s)
+	return s
+}
+
+func (sr *snapshotReader) str() string {
+	return *sr.strRef()
+}
+
+func (sr *snapshotReader) strPtr() *string {
+	if !sr.bool() {
+		return nil
+	}
+	return sr.strRef()
+}
+
+// count reads the length of a string or the number of elements of a map
+// or slice
+func (sr *snapshotReader) count() int {
+	n := sr.uint()
+	if n > maxSnapshotLen {
+		panic(fmt.Errorf("length %d exceeds the maximum of %d", n, maxSnapshotLen))
+	}
+	return int(n)
+}
+
+// length reads the length of a slice, returns -1 for nil slices
+func (sr *snapshotReader) length() int {
+	return sr.count() - 1
+}
+
+func (sr *snapshotReader) url() *url.URL {
+	if !sr.bool() {
+		return nil
+	}
+	u, e := url.Parse(sr.str())
+	if e != nil {
+		panic(e)
+	}
+	return u
+}
+
+func (sr *snapshotReader) mail() *mail.Address {
+	if !sr.bool() {
+		return nil
+	}
+	return &mail.Address{Name: sr.str(), Address: sr.str()}
+}
+
+func (sr *snapshotReader) date() gtfs.Date {
+	day := sr.byte()
+	month := sr.byte()
+	year := sr.byte()
+	return gtfs.NewDate(day, month, uint16(year)+1900)
+}
+
+func (sr *snapshotReader) time() gtfs.Time {
+	return gtfs.Time{Hour: int16(sr.int()), Minute: int8(sr.byte()), Second: int8(sr.byte())}
+}
+
+func (sr *snapshotReader) timezone() gtfs.Timezone {
+	// an empty string gives the empty timezone
+	tz, _ := gtfs.NewTimezone(sr.str())
+	return tz
+}
+
+func (sr *snapshotReader) lang() gtfs.LanguageTag {
+	// an empty string gives the empty language
+	l, _ := gtfs.NewLanguageTag(sr.str())
+	return l
+}
+
+func readRef[T any](sr *snapshotReader, entities []*T) *T {
+	i := sr.uint()
+	if i == 0 {
+		return nil
+	}
+	if i > uint64(len(entities)) {
+		panic(errors.New("invalid reference"))
+	}
+	return entities[i-1]
+}
+
+// readKeys reads the keys of an entity map, creates the entities and
+// stores them in m
+func readKeys[T any](sr *snapshotReader, m map[string]*T) []*T {
+	n := sr.count()
+	entities := make([]*T, 0)
+	for i := 0; i < n; i++ {
+		k := sr.str()
+		var e *T
+		if sr.bool() {
+			e = new(T)
+		}
+		m[k] = e
+		entities = append(entities, e)
+	}
+	return entities
+}
+
+func (sr *snapshotReader) translationRefs() []*gtfs.Translation {
+	l := sr.length()
+	if l < 0 {
+		return nil
+	}
+	ts := make([]*gtfs.Translation, l)
+	for i := range ts {
+		ts[i] = readRef(sr, sr.translations)
+	}
+	return ts
+}
+
+func (sr *snapshotReader) attributionRefs() []*gtfs.Attribution {
+	l := sr.length()
+	if l < 0 {
+		return nil
+	}
+	as := make([]*gtfs.Attribution, l)
+	for i := range as {
+		as[i] = readRef(sr, sr.attributions)
+	}
+	return as
+}
+
+func (sr *snapshotReader) structFields(v reflect.Value) {
+	n := int(sr.uint())
+	if n != v.NumField() {
+		panic(fmt.Errorf("expected %d fields for %s, found %d", v.NumField(), v.Type().Name(), n))
+	}
+	for i := 0; i < n; i++ {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Int:
+			f.SetInt(sr.int())
+		case reflect.Slice:
+			l := sr.length()
+			if l < 0 {
+				continue
+			}
+			s := make([]string, l)
+			for j := range s {
+				s[j] = sr.str()
+			}
+			f.Set(reflect.ValueOf(s))
+		}
+	}
+}
+
+func (sr *snapshotReader) readFeed(feed *Feed) {
+	sr.attributions = make([]*gtfs.Attribution, sr.count())
+	for i := range sr.attributions {
+		sr.attributions[i] = &gtfs.Attribution{
+			ID:               sr.str(),
+			OrganizationName: sr.str(),
+			IsProducer:       sr.bool(),
+			IsOperator:       sr.bool(),
+			IsAuthority:      sr.bool(),
+			Email:            sr.mail(),
+			URL:              sr.url(),
+			Phone:            sr.str(),
+		}
+	}
+
+	sr.translations = make([]*gtfs.Translation, sr.count())
+	for i := range sr.translations {
+		sr.translations[i] = &gtfs.Translation{
+			FieldName:   sr.str(),
+			Language:    sr.lang(),
+			Translation: sr.str(),
+			FieldValue:  sr.str(),
+		}
+	}
+
+	sr.agencies = readKeys(sr, feed.Agencies)
+	for _, a := range sr.agencies {
+		if a == nil {
+			continue
+		}
+		a.ID = sr.str()
+		a.Name = sr.str()
+		a.URL = sr.url()
+		a.Timezone = sr.timezone()
+		a.Lang = sr.lang()
+		a.Phone = sr.str()
+		a.FareURL = sr.url()
+		a.Email = sr.mail()
+		a.Attributions = sr.attributionRefs()
+		a.Translations = sr.translationRefs()
+	}
+
+	sr.levels = readKeys(sr, feed.Levels)
+	for _, l := range sr.levels {
+		if l == nil {
+			continue
+		}
+		l.ID = sr.str()
+		l.Index = sr.f32()
+		l.Name = sr.str()
+		l.Translations = sr.translationRefs()
+	}
+
+	sr.stops = readKeys(sr, feed.Stops)
+	for _, s := range sr.stops {
+		if s == nil {
+			continue
+		}
+		s.ID = sr.str()
+		s.Code = sr.str()
+		s.Name = sr.str()
+		s.Desc = sr.str()
+		s.Lat = sr.f32()
+		s.Lon = sr.f32()
+		s.LocationType = int8(sr.int())
+		s.WheelchairBoarding = int8(sr.int())
+		s.ZoneID = sr.str()
+		s.URL = sr.url()
+		s.ParentStation = readRef(sr, sr.stops)
+		s.Translations = sr.translationRefs()
+		s.Level = readRef(sr, sr.levels)
+		s.PlatformCode = sr.str()
+		s.Timezone = sr.timezone()
+	}
+
+	sr.routes = readKeys(sr, feed.Routes)
+	for _, r := range sr.routes {
+		if r == nil {
+			continue
+		}
+		r.ID = sr.str()
+		r.Agency = readRef(sr, sr.agencies)
+		r.ShortName = sr.str()
+		r.LongName = sr.str()
+		r.Desc = sr.str()
+		r.Type = int16(sr.int())
+		r.URL = sr.url()
+		r.Color = sr.str()
+		r.TextColor = sr.str()
+		r.SortOrder = int(sr.int())
+		r.ContinuousPickup = int8(sr.int())
+		r.ContinuousDropOff = int8(sr.int())
+		r.Attributions = sr.attributionRefs()
+	}
+
+	sr.services = readKeys(sr, feed.Services)
+	for _, s := range sr.services {
+		if s == nil {
+			continue
+		}
+		s.ID = sr.str()
+		s.Daymap = sr.byte()
+		s.StartDate = sr.date()
+		s.EndDate = sr.date()
+		if l := sr.length(); l >= 0 {
+			s.Exceptions = make(map[gtfs.Date]bool, l)
+			for i := 0; i < l; i++ {
+				d := sr.date()
+				s.Exceptions[d] = sr.bool()
+			}
+		}
+	}
+
+	sr.shapes = readKeys(sr, feed.Shapes)
+	for _, s := range sr.shapes {
+		if s == nil {
+			continue
+		}
+		s.ID = sr.str()
+		if l := sr.length(); l >= 0 {
+			s.Points = make(gtfs.ShapePoints, l)
+			for i := range s.Points {
+				p := &s.Points[i]
+				p.Lat = sr.f32()
+				p.Lon = sr.f32()
+				p.Sequence = uint32(sr.uint())
+				p.DistTraveled = sr.f32()
+			}
+		}
+	}
+
+	sr.trips = readKeys(sr, feed.Trips)
+	for _, t := range sr.trips {
+		if t == nil {
+			continue
+		}
+		t.Route = readRef(sr, sr.routes)
+		t.Service = readRef(sr, sr.services)
+		t.Headsign = sr.strPtr()
+		t.Shape = readRef(sr, sr.shapes)
+		t.ID = sr.str()
+		t.ShortName = sr.strPtr()
+		t.BlockID = sr.strPtr()
+
+		compact := sr.bool()
+
+		if l := sr.length(); l >= 0 {
+			t.StopTimes = make(gtfs.StopTimes, l)
+			for i := range t.StopTimes {
+				st := &t.StopTimes[i]
+				st.ArrivalTime = sr.time()
+				st.DepartureTime = sr.time()
+				st.PickupDropOff = sr.byte()
+				st.Stop = readRef(sr, sr.stops)
+				st.Headsign = sr.strPtr()
+				st.Seq = int32(sr.int())
+				st.ShapeDistTraveled = sr.f32()
+			}
+		}
+
+		if compact {
+			if feed.compactor == nil {
+				feed.compactor = gtfs.NewStopTimeCompactor()
+			}
+			t.CompactStopTimes = feed.compactor.Compact(t.StopTimes)
+			t.StopTimes = make(gtfs.StopTimes, 0)
+		}
+
+		if l := sr.length(); l >= 0 {
+			freqs := make([]*gtfs.Frequency, l)
+			for i := range freqs {
+				freqs[i] = &gtfs.Frequency{
+					StartTime:   sr.time(),
+					EndTime:     sr.time(),
+					HeadwaySecs: int(sr.int()),
+					ExactTimes:  sr.bool(),
+				}
+				sr.frequencies = append(sr.frequencies, freqs[i])
+			}
+			t.Frequencies = &freqs
+		}
+
+		if as := sr.attributionRefs(); as != nil {
+			t.Attributions = &as
+		}
+
+		if ts := sr.translationRefs(); ts != nil {
+			t.Translations = &ts
+		}
+
+		t.DirectionID = int8(sr.int())
+		t.WheelchairAccessible = int8(sr.int())
+		t.BikesAllowed = int8(sr.int())
+	}
+
+	sr.fareAttrs = readKeys(sr, feed.FareAttributes)
+	for _, fa := range sr.fareAttrs {
+		if fa == nil {
+			continue
+		}
+		fa.ID = sr.str()
+		fa.Price, _ = gtfs.ParseDecimal(sr.str())
+		fa.CurrencyType, _ = gtfs.NewCurrency(sr.str())
+		fa.PaymentMethod = int(sr.int())
+		fa.Transfers = int(sr.int())
+		fa.Agency = readRef(sr, sr.agencies)
+		fa.TransferDuration = int(sr.int())
+		if l := sr.length(); l >= 0 {
+			fa.Rules = make([]*gtfs.FareAttributeRule, l)
+			for i := range fa.Rules {
+				fa.Rules[i] = &gtfs.FareAttributeRule{
+					Route:         readRef(sr, sr.routes),
+					OriginID:      sr.str(),
+					DestinationID: sr.str(),
+					ContainsID:    sr.str(),
+				}
+				sr.fareRules = append(sr.fareRules, fa.Rules[i])
+			}
+		}
+	}
+
+	for _, p := range readKeys(sr, feed.Pathways) {
+		if p == nil {
+			continue
+		}
+		p.ID = sr.str()
+		p.FromStop = readRef(sr, sr.stops)
+		p.ToStop = readRef(sr, sr.stops)
+		p.Mode = sr.byte()
+		p.IsBidirectional = sr.bool()
+		p.Length = sr.f32()
+		p.TraversalTime = int(sr.int())
+		p.StairCount = int(sr.int())
+		p.MaxSlope = sr.f32()
+		p.MinWidth = sr.f32()
+		p.SignpostedAs = sr.str()
+		p.ReversedSignpostedAs = sr.str()
+		p.Translations = sr.translationRefs()
+	}
+
+	sr.transfers = make([]gtfs.TransferKey, sr.count())
+	for i := range sr.transfers {
+		tk := gtfs.TransferKey{
+			FromStop:  readRef(sr, sr.stops),
+			ToStop:    readRef(sr, sr.stops),
+			FromRoute: readRef(sr, sr.routes),
+			ToRoute:   readRef(sr, sr.routes),
+			FromTrip:  readRef(sr, sr.trips),
+			ToTrip:    readRef(sr, sr.trips),
+		}
+		sr.transfers[i] = tk
+		feed.Transfers[tk] = gtfs.TransferVal{
+			TransferType:    int(sr.int()),
+			MinTransferTime: int(sr.int()),
+		}
+	}
+
+	if l := sr.length(); l >= 0 {
+		feed.FeedInfos = make([]*gtfs.FeedInfo, l)
+		for i := range feed.FeedInfos {
+			feed.FeedInfos[i] = &gtfs.FeedInfo{
+				PublisherName: sr.str(),
+				PublisherURL:  sr.url(),
+				Lang:          sr.lang(),
+				StartDate:     sr.date(),
+				EndDate:       sr.date(),
+				Version:       sr.str(),
+				ContactEmail:  sr.mail(),
+				ContactURL:    sr.url(),
+			}
+		}
+	} else {
+		feed.FeedInfos = nil
+	}
+	sr.feedInfos = feed.FeedInfos
+
+	feed.Attributions = sr.attributionRefs()
+
+	feed.StopsAddFlds = sr.addFlds()
+	feed.AgenciesAddFlds = sr.addFlds()
+	feed.RoutesAddFlds = sr.addFlds()
+	feed.TripsAddFlds = sr.addFlds()
+	feed.StopTimesAddFlds = sr.seqAddFlds()
+	feed.FrequenciesAddFlds = readNestedRefAddFlds(sr, sr.frequencies)
+	feed.ShapesAddFlds = sr.seqAddFlds()
+	feed.FareRulesAddFlds = readNestedRefAddFlds(sr, sr.fareRules)
+	feed.LevelsAddFlds = sr.addFlds()
+	feed.PathwaysAddFlds = sr.addFlds()
+	feed.FareAttributesAddFlds = sr.addFlds()
+	feed.TransfersAddFlds = sr.transferAddFlds()
+	feed.FeedInfosAddFlds = readRefAddFlds(sr, sr.feedInfos)
+	feed.AttributionsAddFlds = readRefAddFlds(sr, sr.attributions)
+	feed.TranslationsAddFlds = readRefAddFlds(sr, sr.translations)
+
+	sr.structFields(reflect.ValueOf(&feed.ErrorStats).Elem())
+	feed.NumShpPoints = int(sr.int())
+	feed.NumStopTimes = int(sr.int())
+	sr.structFields(reflect.ValueOf(&feed.ColOrders).Elem())
+}
+
+func (sr *snapshotReader) addFlds() map[string]map[string]string {
+	n := sr.count()
+	m := make(map[string]map[string]string, n)
+	for i := 0; i < n; i++ {
+		fld := sr.str()
+		l := sr.count()
+		m[fld] = make(map[string]string, l)
+		for j := 0; j < l; j++ {
+			id := sr.str()
+			m[fld][id] = sr.str()
+		}
+	}
+	return m
+}
+
+func (sr *snapshotReader) seqAddFlds() map[string]map[string]map[int]string {
+	n := sr.count()
+	m := make(map[string]map[string]map[int]string, n)
+	for i := 0; i < n; i++ {
+		fld := sr.str()
+		l := sr.count()
+		m[fld] = make(map[string]map[int]string, l)
+		for j := 0; j < l; j++ {
+			id := sr.str()
+			ll := sr.count()
+			m[fld][id] = make(map[int]string, ll)
+			for k := 0; k < ll; k++ {
+				seq := int(sr.int())
+				m[fld][id][seq] = sr.str()
+			}
+		}
+	}
+	return m
+}
+
+func readRefMap[T any](sr *snapshotReader, entities []*T) map[*T]string {
+	n := sr.count()
+	m := make(map[*T]string, n)
+	for i := 0; i < n; i++ {
+		idx := sr.uint()
+		if idx >= uint64(len(entities)) {
+			panic(errors.New("invalid reference"))
+		}
+		m[entities[idx]] = sr.str()
+	}
+	return m
+}
+
+func readRefAddFlds[T any](sr *snapshotReader, entities []*T) map[string]map[*T]string {
+	n := sr.count()
+	m := make(map[string]map[*T]string, n)
+	for i := 0; i < n; i++ {
+		fld := sr.str()
+		m[fld] = readRefMap(sr, entities)
+	}
+	return m
+}
+
+func readNestedRefAddFlds[T any](sr *snapshotReader, entities []*T) map[string]map[string]map[*T]string {
+	n := sr.count()
+	m := make(map[string]map[string]map[*T]string, n)
+	for i := 0; i < n; i++ {
+		fld := sr.str()
+		l := sr.count()
+		m[fld] = make(map[string]map[*T]string, l)
+		for j := 0; j < l; j++ {
+			id := sr.str()
+			m[fld][id] = readRefMap(sr, entities)
+		}
+	}
+	return m
+}
+
+func (sr *snapshotReader) transferAddFlds() map[string]map[gtfs.TransferKey]string {
+	n := sr.count()
+	m := make(map[string]map[gtfs.TransferKey]string, n)
+	for i := 0; i < n; i++ {
+		fld := sr.str()
+		l := sr.count()
+		m[fld] = make(map[gtfs.TransferKey]string, l)
+		for j := 0; j < l; j++ {
+			idx := sr.uint()
+			if idx >= uint64(len(sr.transfers)) {
+				panic(errors.New("invalid reference"))
+			}
+			m[fld][sr.transfers[idx]] = sr.str()
+		}
+	}
+	return m
+}
@@ -0,0 +1,215 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// SpeedLimits hold the thresholds of the speed plausibility check between
+// consecutive stop times
+type SpeedLimits struct {
+	// maximum speed in km/h per route type. Extended route types without
+	// a limit use the limit of their basic type, all others use Default.
+	MaxSpeed map[int16]float64
+	Default  float64
+
+	// maximum distance in meters between consecutive stops with the same
+	// time
+	MaxZeroTimeDist float64
+}
+
+// DefaultSpeedLimits returns generous limits for all basic route types
+func DefaultSpeedLimits() *SpeedLimits {
+	return &SpeedLimits{
+		MaxSpeed: map[int16]float64{
+			0:  100, // tram
+			1:  150, // subway
+			2:  500, // rail
+			3:  150, // bus
+			4:  80,  // ferry
+			5:  50,  // cable tram
+			6:  50,  // aerial lift
+			7:  50,  // funicular
+			11: 100, // trolleybus
+			12: 150, // monorail
+		},
+		Default:         500,
+		MaxZeroTimeDist: 1000,
+	}
+}
+
+// maxSpeed returns the speed limit in km/h for a route type
+func (l *SpeedLimits) maxSpeed(t int16) float64 {
+	if v, ok := l.MaxSpeed[t]; ok {
+		return v
+	}
+	if bt, ok := gtfs.RouteType(t).BasicType(); ok {
+		if v, ok := l.MaxSpeed[int16(bt)]; ok {
+			return v
+		}
+	}
+	return l.Default
+}
+
+// checkStopTimeSpeeds checks the speeds between the consecutive stop times
+// of a trip. If DropErroneous is set, the later of two stop times which
+// are too far apart is dropped.
+func (feed *Feed) checkStopTimeSpeeds(trip *gtfs.Trip, opt *ParseOptions) error {
+	limits := opt.SpeedLimits
+	if limits == nil || trip.Route == nil || len(trip.StopTimes) < 2 {
+		return nil
+	}
+
+	maxSpeed := limits.maxSpeed(trip.Route.Type)
+	measure := newShapeMeasure(trip.Shape)
+
+	// last stop time with a time, and the distance traveled since then
+	last := -1
+	dist := 0.0
+
+	for i := 0; i < len(trip.StopTimes); i++ {
+		cur := &trip.StopTimes[i]
+
+		if i > 0 {
+			dist += stopTimeDist(&trip.StopTimes[i-1], cur, measure)
+		}
+
+		arr := cur.ArrivalTime
+		if arr.Empty() {
+			arr = cur.DepartureTime
+		}
+		if arr.Empty() {
+			continue
+		}
+
+		if last >= 0 {
+			prev := &trip.StopTimes[last]
+			dep := prev.DepartureTime
+			if dep.Empty() {
+				dep = prev.ArrivalTime
+			}
+
+			secs := float64(arr.SecondsSinceMidnight() - dep.SecondsSinceMidnight())
+
+			var e error
+
+			if secs <= 0 && dist > limits.MaxZeroTimeDist {
+				e = fmt.Errorf("In trip '%s' for stoptime with seq=%d: the stop is %.0f m away from the previous stop (seq=%d), but the travel time is 0", trip.ID, cur.Sequence(), dist, prev.Sequence())
+			} else if secs > 0 || (arr.Second == 0 && dep.Second == 0) {
+				// times given in full minutes may be rounded
+				if arr.Second == 0 && dep.Second == 0 {
+					secs = math.Max(secs, 60)
+				}
+				if speed := dist / secs * 3.6; speed > maxSpeed {
+					e = fmt.Errorf("In trip '%s' for stoptime with seq=%d: implausible speed of %.0f km/h from the previous stop (seq=%d), the maximum for route type %d is %.0f km/h", trip.ID, cur.Sequence(), speed, prev.Sequence(), trip.Route.Type, maxSpeed)
+				}
+			}
+
+			if e != nil {
+				if opt.UseDefValueOnError {
+					feed.warn(e)
+				} else if opt.DropErroneous {
+					feed.ErrorStats.DroppedStopTimes++
+					trip.StopTimes = trip.StopTimes[:i+copy(trip.StopTimes[i:], trip.StopTimes[i+1:])]
+					feed.warn(e)
+
+					// continue from the previous stop time
+					i--
+					dist = 0
+					for j := last + 1; j <= i; j++ {
+						dist += stopTimeDist(&trip.StopTimes[j-1], &trip.StopTimes[j], measure)
+					}
+					continue
+				} else {
+					return e
+				}
+			}
+		}
+
+		last = i
+		dist = 0
+	}
+
+	return nil
+}
+
+// shapeMeasure maps shape_dist_traveled values of a shape to the
+// geometric distance along it
+type shapeMeasure struct {
+	measures []float64
+	lengths  []float64
+}
+
+// newShapeMeasure returns the measure of a shape, or nil if the shape
+// points have no usable shape_dist_traveled values
+func newShapeMeasure(shape *gtfs.Shape) *shapeMeasure {
+	if shape == nil || len(shape.Points) < 2 {
+		return nil
+	}
+
+	sm := &shapeMeasure{}
+	length := 0.0
+
+	for i := range shape.Points {
+		p := &shape.Points[i]
+		if i > 0 {
+			q := &shape.Points[i-1]
+			length += gtfs.Haversine(float64(q.Lat), float64(q.Lon), float64(p.Lat), float64(p.Lon))
+		}
+		if !p.HasDistanceTraveled() {
+			continue
+		}
+		if n := len(sm.measures); n > 0 && float64(p.DistTraveled) < sm.measures[n-1] {
+			return nil
+		}
+		sm.measures = append(sm.measures, float64(p.DistTraveled))
+		sm.lengths = append(sm.lengths, length)
+	}
+
+	if len(sm.measures) < 2 {
+		return nil
+	}
+
+	return sm
+}
+
+// length returns the geometric distance along the shape at a measure
+func (sm *shapeMeasure) length(m float64) float64 {
+	i := sort.SearchFloat64s(sm.measures, m)
+	if i == 0 {
+		return sm.lengths[0]
+	}
+	if i == len(sm.measures) {
+		return sm.lengths[len(sm.lengths)-1]
+	}
+
+	m0, m1 := sm.measures[i-1], sm.measures[i]
+	if m1 == m0 {
+		return sm.lengths[i]
+	}
+
+	return sm.lengths[i-1] + (m-m0)/(m1-m0)*(sm.lengths[i]-sm.lengths[i-1])
+}
+
+// stopTimeDist returns the distance in meters between two stop times,
+// along the shape if possible
+func stopTimeDist(a, b *gtfs.StopTime, measure *shapeMeasure) float64 {
+	if measure != nil && a.HasDistanceTraveled() && b.HasDistanceTraveled() {
+		return measure.length(float64(b.ShapeDistTraveled)) - measure.length(float64(a.ShapeDistTraveled))
+	}
+
+	if a.Stop == nil || b.Stop == nil || !a.Stop.HasLatLon() || !b.Stop.HasLatLon() {
+		return 0
+	}
+
+	return gtfs.Haversine(float64(a.Stop.Lat), float64(a.Stop.Lon), float64(b.Stop.Lat), float64(b.Stop.Lon))
+}
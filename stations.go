@@ -0,0 +1,265 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// HierarchyErrKind is the kind of a StationHierarchyErr
+type HierarchyErrKind int
+
+const (
+	// a stop references a parent station which does not exist, or an
+	// entrance, generic node or boarding area has no parent station
+	HierarchyMissingParent HierarchyErrKind = iota
+
+	// a station (location_type=1) has a parent station
+	HierarchyStationWithParent
+
+	// the parent has the wrong location_type: platforms, entrances and
+	// generic nodes need a station, boarding areas need a platform
+	HierarchyWrongParentType
+
+	// following the parent stations leads back to the stop
+	HierarchyCycle
+)
+
+// A StationHierarchyErr is a violation of the station hierarchy rules
+type StationHierarchyErr struct {
+	sid  string
+	pid  string
+	kind HierarchyErrKind
+	msg  string
+}
+
+func (e *StationHierarchyErr) Error() string {
+	return e.msg
+}
+
+// StopId returns the ID of the offending stop
+func (e *StationHierarchyErr) StopId() string {
+	return e.sid
+}
+
+// ParentId returns the ID of the offending parent station, if any
+func (e *StationHierarchyErr) ParentId() string {
+	return e.pid
+}
+
+// Kind returns the kind of the violation
+func (e *StationHierarchyErr) Kind() HierarchyErrKind {
+	return e.kind
+}
+
+func newHierarchyErr(kind HierarchyErrKind, sid string, pid string, format string, args ...interface{}) *StationHierarchyErr {
+	return &StationHierarchyErr{sid, pid, kind, fmt.Sprintf(format, args...)}
+}
+
+// ValidateStationHierarchy checks the parent stations of all stops and
+// returns a *StationHierarchyErr for each violation, ordered by stop ID
+func (feed *Feed) ValidateStationHierarchy() []error {
+	ret := make([]error, 0)
+
+	for _, id := range sortedMapKeys(feed.Stops) {
+		if e := feed.checkStopHierarchy(feed.Stops[id]); e != nil {
+			ret = append(ret, e)
+		}
+	}
+
+	return ret
+}
+
+// checkStopHierarchy returns the first hierarchy violation of a stop
+func (feed *Feed) checkStopHierarchy(stop *gtfs.Stop) *StationHierarchyErr {
+	parent := stop.ParentStation
+
+	if parent == nil {
+		if stop.LocationType >= 2 {
+			return newHierarchyErr(HierarchyMissingParent, stop.ID, "", "(for stop id %s) Stop with location_type=%d requires a parent station.", stop.ID, stop.LocationType)
+		}
+		return nil
+	}
+
+	if feed.Stops[parent.ID] != parent {
+		return newHierarchyErr(HierarchyMissingParent, stop.ID, parent.ID, "(for stop id %s) Parent station with id %s does not exist.", stop.ID, parent.ID)
+	}
+
+	switch stop.LocationType {
+	case 1:
+		return newHierarchyErr(HierarchyStationWithParent, stop.ID, parent.ID, "(for stop id %s) 'parent_station' cannot be defined for location_type=1.", stop.ID)
+	case 4:
+		if parent.LocationType != 0 {
+			return newHierarchyErr(HierarchyWrongParentType, stop.ID, parent.ID, "(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=4 (boarding area), which expects a parent station with location_type=0 (stop/platform).", stop.ID, parent.ID, parent.LocationType)
+		}
+	default:
+		if parent.LocationType != 1 {
+			return newHierarchyErr(HierarchyWrongParentType, stop.ID, parent.ID, "(for stop id %s) Station with id %s has location_type=%d, cannot use as parent station here for stop with location_type=%d (must be 1).", stop.ID, parent.ID, parent.LocationType, stop.LocationType)
+		}
+	}
+
+	visited := map[*gtfs.Stop]struct{}{stop: {}}
+	for p := parent; p != nil; p = p.ParentStation {
+		if _, ok := visited[p]; ok {
+			return newHierarchyErr(HierarchyCycle, stop.ID, parent.ID, "(for stop id %s) Parent stations form a cycle.", stop.ID)
+		}
+		visited[p] = struct{}{}
+	}
+
+	return nil
+}
+
+// checkStationHierarchy validates the hierarchy after the parent stations
+// were resolved. Erroneous stops are dropped if DropErroneous is set,
+// which may in turn invalidate their children.
+func (feed *Feed) checkStationHierarchy() error {
+	for {
+		errs := feed.ValidateStationHierarchy()
+		if len(errs) == 0 {
+			return nil
+		}
+
+		if !feed.opts.DropErroneous {
+			return errs[0]
+		}
+
+		for _, e := range errs {
+			delete(feed.Stops, e.(*StationHierarchyErr).StopId())
+			feed.ErrorStats.DroppedStops++
+			feed.warn(e)
+		}
+	}
+}
+
+// GenerateParentStations creates stations (location_type=1) for clusters
+// of stops without a parent station which have the same name and are at
+// most maxDist meters apart from each other. Only clusters of at least 2
+// stops get a station. The number of created stations is returned.
+func (feed *Feed) GenerateParentStations(maxDist float64) int {
+	byName := make(map[string][]*gtfs.Stop)
+
+	for _, id := range sortedMapKeys(feed.Stops) {
+		s := feed.Stops[id]
+		if s.LocationType != 0 || s.ParentStation != nil || !s.HasLatLon() {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(s.Name))
+		if len(name) == 0 {
+			continue
+		}
+		byName[name] = append(byName[name], s)
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	created := 0
+
+	for _, name := range names {
+		for _, cluster := range clusterStops(byName[name], maxDist) {
+			if len(cluster) < 2 {
+				continue
+			}
+			feed.createParentStation(cluster)
+			created++
+		}
+	}
+
+	return created
+}
+
+// clusterStops clusters stops by single linkage, stops in a cluster are
+// connected by distances of at most maxDist meters
+func clusterStops(stops []*gtfs.Stop, maxDist float64) [][]*gtfs.Stop {
+	parent := make([]int, len(stops))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range stops {
+		for j := i + 1; j < len(stops); j++ {
+			a, b := stops[i], stops[j]
+			if gtfs.Haversine(float64(a.Lat), float64(a.Lon), float64(b.Lat), float64(b.Lon)) <= maxDist {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	clusters := make(map[int][]*gtfs.Stop)
+	roots := make([]int, 0)
+	for i, s := range stops {
+		r := find(i)
+		if _, ok := clusters[r]; !ok {
+			roots = append(roots, r)
+		}
+		clusters[r] = append(clusters[r], s)
+	}
+
+	ret := make([][]*gtfs.Stop, 0, len(roots))
+	for _, r := range roots {
+		ret = append(ret, clusters[r])
+	}
+
+	return ret
+}
+
+// createParentStation adds a station at the centroid of the stops and
+// sets it as their parent station
+func (feed *Feed) createParentStation(stops []*gtfs.Stop) *gtfs.Stop {
+	first := stops[0]
+
+	id := first.ID + "_station"
+	for i := 2; feed.Stops[id] != nil; i++ {
+		id = fmt.Sprintf("%s_station_%d", first.ID, i)
+	}
+
+	station := &gtfs.Stop{
+		ID:           id,
+		Name:         first.Name,
+		LocationType: 1,
+		ZoneID:       first.ZoneID,
+		Timezone:     first.Timezone,
+	}
+
+	lat, lon := 0.0, 0.0
+	for _, s := range stops {
+		lat += float64(s.Lat)
+		lon += float64(s.Lon)
+		if s.ZoneID != station.ZoneID {
+			station.ZoneID = ""
+		}
+		if s.Timezone != station.Timezone {
+			station.Timezone = gtfs.Timezone{}
+		}
+	}
+
+	station.Lat = float32(lat / float64(len(stops)))
+	station.Lon = float32(lon / float64(len(stops)))
+
+	feed.Stops[id] = station
+
+	for _, s := range stops {
+		s.ParentStation = station
+	}
+
+	return station
+}
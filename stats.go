@@ -0,0 +1,446 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// HeadwayBuckets are the upper bounds (in minutes) of the headway
+// histogram buckets. The last bucket holds all longer headways.
+var HeadwayBuckets = []int{5, 10, 15, 20, 30, 60}
+
+// FeedStats holds summary statistics of a feed
+type FeedStats struct {
+	Agencies    int `json:"agencies"`
+	Routes      int `json:"routes"`
+	Trips       int `json:"trips"`
+	Stops       int `json:"stops"`
+	Shapes      int `json:"shapes"`
+	Services    int `json:"services"`
+	StopTimes   int `json:"stop_times"`
+	ShapePoints int `json:"shape_points"`
+
+	StopsByLocationType map[int8]int   `json:"stops_by_location_type"`
+	TripsByAgency       map[string]int `json:"trips_by_agency"`
+	TripsByRoute        map[string]int `json:"trips_by_route"`
+	TripsByRouteType    map[int16]int  `json:"trips_by_route_type"`
+
+	// first and last date any service is active
+	ValidFrom  gtfs.Date `json:"valid_from"`
+	ValidUntil gtfs.Date `json:"valid_until"`
+
+	// first departure and last arrival of all trips
+	FirstDeparture gtfs.Time `json:"first_departure"`
+	LastArrival    gtfs.Time `json:"last_arrival"`
+
+	Days []DayStats `json:"days"`
+
+	// the busiest day, on which headways are measured
+	BusiestDay gtfs.Date      `json:"busiest_day"`
+	Headways   []HeadwayStats `json:"headways"`
+}
+
+// DayStats holds the statistics of a single service day. Frequency-based
+// trips are counted once per departure.
+type DayStats struct {
+	Date           gtfs.Date `json:"date"`
+	Trips          int       `json:"trips"`
+	ServiceKm      float64   `json:"service_km"`
+	ServiceHours   float64   `json:"service_hours"`
+	FirstDeparture gtfs.Time `json:"first_departure"`
+	LastArrival    gtfs.Time `json:"last_arrival"`
+}
+
+// HeadwayStats holds the distribution of the headways between the
+// departures of a route in one direction, on the busiest day
+type HeadwayStats struct {
+	Route       string `json:"route"`
+	DirectionID int8   `json:"direction_id"`
+	Departures  int    `json:"departures"`
+
+	// in seconds
+	Min    int `json:"min"`
+	Median int `json:"median"`
+	Max    int `json:"max"`
+
+	// number of headways per bucket of HeadwayBuckets
+	Histogram []int `json:"histogram"`
+}
+
+// tripStats holds the statistics of a single trip
+type tripStats struct {
+	trip  *gtfs.Trip
+	km    float64
+	secs  int
+	first int // first departure, seconds since midnight
+	last  int // last arrival
+
+	// departure offsets of all instances, relative to first
+	starts []int
+}
+
+// serviceStats holds the aggregated statistics of all trips of a service
+type serviceStats struct {
+	trips int
+	km    float64
+	secs  int
+	first int
+	last  int
+}
+
+// Stats computes summary statistics of the feed
+func (feed *Feed) Stats() *FeedStats {
+	stats := &FeedStats{
+		Agencies:            len(feed.Agencies),
+		Routes:              len(feed.Routes),
+		Trips:               len(feed.Trips),
+		Stops:               len(feed.Stops),
+		Shapes:              len(feed.Shapes),
+		Services:            len(feed.Services),
+		StopsByLocationType: make(map[int8]int),
+		TripsByAgency:       make(map[string]int),
+		TripsByRoute:        make(map[string]int),
+		TripsByRouteType:    make(map[int16]int),
+		FirstDeparture:      gtfs.EmptyTime(),
+		LastArrival:         gtfs.EmptyTime(),
+		Days:                make([]DayStats, 0),
+		Headways:            make([]HeadwayStats, 0),
+	}
+
+	for _, s := range feed.Stops {
+		stats.StopsByLocationType[s.LocationType]++
+	}
+
+	for _, s := range feed.Shapes {
+		stats.ShapePoints += len(s.Points)
+	}
+
+	shapeLengths := make(map[*gtfs.Shape]float64)
+	trips := make([]*tripStats, 0, len(feed.Trips))
+	services := make(map[*gtfs.Service]*serviceStats)
+
+	for _, t := range feed.Trips {
+		stats.StopTimes += t.NumStopTimes()
+
+		if t.Route != nil {
+			stats.TripsByRoute[t.Route.ID]++
+			stats.TripsByRouteType[t.Route.Type]++
+			if t.Route.Agency != nil {
+				stats.TripsByAgency[t.Route.Agency.ID]++
+			}
+		}
+
+		ts := newTripStats(t, shapeLengths)
+		if ts == nil {
+			continue
+		}
+		trips = append(trips, ts)
+
+		if t.Service == nil {
+			continue
+		}
+
+		ss, ok := services[t.Service]
+		if !ok {
+			ss = &serviceStats{first: math.MaxInt, last: -1}
+			services[t.Service] = ss
+		}
+
+		n := len(ts.starts)
+		ss.trips += n
+		ss.km += float64(n) * ts.km
+		ss.secs += n * ts.secs
+		ss.first = min(ss.first, ts.first)
+		ss.last = max(ss.last, ts.first+ts.starts[n-1]+ts.secs)
+
+		stats.FirstDeparture = minTime(stats.FirstDeparture, ts.first)
+		stats.LastArrival = maxTime(stats.LastArrival, ts.first+ts.starts[n-1]+ts.secs)
+	}
+
+	for _, s := range feed.Services {
+		first, last := s.GetFirstDefinedDate(), s.GetLastDefinedDate()
+		if !first.IsEmpty() && (stats.ValidFrom.IsEmpty() || first.GetTime().Before(stats.ValidFrom.GetTime())) {
+			stats.ValidFrom = first
+		}
+		if !last.IsEmpty() && (stats.ValidUntil.IsEmpty() || last.GetTime().After(stats.ValidUntil.GetTime())) {
+			stats.ValidUntil = last
+		}
+	}
+
+	if stats.ValidFrom.IsEmpty() || stats.ValidUntil.IsEmpty() {
+		return stats
+	}
+
+	busiest := -1
+
+	for d := stats.ValidFrom; !d.GetTime().After(stats.ValidUntil.GetTime()); d = d.GetOffsettedDate(1) {
+		day := DayStats{Date: d, FirstDeparture: gtfs.EmptyTime(), LastArrival: gtfs.EmptyTime()}
+		secs := 0
+
+		for s, ss := range services {
+			if !s.IsActiveOn(d) {
+				continue
+			}
+			day.Trips += ss.trips
+			day.ServiceKm += ss.km
+			secs += ss.secs
+			day.FirstDeparture = minTime(day.FirstDeparture, ss.first)
+			day.LastArrival = maxTime(day.LastArrival, ss.last)
+		}
+
+		day.ServiceHours = float64(secs) / 3600
+		stats.Days = append(stats.Days, day)
+
+		if day.Trips > busiest {
+			stats.BusiestDay = d
+			busiest = day.Trips
+		}
+	}
+
+	stats.Headways = headwayStats(trips, stats.BusiestDay)
+
+	return stats
+}
+
+// newTripStats returns the statistics of a trip, or nil if it has no times
+func newTripStats(t *gtfs.Trip, shapeLengths map[*gtfs.Shape]float64) *tripStats {
+	sts := t.GetStopTimes()
+
+	ts := &tripStats{trip: t, first: -1, last: -1}
+
+	for _, st := range sts {
+		tm := st.DepartureTime
+		if tm.Empty() {
+			tm = st.ArrivalTime
+		}
+		if !tm.Empty() {
+			ts.first = tm.SecondsSinceMidnight()
+			break
+		}
+	}
+
+	for i := len(sts) - 1; i >= 0; i-- {
+		tm := sts[i].ArrivalTime
+		if tm.Empty() {
+			tm = sts[i].DepartureTime
+		}
+		if !tm.Empty() {
+			ts.last = tm.SecondsSinceMidnight()
+			break
+		}
+	}
+
+	if ts.first < 0 || ts.last < ts.first {
+		return nil
+	}
+
+	ts.secs = ts.last - ts.first
+
+	if t.Shape != nil {
+		l, ok := shapeLengths[t.Shape]
+		if !ok {
+			l = t.Shape.Length()
+			shapeLengths[t.Shape] = l
+		}
+		ts.km = l / 1000
+	} else {
+		for i := 1; i < len(sts); i++ {
+			a, b := sts[i-1].Stop, sts[i].Stop
+			if a != nil && b != nil && a.HasLatLon() && b.HasLatLon() {
+				ts.km += gtfs.Haversine(float64(a.Lat), float64(a.Lon), float64(b.Lat), float64(b.Lon)) / 1000
+			}
+		}
+	}
+
+	if t.Frequencies == nil || len(*t.Frequencies) == 0 {
+		ts.starts = []int{0}
+		return ts
+	}
+
+	// the stop times of frequency-based trips only define relative times
+	start := -1
+	for _, f := range *t.Frequencies {
+		if f.HeadwaySecs <= 0 || f.StartTime.Empty() || f.EndTime.Empty() {
+			continue
+		}
+		for s := f.StartTime.SecondsSinceMidnight(); s < f.EndTime.SecondsSinceMidnight(); s += f.HeadwaySecs {
+			if start < 0 {
+				start = s
+			}
+			ts.starts = append(ts.starts, s-start)
+		}
+	}
+
+	if start < 0 {
+		return nil
+	}
+
+	sort.Ints(ts.starts)
+	ts.first = start + ts.starts[0]
+	for i := range ts.starts {
+		ts.starts[i] -= ts.starts[0]
+	}
+
+	return ts
+}
+
+// headwayStats computes the headway distributions of all routes on a day
+func headwayStats(trips []*tripStats, d gtfs.Date) []HeadwayStats {
+	type key struct {
+		route string
+		dir   int8
+	}
+
+	deps := make(map[key][]int)
+
+	for _, ts := range trips {
+		if ts.trip.Route == nil || ts.trip.Service == nil || !ts.trip.Service.IsActiveOn(d) {
+			continue
+		}
+		k := key{ts.trip.Route.ID, ts.trip.DirectionID}
+		for _, s := range ts.starts {
+			deps[k] = append(deps[k], ts.first+s)
+		}
+	}
+
+	keys := make([]key, 0, len(deps))
+	for k := range deps {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].dir < keys[j].dir
+	})
+
+	ret := make([]HeadwayStats, 0, len(keys))
+
+	for _, k := range keys {
+		times := deps[k]
+		sort.Ints(times)
+
+		hs := HeadwayStats{Route: k.route, DirectionID: k.dir, Departures: len(times), Histogram: make([]int, len(HeadwayBuckets)+1)}
+
+		headways := make([]int, 0, len(times))
+		for i := 1; i < len(times); i++ {
+			headways = append(headways, times[i]-times[i-1])
+		}
+
+		if len(headways) > 0 {
+			sort.Ints(headways)
+			hs.Min = headways[0]
+			hs.Max = headways[len(headways)-1]
+			hs.Median = headways[len(headways)/2]
+
+			for _, h := range headways {
+				b := sort.SearchInts(HeadwayBuckets, (h+59)/60)
+				hs.Histogram[b]++
+			}
+		}
+
+		ret = append(ret, hs)
+	}
+
+	return ret
+}
+
+func minTime(t gtfs.Time, s int) gtfs.Time {
+	if t.Empty() || s < t.SecondsSinceMidnight() {
+		t, _ = gtfs.NewTimeFromSeconds(s)
+	}
+	return t
+}
+
+func maxTime(t gtfs.Time, s int) gtfs.Time {
+	if t.Empty() || s > t.SecondsSinceMidnight() {
+		t, _ = gtfs.NewTimeFromSeconds(s)
+	}
+	return t
+}
+
+// JSON returns the statistics as indented JSON
+func (stats *FeedStats) JSON() ([]byte, error) {
+	return json.MarshalIndent(stats, "", "  ")
+}
+
+// WriteMarkdown writes the statistics as a Markdown report
+func (stats *FeedStats) WriteMarkdown(w io.Writer) error {
+	b := &strings.Builder{}
+
+	fmt.Fprintf(b, "# Feed statistics\n\n")
+	fmt.Fprintf(b, "| | |\n|---|---:|\n")
+	fmt.Fprintf(b, "| Agencies | %d |\n| Routes | %d |\n| Trips | %d |\n| Stops | %d |\n", stats.Agencies, stats.Routes, stats.Trips, stats.Stops)
+	fmt.Fprintf(b, "| Shapes | %d |\n| Services | %d |\n| Stop times | %d |\n| Shape points | %d |\n", stats.Shapes, stats.Services, stats.StopTimes, stats.ShapePoints)
+	fmt.Fprintf(b, "| Valid from | %s |\n| Valid until | %s |\n", stats.ValidFrom, stats.ValidUntil)
+	fmt.Fprintf(b, "| First departure | %s |\n| Last arrival | %s |\n", stats.FirstDeparture, stats.LastArrival)
+
+	fmt.Fprintf(b, "\n## Stops by location type\n\n| Location type | Stops |\n|---|---:|\n")
+	for _, k := range sortedMapKeys(stats.StopsByLocationType) {
+		fmt.Fprintf(b, "| %d | %d |\n", k, stats.StopsByLocationType[k])
+	}
+
+	fmt.Fprintf(b, "\n## Trips by agency\n\n| Agency | Trips |\n|---|---:|\n")
+	for _, k := range sortedMapKeys(stats.TripsByAgency) {
+		fmt.Fprintf(b, "| %s | %d |\n", k, stats.TripsByAgency[k])
+	}
+
+	fmt.Fprintf(b, "\n## Trips by route type\n\n| Route type | Name | Trips |\n|---|---|---:|\n")
+	for _, k := range sortedMapKeys(stats.TripsByRouteType) {
+		fmt.Fprintf(b, "| %d | %s | %d |\n", k, gtfs.RouteType(k).Name(), stats.TripsByRouteType[k])
+	}
+
+	fmt.Fprintf(b, "\n## Trips by route\n\n| Route | Trips |\n|---|---:|\n")
+	for _, k := range sortedMapKeys(stats.TripsByRoute) {
+		fmt.Fprintf(b, "| %s | %d |\n", k, stats.TripsByRoute[k])
+	}
+
+	fmt.Fprintf(b, "\n## Service days\n\n| Date | Trips | Service km | Service hours | First departure | Last arrival |\n|---|---:|---:|---:|---|---|\n")
+	for _, d := range stats.Days {
+		fmt.Fprintf(b, "| %s | %d | %.1f | %.1f | %s | %s |\n", d.Date, d.Trips, d.ServiceKm, d.ServiceHours, d.FirstDeparture, d.LastArrival)
+	}
+
+	fmt.Fprintf(b, "\n## Headways on %s\n\n| Route | Direction | Departures | Min | Median | Max |", stats.BusiestDay)
+	prev := 0
+	for _, bucket := range HeadwayBuckets {
+		fmt.Fprintf(b, " %d-%d min |", prev, bucket)
+		prev = bucket
+	}
+	fmt.Fprintf(b, " > %d min |\n|---|---:|---:|---:|---:|---:|%s\n", prev, strings.Repeat("---:|", len(HeadwayBuckets)+1))
+
+	for _, h := range stats.Headways {
+		fmt.Fprintf(b, "| %s | %d | %d | %s | %s | %s |", h.Route, h.DirectionID, h.Departures, fmtSecs(h.Min), fmtSecs(h.Median), fmtSecs(h.Max))
+		for _, c := range h.Histogram {
+			fmt.Fprintf(b, " %d |", c)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+
+	_, e := io.WriteString(w, b.String())
+	return e
+}
+
+func fmtSecs(s int) string {
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+func sortedMapKeys[K int8 | int16 | string, V any](m map[K]V) []K {
+	ret := make([]K, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// StreamHandlers hold callbacks which are called for parsed entities.
+//
+// If OnStopTime is set, stop times are not retained in Trip.StopTimes,
+// but are delivered trip by trip (ordered by stop_sequence) right after
+// all stop times of a trip have been read. stop_times.txt must then list
+// the stop times of each trip in a contiguous block. The same holds for
+// OnShapePoint, shapes.txt and Shape.Points. Shapes are still kept (without
+// points) in Feed.Shapes, so trips can reference them.
+//
+// All other handlers are called after the respective table has been parsed
+// and validated, in file order. The entities are still stored in the feed,
+// so that they can be used for pointer references. If stop times are not
+// streamed, OnTrip is called after stop_times.txt has been parsed, with
+// Trip.StopTimes filled.
+type StreamHandlers struct {
+	OnAgency     func(*gtfs.Agency)
+	OnStop       func(*gtfs.Stop)
+	OnRoute      func(*gtfs.Route)
+	OnService    func(*gtfs.Service)
+	OnTrip       func(*gtfs.Trip)
+	OnShapePoint func(*gtfs.Shape, *gtfs.ShapePoint)
+	OnStopTime   func(*gtfs.Trip, *gtfs.StopTime)
+}
+
+// SetStreamHandlers sets the StreamHandlers for this feed
+func (feed *Feed) SetStreamHandlers(handlers StreamHandlers) {
+	feed.stream = handlers
+}
+
+func (h *StreamHandlers) empty() bool {
+	return h.OnAgency == nil && h.OnStop == nil && h.OnRoute == nil && h.OnService == nil &&
+		h.OnTrip == nil && h.OnShapePoint == nil && h.OnStopTime == nil
+}
+
+func (feed *Feed) streamStopTimes() bool {
+	return feed.stream.OnStopTime != nil
+}
+
+func (feed *Feed) streamShapes() bool {
+	return feed.stream.OnShapePoint != nil && !feed.opts.DropShapes
+}
+
+// isDateFiltered returns true if a service will be removed because of the
+// date filter
+func (feed *Feed) isDateFiltered(s *gtfs.Service) bool {
+	if feed.opts.DateFilterStart.IsEmpty() && feed.opts.DateFilterEnd.IsEmpty() {
+		return false
+	}
+	return (s.IsEmpty() && s.StartDate.IsEmpty() && s.EndDate.IsEmpty()) || s.GetFirstActiveDate().IsEmpty()
+}
+
+func (feed *Feed) emitServices() {
+	for _, s := range feed.pendingServices {
+		if feed.Services[s.ID] == s && !feed.isDateFiltered(s) {
+			feed.stream.OnService(s)
+		}
+	}
+	feed.pendingServices = nil
+}
+
+func (feed *Feed) emitTrips() {
+	for _, t := range feed.pendingTrips {
+		feed.stream.OnTrip(t)
+	}
+	feed.pendingTrips = nil
+}
+
+// flushStopTimes validates the collected stop times of a trip, hands them
+// over to the OnStopTime handler and releases them
+func (feed *Feed) flushStopTimes(trip *gtfs.Trip) error {
+	sort.Sort(trip.StopTimes)
+	if e := feed.checkStopTimeMeasure(trip, &feed.opts); e != nil {
+		return e
+	}
+	feed.NumStopTimes += len(trip.StopTimes)
+
+	for i := range trip.StopTimes {
+		feed.stream.OnStopTime(trip, &trip.StopTimes[i])
+	}
+
+	trip.StopTimes = nil
+	return nil
+}
+
+// flushShape validates the collected points of a shape, hands them
+// over to the OnShapePoint handler and releases them
+func (feed *Feed) flushShape(shape *gtfs.Shape) error {
+	if len(shape.Points) == 0 {
+		if feed.opts.DropErroneous || len(feed.opts.PolygonFilter) > 0 {
+			// all points have been dropped before, see parseShapes
+			delete(feed.Shapes, shape.ID)
+			return nil
+		}
+		return fmt.Errorf("Shape #%s has no points", shape.ID)
+	}
+
+	sort.Sort(shape.Points)
+	if e := feed.checkShapeMeasure(shape, &feed.opts); e != nil {
+		return e
+	}
+	feed.NumShpPoints += len(shape.Points)
+
+	for i := range shape.Points {
+		feed.stream.OnShapePoint(shape, &shape.Points[i])
+	}
+
+	shape.Points = nil
+	return nil
+}
+
+func notContiguousErr(file string, entity string, id string) error {
+	return fmt.Errorf("Rows for %s '%s' are not contiguous in %s, cannot stream them.", entity, id, file)
+}
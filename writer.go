@@ -0,0 +1,675 @@
+// Copyright 2023 Patrick Brosi
+// Authors: info@patrickbrosi.de
+//
+// Use of this source code is governed by a GPL v2
+// license that can be found in the LICENSE file
+
+package gtfsparser
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"io"
+	"math"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/thecodinglab/gtfsparser/gtfs"
+)
+
+// csvTable writes the rows of a single GTFS file
+type csvTable struct {
+	w       *csv.Writer
+	header  []string
+	addFlds []string
+	row     []string
+}
+
+// WriteZip writes the feed as a GTFS ZIP archive. Additional fields are
+// written if they were kept during parsing. Files without entries are
+// omitted.
+func (feed *Feed) WriteZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	writers := []func(*zip.Writer) error{
+		feed.writeAgencies,
+		feed.writeStops,
+		feed.writeRoutes,
+		feed.writeTrips,
+		feed.writeStopTimes,
+		feed.writeCalendar,
+		feed.writeCalendarDates,
+		feed.writeFareAttributes,
+		feed.writeFareRules,
+		feed.writeShapes,
+		feed.writeFrequencies,
+		feed.writeTransfers,
+		feed.writePathways,
+		feed.writeLevels,
+		feed.writeFeedInfos,
+		feed.writeAttributions,
+	}
+
+	for _, write := range writers {
+		if e := write(zw); e != nil {
+			zw.Close()
+			return e
+		}
+	}
+
+	return zw.Close()
+}
+
+func newCsvTable(zw *zip.Writer, name string, header []string, addFlds []string) (*csvTable, error) {
+	f, e := zw.Create(name)
+	if e != nil {
+		return nil, e
+	}
+
+	t := &csvTable{w: csv.NewWriter(f), header: header, addFlds: addFlds}
+	if e := t.w.Write(append(append([]string(nil), header...), addFlds...)); e != nil {
+		return nil, e
+	}
+	return t, nil
+}
+
+// write writes a row, the values of the additional fields are returned by
+// addFld
+func (t *csvTable) write(addFld func(string) string, vals ...string) error {
+	t.row = append(t.row[:0], vals...)
+	for _, f := range t.addFlds {
+		t.row = append(t.row, addFld(f))
+	}
+	return t.w.Write(t.row)
+}
+
+func (t *csvTable) close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func addFldNames[K comparable](m map[string]map[K]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func nestedAddFldNames[K comparable, L comparable](m map[string]map[K]map[L]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func sortedIDs[T any](m map[string]*T) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func fmtFloat(f float32) string {
+	if math.IsNaN(float64(f)) {
+		return ""
+	}
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+func fmtInt(i int) string {
+	return strconv.Itoa(i)
+}
+
+// fmtOptInt formats i, or returns an empty string if i is negative
+func fmtOptInt(i int) string {
+	if i < 0 {
+		return ""
+	}
+	return strconv.Itoa(i)
+}
+
+func fmtBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func fmtURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+func fmtMail(m *mail.Address) string {
+	if m == nil {
+		return ""
+	}
+	return m.Address
+}
+
+func (feed *Feed) writeAgencies(zw *zip.Writer) error {
+	if len(feed.Agencies) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "agency.txt", []string{"agency_id", "agency_name", "agency_url", "agency_timezone", "agency_lang", "agency_phone", "agency_fare_url", "agency_email"}, addFldNames(feed.AgenciesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Agencies) {
+		a := feed.Agencies[id]
+		e := t.write(func(f string) string { return feed.AgenciesAddFlds[f][id] },
+			a.ID, a.Name, fmtURL(a.URL), a.Timezone.GetTzString(), a.Lang.GetLangString(), a.Phone, fmtURL(a.FareURL), fmtMail(a.Email))
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeStops(zw *zip.Writer) error {
+	if len(feed.Stops) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "stops.txt", []string{"stop_id", "stop_code", "stop_name", "stop_desc", "stop_lat", "stop_lon", "zone_id", "stop_url", "location_type", "parent_station", "stop_timezone", "wheelchair_boarding", "level_id", "platform_code"}, addFldNames(feed.StopsAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Stops) {
+		s := feed.Stops[id]
+		parent, level := "", ""
+		if s.ParentStation != nil {
+			parent = s.ParentStation.ID
+		}
+		if s.Level != nil {
+			level = s.Level.ID
+		}
+		e := t.write(func(f string) string { return feed.StopsAddFlds[f][id] },
+			s.ID, s.Code, s.Name, s.Desc, fmtFloat(s.Lat), fmtFloat(s.Lon), s.ZoneID, fmtURL(s.URL), fmtInt(int(s.LocationType)), parent, s.Timezone.GetTzString(), fmtInt(int(s.WheelchairBoarding)), level, s.PlatformCode)
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeRoutes(zw *zip.Writer) error {
+	if len(feed.Routes) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "routes.txt", []string{"route_id", "agency_id", "route_short_name", "route_long_name", "route_desc", "route_type", "route_url", "route_color", "route_text_color", "route_sort_order", "continuous_pickup", "continuous_drop_off"}, addFldNames(feed.RoutesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Routes) {
+		r := feed.Routes[id]
+		agency := ""
+		if r.Agency != nil {
+			agency = r.Agency.ID
+		}
+		e := t.write(func(f string) string { return feed.RoutesAddFlds[f][id] },
+			r.ID, agency, r.ShortName, r.LongName, r.Desc, fmtInt(int(r.Type)), fmtURL(r.URL), r.Color, r.TextColor, fmtOptInt(r.SortOrder), fmtInt(int(r.ContinuousPickup)), fmtInt(int(r.ContinuousDropOff)))
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeTrips(zw *zip.Writer) error {
+	if len(feed.Trips) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "trips.txt", []string{"route_id", "service_id", "trip_id", "trip_headsign", "trip_short_name", "direction_id", "block_id", "shape_id", "wheelchair_accessible", "bikes_allowed"}, addFldNames(feed.TripsAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Trips) {
+		tr := feed.Trips[id]
+		route, service, headsign, shortName, block, shape := "", "", "", "", "", ""
+		if tr.Route != nil {
+			route = tr.Route.ID
+		}
+		if tr.Service != nil {
+			service = tr.Service.ID
+		}
+		if tr.Headsign != nil {
+			headsign = *tr.Headsign
+		}
+		if tr.ShortName != nil {
+			shortName = *tr.ShortName
+		}
+		if tr.BlockID != nil {
+			block = *tr.BlockID
+		}
+		if tr.Shape != nil {
+			shape = tr.Shape.ID
+		}
+		e := t.write(func(f string) string { return feed.TripsAddFlds[f][id] },
+			route, service, tr.ID, headsign, shortName, fmtOptInt(int(tr.DirectionID)), block, shape, fmtInt(int(tr.WheelchairAccessible)), fmtInt(int(tr.BikesAllowed)))
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeStopTimes(zw *zip.Writer) error {
+	if len(feed.Trips) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "stop_times.txt", []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "stop_headsign", "pickup_type", "drop_off_type", "continuous_pickup", "continuous_drop_off", "shape_dist_traveled", "timepoint"}, nestedAddFldNames(feed.StopTimesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Trips) {
+		tr := feed.Trips[id]
+		for i := 0; i < tr.NumStopTimes(); i++ {
+			st := tr.GetStopTime(i)
+			if st.Stop == nil {
+				continue
+			}
+			headsign := ""
+			if st.Headsign != nil {
+				headsign = *st.Headsign
+			}
+			seq := st.Sequence()
+			e := t.write(func(f string) string { return feed.StopTimesAddFlds[f][id][seq] },
+				tr.ID, st.ArrivalTime.String(), st.DepartureTime.String(), st.Stop.ID, fmtInt(seq), headsign,
+				fmtInt(int(st.Pickup())), fmtInt(int(st.DropOff())), fmtInt(int(st.ContinuousPickup())), fmtInt(int(st.ContinuousDropOff())),
+				fmtFloat(st.ShapeDistTraveled), fmtBool(st.Timepoint()))
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeCalendar(zw *zip.Writer) error {
+	ids := make([]string, 0)
+	for _, id := range sortedIDs(feed.Services) {
+		if !feed.Services[id].StartDate.IsEmpty() {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "calendar.txt", []string{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"}, nil)
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		s := feed.Services[id]
+		e := t.write(nil, s.ID, fmtBool(s.Day(1)), fmtBool(s.Day(2)), fmtBool(s.Day(3)), fmtBool(s.Day(4)), fmtBool(s.Day(5)), fmtBool(s.Day(6)), fmtBool(s.Day(0)), s.StartDate.String(), s.EndDate.String())
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeCalendarDates(zw *zip.Writer) error {
+	ids := make([]string, 0)
+	for _, id := range sortedIDs(feed.Services) {
+		if len(feed.Services[id].Exceptions) > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "calendar_dates.txt", []string{"service_id", "date", "exception_type"}, nil)
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		s := feed.Services[id]
+
+		dates := make([]gtfs.Date, 0, len(s.Exceptions))
+		for d := range s.Exceptions {
+			dates = append(dates, d)
+		}
+		sort.Slice(dates, func(i, j int) bool { return dates[i].GetTime().Before(dates[j].GetTime()) })
+
+		for _, d := range dates {
+			if e := t.write(nil, s.ID, d.String(), fmtInt(int(s.GetExceptionTypeOn(d)))); e != nil {
+				return e
+			}
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeFareAttributes(zw *zip.Writer) error {
+	if len(feed.FareAttributes) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "fare_attributes.txt", []string{"fare_id", "price", "currency_type", "payment_method", "transfers", "agency_id", "transfer_duration"}, addFldNames(feed.FareAttributesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.FareAttributes) {
+		fa := feed.FareAttributes[id]
+		agency, duration := "", ""
+		if fa.Agency != nil {
+			agency = fa.Agency.ID
+		}
+		if fa.TransferDuration > 0 {
+			duration = fmtInt(fa.TransferDuration)
+		}
+		e := t.write(func(f string) string { return feed.FareAttributesAddFlds[f][id] },
+			fa.ID, fa.Price.String(), fa.CurrencyType.GetCurrencyString(), fmtInt(fa.PaymentMethod), fmtOptInt(fa.Transfers), agency, duration)
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeFareRules(zw *zip.Writer) error {
+	ids := make([]string, 0)
+	for _, id := range sortedIDs(feed.FareAttributes) {
+		if len(feed.FareAttributes[id].Rules) > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "fare_rules.txt", []string{"fare_id", "route_id", "origin_id", "destination_id", "contains_id"}, nestedAddFldNames(feed.FareRulesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		for _, r := range feed.FareAttributes[id].Rules {
+			route := ""
+			if r.Route != nil {
+				route = r.Route.ID
+			}
+			e := t.write(func(f string) string { return feed.FareRulesAddFlds[f][id][r] }, id, route, r.OriginID, r.DestinationID, r.ContainsID)
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeShapes(zw *zip.Writer) error {
+	if len(feed.Shapes) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "shapes.txt", []string{"shape_id", "shape_pt_lat", "shape_pt_lon", "shape_pt_sequence", "shape_dist_traveled"}, nestedAddFldNames(feed.ShapesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Shapes) {
+		for _, p := range feed.Shapes[id].Points {
+			seq := int(p.Sequence)
+			e := t.write(func(f string) string { return feed.ShapesAddFlds[f][id][seq] },
+				id, fmtFloat(p.Lat), fmtFloat(p.Lon), fmtInt(seq), fmtFloat(p.DistTraveled))
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeFrequencies(zw *zip.Writer) error {
+	ids := make([]string, 0)
+	for _, id := range sortedIDs(feed.Trips) {
+		if feed.Trips[id].Frequencies != nil && len(*feed.Trips[id].Frequencies) > 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "frequencies.txt", []string{"trip_id", "start_time", "end_time", "headway_secs", "exact_times"}, nestedAddFldNames(feed.FrequenciesAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range ids {
+		for _, f := range *feed.Trips[id].Frequencies {
+			e := t.write(func(fld string) string { return feed.FrequenciesAddFlds[fld][id][f] },
+				id, f.StartTime.String(), f.EndTime.String(), fmtInt(f.HeadwaySecs), fmtBool(f.ExactTimes))
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeTransfers(zw *zip.Writer) error {
+	if len(feed.Transfers) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "transfers.txt", []string{"from_stop_id", "to_stop_id", "from_route_id", "to_route_id", "from_trip_id", "to_trip_id", "transfer_type", "min_transfer_time"}, addFldNames(feed.TransfersAddFlds))
+	if e != nil {
+		return e
+	}
+
+	rows := make([][]string, 0, len(feed.Transfers))
+	keys := make([]gtfs.TransferKey, 0, len(feed.Transfers))
+
+	for tk, tv := range feed.Transfers {
+		row := make([]string, 8)
+		if tk.FromStop != nil {
+			row[0] = tk.FromStop.ID
+		}
+		if tk.ToStop != nil {
+			row[1] = tk.ToStop.ID
+		}
+		if tk.FromRoute != nil {
+			row[2] = tk.FromRoute.ID
+		}
+		if tk.ToRoute != nil {
+			row[3] = tk.ToRoute.ID
+		}
+		if tk.FromTrip != nil {
+			row[4] = tk.FromTrip.ID
+		}
+		if tk.ToTrip != nil {
+			row[5] = tk.ToTrip.ID
+		}
+		row[6] = fmtInt(tv.TransferType)
+		row[7] = fmtOptInt(tv.MinTransferTime)
+		rows = append(rows, row)
+		keys = append(keys, tk)
+	}
+
+	// keys are pointers, sort by IDs for a stable output
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := rows[order[i]], rows[order[j]]
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	for _, i := range order {
+		tk := keys[i]
+		if e := t.write(func(f string) string { return feed.TransfersAddFlds[f][tk] }, rows[i]...); e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writePathways(zw *zip.Writer) error {
+	if len(feed.Pathways) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "pathways.txt", []string{"pathway_id", "from_stop_id", "to_stop_id", "pathway_mode", "is_bidirectional", "length", "traversal_time", "stair_count", "max_slope", "min_width", "signposted_as", "reversed_signposted_as"}, addFldNames(feed.PathwaysAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Pathways) {
+		pw := feed.Pathways[id]
+		from, to := "", ""
+		if pw.FromStop != nil {
+			from = pw.FromStop.ID
+		}
+		if pw.ToStop != nil {
+			to = pw.ToStop.ID
+		}
+		e := t.write(func(f string) string { return feed.PathwaysAddFlds[f][id] },
+			pw.ID, from, to, fmtInt(int(pw.Mode)), fmtBool(pw.IsBidirectional), fmtFloat(pw.Length), fmtOptInt(pw.TraversalTime), fmtInt(pw.StairCount), fmtFloat(pw.MaxSlope), fmtFloat(pw.MinWidth), pw.SignpostedAs, pw.ReversedSignpostedAs)
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeLevels(zw *zip.Writer) error {
+	if len(feed.Levels) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "levels.txt", []string{"level_id", "level_index", "level_name"}, addFldNames(feed.LevelsAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, id := range sortedIDs(feed.Levels) {
+		l := feed.Levels[id]
+		if e := t.write(func(f string) string { return feed.LevelsAddFlds[f][id] }, l.ID, fmtFloat(l.Index), l.Name); e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeFeedInfos(zw *zip.Writer) error {
+	if len(feed.FeedInfos) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "feed_info.txt", []string{"feed_publisher_name", "feed_publisher_url", "feed_lang", "feed_start_date", "feed_end_date", "feed_version", "feed_contact_email", "feed_contact_url"}, addFldNames(feed.FeedInfosAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, fi := range feed.FeedInfos {
+		e := t.write(func(f string) string { return feed.FeedInfosAddFlds[f][fi] },
+			fi.PublisherName, fmtURL(fi.PublisherURL), fi.Lang.GetLangString(), fi.StartDate.String(), fi.EndDate.String(), fi.Version, fmtMail(fi.ContactEmail), fmtURL(fi.ContactURL))
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}
+
+func (feed *Feed) writeAttributions(zw *zip.Writer) error {
+	type attrRow struct {
+		attr                *gtfs.Attribution
+		agency, route, trip string
+	}
+
+	rows := make([]attrRow, 0)
+	for _, a := range feed.Attributions {
+		rows = append(rows, attrRow{a, "", "", ""})
+	}
+	for _, id := range sortedIDs(feed.Agencies) {
+		for _, a := range feed.Agencies[id].Attributions {
+			rows = append(rows, attrRow{a, id, "", ""})
+		}
+	}
+	for _, id := range sortedIDs(feed.Routes) {
+		for _, a := range feed.Routes[id].Attributions {
+			rows = append(rows, attrRow{a, "", id, ""})
+		}
+	}
+	for _, id := range sortedIDs(feed.Trips) {
+		if feed.Trips[id].Attributions == nil {
+			continue
+		}
+		for _, a := range *feed.Trips[id].Attributions {
+			rows = append(rows, attrRow{a, "", "", id})
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	t, e := newCsvTable(zw, "attributions.txt", []string{"attribution_id", "agency_id", "route_id", "trip_id", "organization_name", "is_producer", "is_operator", "is_authority", "attribution_url", "attribution_email", "attribution_phone"}, addFldNames(feed.AttributionsAddFlds))
+	if e != nil {
+		return e
+	}
+
+	for _, r := range rows {
+		a := r.attr
+		e := t.write(func(f string) string { return feed.AttributionsAddFlds[f][a] },
+			a.ID, r.agency, r.route, r.trip, a.OrganizationName, fmtBool(a.IsProducer), fmtBool(a.IsOperator), fmtBool(a.IsAuthority), fmtURL(a.URL), fmtMail(a.Email), a.Phone)
+		if e != nil {
+			return e
+		}
+	}
+
+	return t.close()
+}